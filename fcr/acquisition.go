@@ -0,0 +1,266 @@
+package fcr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// number of recent samples kept in the ring buffer
+const acquisitionBufferSize = 600
+
+// AcquisitionListener is called after every poll of the ECU with the current
+// ECU status and the sample read, the sample is nil if the ECU could not be read
+type AcquisitionListener func(status rosco.ECUStatus, sample *rosco.MemsData)
+
+// Acquisition polls the ECU in the background at the configured frequency
+// independently of any browser or REST client
+type Acquisition struct {
+	mutex     sync.RWMutex
+	reader    *MemsReader
+	samples   []rosco.MemsData
+	next      int
+	count     int
+	listeners []AcquisitionListener
+	stop      chan struct{}
+	connected bool
+	// started is when the current ECU session was connected
+	started time.Time
+	// received is when the latest sample was read
+	received time.Time
+	err      error
+	// skipped is true if the last poll wasn't sent because the queue was busy
+	skipped bool
+}
+
+// NewAcquisition creates the acquisition for the reader
+func NewAcquisition(reader *MemsReader) *Acquisition {
+	acquisition := &Acquisition{}
+	acquisition.reader = reader
+	acquisition.samples = make([]rosco.MemsData, acquisitionBufferSize)
+
+	return acquisition
+}
+
+// AddListener registers a listener to be notified after each poll
+func (acquisition *Acquisition) AddListener(listener AcquisitionListener) {
+	acquisition.mutex.Lock()
+	defer acquisition.mutex.Unlock()
+
+	acquisition.listeners = append(acquisition.listeners, listener)
+}
+
+// Start runs the acquisition loop
+func (acquisition *Acquisition) Start() {
+	acquisition.mutex.Lock()
+	defer acquisition.mutex.Unlock()
+
+	if acquisition.stop == nil {
+		acquisition.stop = make(chan struct{})
+		go acquisition.run(acquisition.stop)
+	}
+}
+
+// Stop ends the acquisition loop
+func (acquisition *Acquisition) Stop() {
+	acquisition.mutex.Lock()
+	defer acquisition.mutex.Unlock()
+
+	if acquisition.stop != nil {
+		close(acquisition.stop)
+		acquisition.stop = nil
+	}
+}
+
+// Latest returns the most recent sample, false if nothing has been acquired
+func (acquisition *Acquisition) Latest() (rosco.MemsData, bool) {
+	acquisition.mutex.RLock()
+	defer acquisition.mutex.RUnlock()
+
+	if acquisition.count == 0 {
+		return rosco.MemsData{}, false
+	}
+
+	i := (acquisition.next - 1 + len(acquisition.samples)) % len(acquisition.samples)
+	return acquisition.samples[i], true
+}
+
+// Current returns the latest sample if it was read in the current session no longer than
+// the age ago and the last poll succeeded, false if the sample can't be relied on
+func (acquisition *Acquisition) Current(age time.Duration) (rosco.MemsData, bool) {
+	acquisition.mutex.RLock()
+	defer acquisition.mutex.RUnlock()
+
+	if acquisition.count == 0 || acquisition.err != nil {
+		return rosco.MemsData{}, false
+	}
+
+	if acquisition.received.Before(acquisition.started) || time.Since(acquisition.received) > age {
+		return rosco.MemsData{}, false
+	}
+
+	i := (acquisition.next - 1 + len(acquisition.samples)) % len(acquisition.samples)
+	return acquisition.samples[i], true
+}
+
+// LastError returns the error from the last poll of the connected ECU, nil if the poll succeeded
+func (acquisition *Acquisition) LastError() error {
+	acquisition.mutex.RLock()
+	defer acquisition.mutex.RUnlock()
+
+	return acquisition.err
+}
+
+// Skipped returns true if the last poll was skipped because the queue was busy,
+// listeners are called after each poll so can use this to tell a skipped poll from a failed poll
+func (acquisition *Acquisition) Skipped() bool {
+	acquisition.mutex.RLock()
+	defer acquisition.mutex.RUnlock()
+
+	return acquisition.skipped
+}
+
+// Started returns when the current ECU session was connected
+func (acquisition *Acquisition) Started() time.Time {
+	acquisition.mutex.RLock()
+	defer acquisition.mutex.RUnlock()
+
+	return acquisition.started
+}
+
+// Samples returns the buffered samples, oldest first
+func (acquisition *Acquisition) Samples() []rosco.MemsData {
+	acquisition.mutex.RLock()
+	defer acquisition.mutex.RUnlock()
+
+	samples := make([]rosco.MemsData, 0, acquisition.count)
+	start := acquisition.next - acquisition.count
+
+	for i := 0; i < acquisition.count; i++ {
+		j := (start + i + len(acquisition.samples)) % len(acquisition.samples)
+		samples = append(samples, acquisition.samples[j])
+	}
+
+	return samples
+}
+
+// run polls the ECU at the configured frequency until stopped,
+// changes to the frequency are applied on the next poll
+func (acquisition *Acquisition) run(stop chan struct{}) {
+	interval := acquisition.reader.Config.getFrequency()
+	log.Infof("acquisition started polling the ecu every %v", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			log.Infof("acquisition stopped polling the ecu")
+			return
+		case <-ticker.C:
+			acquisition.poll()
+
+			if frequency := acquisition.reader.Config.getFrequency(); frequency != interval {
+				log.Infof("acquisition polling frequency changed from %v to %v", interval, frequency)
+				interval = frequency
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// poll reads the dataframes if the ECU is connected and notifies the listeners
+func (acquisition *Acquisition) poll() {
+	var sample *rosco.MemsData
+	var status rosco.ECUStatus
+	var memsdata rosco.MemsData
+
+	ecu := acquisition.reader.ECU
+	interval := acquisition.reader.Config.getFrequency()
+
+	// don't let polls queue up behind a slow command, skip the poll if it can't be sent before the next is due
+	err := acquisition.reader.Queue.Submit("dataframes", PriorityLow, interval, func() error {
+		var err error
+
+		status = *ecu.Status
+		if !status.Connected {
+			return ErrECUNotConnected
+		}
+
+		// move a scenario on to the dataframe due to be played
+		acquisition.reader.Playback.prepare(ecu, interval)
+
+		memsdata, err = ecu.GetDataframes()
+		return err
+	})
+
+	if err == ErrECUQueueTimeout {
+		// the status wasn't read, report the last known state
+		status = acquisition.reader.GetECUStatus()
+	}
+
+	if status.Connected {
+		if !acquisition.connected {
+			// new connection, discard the samples from the previous session
+			acquisition.clear()
+		}
+
+		if err == nil {
+			acquisition.add(memsdata)
+			sample = &memsdata
+		} else {
+			log.Warnf("acquisition read ecu dataframes serial comms fault (%s)", err)
+		}
+
+		acquisition.setError(err)
+	} else {
+		acquisition.setError(nil)
+	}
+
+	acquisition.connected = status.Connected
+
+	acquisition.mutex.RLock()
+	listeners := acquisition.listeners
+	acquisition.mutex.RUnlock()
+
+	for _, listener := range listeners {
+		listener(status, sample)
+	}
+}
+
+func (acquisition *Acquisition) add(memsdata rosco.MemsData) {
+	acquisition.mutex.Lock()
+	defer acquisition.mutex.Unlock()
+
+	acquisition.samples[acquisition.next] = memsdata
+	acquisition.received = time.Now()
+	acquisition.next = (acquisition.next + 1) % len(acquisition.samples)
+
+	if acquisition.count < len(acquisition.samples) {
+		acquisition.count++
+	}
+}
+
+func (acquisition *Acquisition) setError(err error) {
+	acquisition.mutex.Lock()
+	defer acquisition.mutex.Unlock()
+
+	acquisition.skipped = err == ErrECUQueueTimeout
+
+	// a skipped poll is not a fault, keep the result of the last poll
+	if err != ErrECUQueueTimeout {
+		acquisition.err = err
+	}
+}
+
+func (acquisition *Acquisition) clear() {
+	acquisition.mutex.Lock()
+	defer acquisition.mutex.Unlock()
+
+	acquisition.next = 0
+	acquisition.count = 0
+	acquisition.started = time.Now()
+}
@@ -0,0 +1,38 @@
+package fcr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusyQueueSkipsPollWithoutError(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	acquisition := webserver.reader.Acquisition
+	acquisition.poll()
+
+	if acquisition.Skipped() || acquisition.LastError() != nil {
+		t.Fatalf("expected the poll to succeed, got skipped %t (%v)", acquisition.Skipped(), acquisition.LastError())
+	}
+
+	// hold the queue for longer than the polling interval
+	interval := webserver.reader.Config.getFrequency()
+	go func() {
+		_ = webserver.reader.Queue.Submit("busy", PriorityHigh, time.Second, func() error {
+			time.Sleep(interval * 2)
+			return nil
+		})
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	acquisition.poll()
+
+	if !acquisition.Skipped() {
+		t.Errorf("expected the poll to be skipped")
+	}
+
+	if err := acquisition.LastError(); err != nil {
+		t.Errorf("expected a skipped poll not to be an error, got %s", err)
+	}
+}
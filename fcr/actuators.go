@@ -0,0 +1,333 @@
+package fcr
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownActuator the actuator is not one of the ECU actuators
+var ErrUnknownActuator = errors.New("unknown actuator")
+
+// ErrEngineRunning the actuator can't be tested while the engine is turning
+var ErrEngineRunning = errors.New("the engine must be stopped (0 rpm) to test this actuator")
+
+// ErrEngineRPMUnknown the rpm could not be read to check the engine is stopped
+var ErrEngineRPMUnknown = errors.New("unable to check the engine is stopped, the rpm could not be read")
+
+// maximum time each actuator is left on before the server switches it off
+const (
+	relayMaxOnTime = time.Second * 10
+	valveMaxOnTime = time.Second * 10
+	fanMaxOnTime   = time.Second * 30
+)
+
+// interval between attempts to switch off an actuator that has been on too long
+const actuatorRetryInterval = time.Second
+
+// ActuatorState is the server side state of an actuator test
+type ActuatorState struct {
+	Actuator string `json:"actuator"`
+	Active   bool   `json:"active"`
+	// Switched is when the actuator was last switched on or off
+	Switched time.Time `json:"switched"`
+	// MaxOnTime is how long the actuator is left on before it is switched off, 0 if momentary
+	MaxOnTime time.Duration `json:"maxOnTime"`
+	// Error is the last failure switching the actuator
+	Error string `json:"error,omitempty"`
+}
+
+// actuator switches a single ECU actuator
+type actuator struct {
+	activate func(activate bool) error
+	// momentary actuators are fired once by the ECU, sending the
+	// deactivate command fires them again so they are never switched off
+	momentary bool
+	// the engine must be stopped to test the actuator
+	engineStopped bool
+	state         ActuatorState
+	timer         *time.Timer
+	// activation identifies the current activation so an expired timer doesn't switch off a later one
+	activation int
+}
+
+// Actuators tracks the state of the actuator tests and switches off actuators left on too long
+type Actuators struct {
+	mutex     sync.Mutex
+	reader    *MemsReader
+	actuators map[string]*actuator
+	names     []string
+}
+
+// NewActuators creates the actuator tests for the reader's ECU
+func NewActuators(reader *MemsReader) *Actuators {
+	actuators := &Actuators{}
+	actuators.reader = reader
+	actuators.actuators = make(map[string]*actuator)
+
+	ecu := reader.ECU
+
+	actuators.add(ActuatorFuelPump, ecu.TestFuelPump, relayMaxOnTime, false)
+	actuators.add(ActuatorPTC, ecu.TestPTCRelay, relayMaxOnTime, false)
+	actuators.add(ActuatorAircon, ecu.TestACRelay, relayMaxOnTime, false)
+	actuators.add(ActuatorPurgeValve, ecu.TestPurgeValve, valveMaxOnTime, false)
+	actuators.add(ActuatorBoostValve, ecu.TestBoostValve, valveMaxOnTime, false)
+	actuators.add(ActuatorFan1, ecu.TestFan1, fanMaxOnTime, false)
+	actuators.add(ActuatorFan2, ecu.TestFan2, fanMaxOnTime, false)
+	actuators.add(ActuatorInjectors, ecu.TestInjectors, 0, true)
+	actuators.add(ActuatorCoil, ecu.TestCoil, 0, true)
+
+	return actuators
+}
+
+func (actuators *Actuators) add(name string, activate func(activate bool) error, maxOnTime time.Duration, engineStopped bool) {
+	actuators.actuators[name] = &actuator{
+		activate:      activate,
+		momentary:     maxOnTime == 0,
+		engineStopped: engineStopped,
+		state:         ActuatorState{Actuator: name, MaxOnTime: maxOnTime},
+	}
+
+	actuators.names = append(actuators.names, name)
+}
+
+// Activate switches the actuator on or off for the client, actuators switched on are
+// switched off by the server after their maximum on time
+func (actuators *Actuators) Activate(client string, name string, on bool) error {
+	actuators.mutex.Lock()
+	a, ok := actuators.actuators[name]
+	actuators.mutex.Unlock()
+
+	if !ok {
+		return ErrUnknownActuator
+	}
+
+	if !on && a.momentary {
+		// nothing to switch off
+		actuators.setState(a, false, nil)
+		return nil
+	}
+
+	state, _ := actuators.State(name)
+	entry := AuditEntry{Client: client, Command: "test " + name, Request: on, Before: state.Active}
+
+	// the rpm is read when the actuator is activated if the latest dataframe can't be relied on
+	check := on && a.engineStopped
+	if check {
+		checked, err := actuators.checkEngineStopped()
+		if err != nil {
+			actuators.setState(a, false, err)
+			actuators.reader.audit(entry, err)
+			return err
+		}
+
+		check = !checked
+	}
+
+	// switching actuators off takes priority over other commands
+	priority := PriorityNormal
+	if !on {
+		priority = PriorityHigh
+	}
+
+	err := actuators.reader.Queue.Submit("test "+name, priority, defaultCommandTimeout, func() error {
+		if check {
+			if err := actuators.readEngineStopped(); err != nil {
+				return err
+			}
+		}
+
+		return a.activate(on)
+	})
+
+	actuators.setState(a, on && !a.momentary, err)
+
+	state, _ = actuators.State(name)
+	entry.After = state.Active
+	actuators.reader.audit(entry, err)
+
+	return err
+}
+
+// AllOff switches off every actuator that can be switched off, whether or not the
+// server switched it on, returns the first failure
+func (actuators *Actuators) AllOff(client string) error {
+	var failed error
+
+	for _, name := range actuators.names {
+		if err := actuators.Activate(client, name, false); err != nil {
+			log.Warnf("unable to switch off %s (%s)", name, err)
+
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+
+	return failed
+}
+
+// States returns the state of each actuator
+func (actuators *Actuators) States() []ActuatorState {
+	actuators.mutex.Lock()
+	defer actuators.mutex.Unlock()
+
+	states := make([]ActuatorState, 0, len(actuators.names))
+	for _, name := range actuators.names {
+		states = append(states, actuators.actuators[name].state)
+	}
+
+	return states
+}
+
+// State returns the state of the actuator
+func (actuators *Actuators) State(name string) (ActuatorState, error) {
+	actuators.mutex.Lock()
+	defer actuators.mutex.Unlock()
+
+	a, ok := actuators.actuators[name]
+	if !ok {
+		return ActuatorState{}, ErrUnknownActuator
+	}
+
+	return a.state, nil
+}
+
+// Reset marks every actuator as off without sending commands to the ECU,
+// used when the ECU is disconnected
+func (actuators *Actuators) Reset() {
+	actuators.mutex.Lock()
+	defer actuators.mutex.Unlock()
+
+	for _, a := range actuators.actuators {
+		a.activation++
+		a.stopTimer()
+
+		if a.state.Active {
+			a.state.Active = false
+			a.state.Switched = time.Now()
+		}
+	}
+}
+
+// IsActive returns true if any actuator is switched on
+func (actuators *Actuators) IsActive() bool {
+	actuators.mutex.Lock()
+	defer actuators.mutex.Unlock()
+
+	for _, a := range actuators.actuators {
+		if a.state.Active {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setState records the result of switching the actuator, the state is
+// unchanged if the command failed
+func (actuators *Actuators) setState(a *actuator, active bool, err error) {
+	actuators.mutex.Lock()
+	defer actuators.mutex.Unlock()
+
+	if err != nil {
+		a.state.Error = err.Error()
+		log.Warnf("actuator %s failed to switch (%s)", a.state.Actuator, err)
+		return
+	}
+
+	a.activation++
+	a.stopTimer()
+
+	a.state.Active = active
+	a.state.Switched = time.Now()
+	a.state.Error = ""
+
+	if active {
+		log.Infof("actuator %s switched on for up to %v", a.state.Actuator, a.state.MaxOnTime)
+	} else {
+		log.Infof("actuator %s switched off", a.state.Actuator)
+	}
+
+	if active {
+		activation := a.activation
+		name := a.state.Actuator
+
+		a.timer = time.AfterFunc(a.state.MaxOnTime, func() {
+			actuators.expire(name, activation)
+		})
+	}
+}
+
+// expire switches off the actuator if it is still on from the same activation
+func (actuators *Actuators) expire(name string, activation int) {
+	actuators.mutex.Lock()
+	a := actuators.actuators[name]
+	current := a.activation == activation && a.state.Active
+	actuators.mutex.Unlock()
+
+	if !current {
+		return
+	}
+
+	log.Warnf("%s has been on for %v, switching it off", name, a.state.MaxOnTime)
+
+	err := actuators.Activate(auditClientServer, name, false)
+
+	switch err {
+	case nil:
+	case ErrECUNotConnected, ErrECUQueueClosed:
+		log.Errorf("unable to switch off %s (%s)", name, err)
+	default:
+		// keep trying, the actuator must not be left on
+		log.Errorf("unable to switch off %s (%s), retrying", name, err)
+
+		actuators.mutex.Lock()
+		if a.activation == activation {
+			a.timer = time.AfterFunc(actuatorRetryInterval, func() {
+				actuators.expire(name, activation)
+			})
+		}
+		actuators.mutex.Unlock()
+	}
+}
+
+// checkEngineStopped uses the latest dataframe to check the engine is not turning, the dataframe
+// is only used if it was read in this session within two polls, returns false if it wasn't checked
+func (actuators *Actuators) checkEngineStopped() (bool, error) {
+	memsdata, ok := actuators.reader.Acquisition.Current(actuators.reader.Config.getFrequency() * 2)
+
+	if !ok {
+		return false, nil
+	}
+
+	if memsdata.EngineRPM != 0 {
+		return true, ErrEngineRunning
+	}
+
+	return true, nil
+}
+
+// readEngineStopped reads the rpm from the ECU to check the engine is not turning, run this from a queued command
+func (actuators *Actuators) readEngineStopped() error {
+	df80, _, err := actuators.reader.readDataframes()
+	if err != nil {
+		log.Warnf("unable to read the engine rpm (%s)", err)
+		return ErrEngineRPMUnknown
+	}
+
+	if df80.EngineRpm != 0 {
+		return ErrEngineRunning
+	}
+
+	return nil
+}
+
+func (a *actuator) stopTimer() {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+}
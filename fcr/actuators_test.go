@@ -0,0 +1,168 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestActuatorSwitchedOffAfterMaxOnTime(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	actuators := webserver.reader.Actuators
+	actuators.actuators[ActuatorFuelPump].state.MaxOnTime = time.Millisecond * 50
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/fuelpump", `{"activate":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if state, _ := actuators.State(ActuatorFuelPump); !state.Active {
+		t.Fatalf("expected the fuel pump to be on")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for actuators.IsActive() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if state, _ := actuators.State(ActuatorFuelPump); state.Active {
+		t.Errorf("expected the fuel pump to be switched off after %v", state.MaxOnTime)
+	}
+}
+
+func TestAllActuatorsOff(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	for _, actuator := range []string{"fan1", "purgevalve"} {
+		w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/"+actuator, `{"activate":true}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+		}
+	}
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/off", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if webserver.reader.Actuators.IsActive() {
+		t.Errorf("expected all the actuators to be off")
+	}
+}
+
+func TestInjectorsRefusedWithoutEngineRPM(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	// no dataframes have been read so the rpm is read from the test scenario, which is running
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/injectors", `{"activate":true}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeInterlock)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/coil", `{"activate":true}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeInterlock)
+}
+
+func TestInjectorsRefusedWhileEngineRunning(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	memsdata, _ := webserver.reader.ECU.GetDataframes()
+	memsdata.EngineRPM = 850
+	webserver.reader.Acquisition.add(memsdata)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/injectors", `{"activate":true}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeInterlock)
+
+	memsdata.EngineRPM = 0
+	webserver.reader.Acquisition.add(memsdata)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/injectors", `{"activate":true}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with the engine stopped, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if webserver.reader.Actuators.IsActive() {
+		t.Errorf("expected the injectors to be momentary")
+	}
+}
+
+func TestInjectorsRefusedWithStaleEngineRPM(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	acquisition := webserver.reader.Acquisition
+
+	memsdata, _ := webserver.reader.ECU.GetDataframes()
+	memsdata.EngineRPM = 0
+
+	// a stopped engine read too long ago isn't relied on, the test scenario is running
+	acquisition.add(memsdata)
+	acquisition.received = time.Now().Add(-time.Minute)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/injectors", `{"activate":true}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeInterlock)
+
+	// nor is a stopped engine when the last poll failed
+	acquisition.add(memsdata)
+	acquisition.setError(ErrECUNotConnected)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/coil", `{"activate":true}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeInterlock)
+
+	// nor is a stopped engine from before the ecu was reconnected
+	acquisition.setError(nil)
+	acquisition.add(memsdata)
+
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/disconnect", "")
+	connectTestScenario(t, webserver)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/injectors", `{"activate":true}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeInterlock)
+
+	if position := webserver.reader.ECU.Responder.Playbook.Position; position != 0 {
+		t.Errorf("expected reading the rpm not to move the scenario on, got position %d", position)
+	}
+}
+
+func TestActuatorStates(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/fan2", `{"activate":true}`)
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/coil", `{"activate":true}`)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/rosco/test", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var states []ActuatorState
+	if err := json.Unmarshal(w.Body.Bytes(), &states); err != nil {
+		t.Fatalf("unable to decode the actuator states (%s)", err)
+	}
+
+	if len(states) != 9 {
+		t.Fatalf("expected 9 actuators, got %d", len(states))
+	}
+
+	for _, state := range states {
+		switch state.Actuator {
+		case ActuatorFan2:
+			if !state.Active || state.Switched.IsZero() {
+				t.Errorf("expected fan 2 to be on, got %+v", state)
+			}
+		case ActuatorCoil:
+			if state.Active || state.Error == "" {
+				t.Errorf("expected the coil to be off with the interlock error, got %+v", state)
+			}
+		default:
+			if state.Active {
+				t.Errorf("expected %s to be off", state.Actuator)
+			}
+		}
+	}
+}
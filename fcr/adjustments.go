@@ -0,0 +1,481 @@
+package fcr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownAdjustment the adjustment is not one of the ECU adjustable values
+var ErrUnknownAdjustment = errors.New("unknown adjustment")
+
+// ErrTargetOutOfRange the target is outside the limits of the ECU
+var ErrTargetOutOfRange = errors.New("target is outside the ecu limits")
+
+// ErrNoSnapshot no snapshot of the adjustable values has been taken
+var ErrNoSnapshot = errors.New("no snapshot of the adjustable values has been taken")
+
+// ErrSnapshotECU the snapshot was taken from a different ECU
+var ErrSnapshotECU = errors.New("the snapshot was taken from a different ecu")
+
+// ErrNoDataframes the adjustable values could not be read from the ECU
+var ErrNoDataframes = errors.New("unable to read the adjustable values from the ecu")
+
+// the snapshot of the adjustable values in the log folder
+const snapshotFile = "adjustments.snapshot.json"
+
+// the most steps taken to reach a target, enough to cover the full range of the fuel trims
+const maxAdjustmentSteps = 255
+
+// the offsets taken from the raw dataframe bytes to give the adjustable values shown in the dataframe
+const (
+	stftOffset            = 100
+	ltftOffset            = 128
+	ignitionAdvanceOffset = 48
+)
+
+// the ECU reports the ignition advance offset and idle speed about these values when they are stepped,
+// the dataframe values are offsets from the centre
+const (
+	steppedIgnitionAdvanceCentre = 0x80
+	steppedIdleSpeedCentre       = 0x80
+)
+
+// Adjustments are the adjustable values in the order they are restored
+var Adjustments = []string{AdjustmentSTFT, AdjustmentLTFT, AdjustmentIdleDecay, AdjustmentIdleSpeed, AdjustmentIgnitionAdvance, AdjustmentIAC}
+
+// AdjustmentSnapshot records the adjustable values of an ECU
+type AdjustmentSnapshot struct {
+	Time   time.Time      `json:"time"`
+	ECUID  string         `json:"ecuId"`
+	Values map[string]int `json:"values"`
+}
+
+// AdjustmentResult reports how close stepping an adjustment got to the target value
+type AdjustmentResult struct {
+	Adjustment string `json:"adjustment"`
+	Target     int    `json:"target"`
+	// Initial is the value before stepping
+	Initial int `json:"initial"`
+	// Value is the final value read from the ECU
+	Value int `json:"value"`
+	// Steps is the number of steps sent to the ECU
+	Steps int `json:"steps"`
+	// Difference is the target less the final value, 0 if the target was reached
+	Difference int    `json:"difference"`
+	Reached    bool   `json:"reached"`
+	Error      string `json:"error,omitempty"`
+	// Read is true once the initial value has been read from the ECU
+	Read bool `json:"-"`
+}
+
+// getAdjustableValues returns the adjustable values shown in the dataframe
+func getAdjustableValues(memsdata rosco.MemsData) map[string]int {
+	return map[string]int{
+		AdjustmentSTFT:            memsdata.ShortTermFuelTrim,
+		AdjustmentLTFT:            memsdata.LongTermFuelTrim,
+		AdjustmentIdleDecay:       memsdata.IdleHot,
+		AdjustmentIdleSpeed:       memsdata.IdleSpeedOffset,
+		AdjustmentIgnitionAdvance: memsdata.IgnitionAdvanceOffset7d,
+		AdjustmentIAC:             memsdata.IACPosition,
+	}
+}
+
+// getDataframeAdjustableValues returns the adjustable values in the raw dataframes,
+// scaled in the same way as the values in the dataframe
+func getDataframeAdjustableValues(df80 rosco.DataFrame80, df7d rosco.DataFrame7d) map[string]int {
+	return map[string]int{
+		AdjustmentSTFT:            int(df7d.ShortTermFuelTrim) - stftOffset,
+		AdjustmentLTFT:            int(df7d.LongTermFuelTrim) - ltftOffset,
+		AdjustmentIdleDecay:       int(df80.IdleHot),
+		AdjustmentIdleSpeed:       int(df7d.IdleSpeedOffset),
+		AdjustmentIgnitionAdvance: int(df7d.IgnitionAdvanceOffset7d) - ignitionAdvanceOffset,
+		AdjustmentIAC:             int(df80.IacPosition),
+	}
+}
+
+// readAdjustableValues reads the dataframes and returns the adjustable values,
+// nil if the dataframes could not be read. This must be called from an ECU command.
+// The dataframes are read without logging them so writes don't add dataframes to the log.
+func (reader *MemsReader) readAdjustableValues() map[string]int {
+	df80, df7d, err := reader.readDataframes()
+	if err != nil {
+		log.Warnf("unable to read the adjustable values (%s)", err)
+		return nil
+	}
+
+	return getDataframeAdjustableValues(df80, df7d)
+}
+
+// readFaultCodes reads the dataframes and returns the fault code bytes,
+// nil if the dataframes could not be read. This must be called from an ECU command.
+func (reader *MemsReader) readFaultCodes() map[string]uint8 {
+	df80, df7d, err := reader.readDataframes()
+	if err != nil {
+		log.Warnf("unable to read the fault codes (%s)", err)
+		return nil
+	}
+
+	return map[string]uint8{
+		"DTC0": df80.Dtc0,
+		"DTC1": df80.Dtc1,
+		"DTC2": df7d.Dtc2,
+		"DTC3": df7d.Dtc3,
+		"DTC4": df7d.Dtc4,
+		"DTC5": df7d.Dtc5,
+	}
+}
+
+// auditAdjustableValues reads the adjustable values for the audit log, nil if they couldn't be read
+func (reader *MemsReader) auditAdjustableValues() interface{} {
+	if values := reader.readAdjustableValues(); values != nil {
+		return values
+	}
+
+	return nil
+}
+
+// auditFaultCodes reads the fault codes for the audit log, nil if they couldn't be read
+func (reader *MemsReader) auditFaultCodes() interface{} {
+	if faults := reader.readFaultCodes(); faults != nil {
+		return faults
+	}
+
+	return nil
+}
+
+// audit records the command in the audit log along with the connected ECU
+func (reader *MemsReader) audit(entry AuditEntry, err error) {
+	entry.ECUID = reader.GetECUStatus().ECUID
+	reader.Audit.Record(entry, err)
+}
+
+// getAdjustFunction returns the ECU function that steps the adjustment
+func (reader *MemsReader) getAdjustFunction(adjustment string) (func(steps int) (int, error), error) {
+	switch adjustment {
+	case AdjustmentSTFT:
+		return reader.ECU.AdjustShortTermFuelTrim, nil
+	case AdjustmentLTFT:
+		return reader.ECU.AdjustLongTermFuelTrim, nil
+	case AdjustmentIdleDecay:
+		return reader.ECU.AdjustIdleDecay, nil
+	case AdjustmentIdleSpeed:
+		return reader.ECU.AdjustIdleSpeed, nil
+	case AdjustmentIgnitionAdvance:
+		return reader.ECU.AdjustIgnitionAdvanceOffset, nil
+	case AdjustmentIAC:
+		return reader.ECU.AdjustIACPosition, nil
+	}
+
+	return nil, ErrUnknownAdjustment
+}
+
+// getAdjustmentLimits returns the range of the adjustable value as it is shown in the dataframe
+func getAdjustmentLimits(adjustment string) (int, int, error) {
+	switch adjustment {
+	case AdjustmentSTFT:
+		return rosco.MEMSFuelTrimMin - stftOffset, rosco.MEMSFuelTrimMax - stftOffset, nil
+	case AdjustmentLTFT:
+		return rosco.MEMSFuelTrimMin - ltftOffset, rosco.MEMSFuelTrimMax - ltftOffset, nil
+	case AdjustmentIdleDecay:
+		return rosco.MEMSIdleDecayMin, rosco.MEMSIdleDecayMax, nil
+	case AdjustmentIdleSpeed:
+		return rosco.MEMSIdleSpeedMin - steppedIdleSpeedCentre, rosco.MEMSIdleSpeedMax - steppedIdleSpeedCentre, nil
+	case AdjustmentIgnitionAdvance:
+		return rosco.MEMSIgnitionAdvanceOffsetMin - steppedIgnitionAdvanceCentre, rosco.MEMSIgnitionAdvanceOffsetMax - steppedIgnitionAdvanceCentre, nil
+	case AdjustmentIAC:
+		return 0, 0xff, nil
+	}
+
+	return 0, 0, ErrUnknownAdjustment
+}
+
+// AdjustToTarget steps the adjustment until the value read from the ECU reaches the target,
+// the target is in the same units as the value shown in the dataframe and the snapshot
+func (reader *MemsReader) AdjustToTarget(client string, adjustment string, target int) (AdjustmentResult, error) {
+	result := AdjustmentResult{Adjustment: adjustment, Target: target}
+
+	min, max, err := getAdjustmentLimits(adjustment)
+	if err != nil {
+		return result, err
+	}
+
+	if target < min || target > max {
+		return result, fmt.Errorf("%w, %s must be between %d and %d", ErrTargetOutOfRange, adjustment, min, max)
+	}
+
+	result, err = reader.StepToTarget(adjustment, target)
+	reader.auditAdjustment(client, "adjust "+adjustment, map[string]int{"target": target}, result, err)
+
+	return result, err
+}
+
+// AdjustBySteps steps the adjustment, refusing steps that would take the value further outside the
+// ECU limits, the values read before and after the adjustment are recorded in the audit log
+func (reader *MemsReader) AdjustBySteps(client string, adjustment string, steps int) (int, error) {
+	var value int
+
+	adjust, err := reader.getAdjustFunction(adjustment)
+	if err != nil {
+		return value, err
+	}
+
+	min, max, err := getAdjustmentLimits(adjustment)
+	if err != nil {
+		return value, err
+	}
+
+	entry := AuditEntry{Client: client, Command: "adjust " + adjustment, Request: steps}
+
+	err = reader.Queue.Submit("adjust "+adjustment, PriorityNormal, defaultCommandTimeout, func() error {
+		var err error
+
+		if values := reader.readAdjustableValues(); values != nil {
+			entry.Before = values[adjustment]
+
+			if next := values[adjustment] + steps; (next > max && steps > 0) || (next < min && steps < 0) {
+				return fmt.Errorf("%w, %s must be between %d and %d", ErrTargetOutOfRange, adjustment, min, max)
+			}
+		}
+
+		if value, err = adjust(steps); err != nil {
+			return err
+		}
+
+		if values := reader.readAdjustableValues(); values != nil {
+			entry.After = values[adjustment]
+		}
+
+		return nil
+	})
+
+	reader.audit(entry, err)
+
+	return value, err
+}
+
+// auditAdjustment records stepping the adjustment towards a target with the values before and after,
+// the values are left out if the adjustment couldn't be read
+func (reader *MemsReader) auditAdjustment(client string, command string, request interface{}, result AdjustmentResult, err error) {
+	entry := AuditEntry{Client: client, Command: command, Request: request}
+
+	if result.Read {
+		entry.Before = result.Initial
+		entry.After = result.Value
+	}
+
+	reader.audit(entry, err)
+}
+
+// getAdjustableValue reads the current value of the adjustment from the ECU
+func (reader *MemsReader) getAdjustableValue(adjustment string) (int, error) {
+	var values map[string]int
+
+	err := reader.Queue.Submit("read "+adjustment, PriorityNormal, defaultCommandTimeout, func() error {
+		if values = reader.readAdjustableValues(); values == nil {
+			return ErrNoDataframes
+		}
+
+		return nil
+	})
+
+	return values[adjustment], err
+}
+
+// stepAdjustment sends a single step to the ECU and re-reads the value of the adjustment
+func (reader *MemsReader) stepAdjustment(adjustment string, adjust func(steps int) (int, error), step int) (int, error) {
+	var values map[string]int
+
+	err := reader.Queue.Submit("adjust "+adjustment, PriorityNormal, defaultCommandTimeout, func() error {
+		if _, err := adjust(step); err != nil {
+			return err
+		}
+
+		if values = reader.readAdjustableValues(); values == nil {
+			return ErrNoDataframes
+		}
+
+		return nil
+	})
+
+	return values[adjustment], err
+}
+
+// StepToTarget steps the adjustment towards the target, re-reading the value after each step
+func (reader *MemsReader) StepToTarget(adjustment string, target int) (AdjustmentResult, error) {
+	adjust, err := reader.getAdjustFunction(adjustment)
+	if err != nil {
+		return AdjustmentResult{Adjustment: adjustment, Target: target}, err
+	}
+
+	value, err := reader.getAdjustableValue(adjustment)
+	if err != nil {
+		return AdjustmentResult{Adjustment: adjustment, Target: target}, err
+	}
+
+	return stepToTarget(adjustment, value, target, func(step int) (int, error) {
+		return reader.stepAdjustment(adjustment, adjust, step)
+	})
+}
+
+// stepToTarget steps from the value towards the target one step at a time. Stepping stops when the
+// target is reached, when a step doesn't change the value because the ECU limit has been reached,
+// or when a step takes the value past the target, stepping back if that is closer.
+func stepToTarget(adjustment string, value int, target int, adjust func(step int) (int, error)) (AdjustmentResult, error) {
+	result := AdjustmentResult{Adjustment: adjustment, Target: target, Initial: value, Read: true}
+
+	var err error
+
+	for result.Steps < maxAdjustmentSteps && value != target {
+		step := 1
+		if target < value {
+			step = -1
+		}
+
+		var next int
+		if next, err = adjust(step); err != nil {
+			break
+		}
+
+		result.Steps++
+
+		if next == value {
+			log.Warnf("%s stopped changing at %d, the ecu limit has been reached", adjustment, value)
+			break
+		}
+
+		if (target-next)*step < 0 {
+			// overshot the target, step back if the previous value was closer
+			if abs(target-next) > abs(target-value) {
+				var back int
+				if back, err = adjust(-step); err == nil {
+					result.Steps++
+					next = back
+				}
+			}
+
+			value = next
+			break
+		}
+
+		value = next
+	}
+
+	result.Value = value
+	result.Difference = target - value
+	result.Reached = value == target
+
+	return result, err
+}
+
+// TakeSnapshot reads the adjustable values from the ECU and saves them
+// so they can be restored
+func (reader *MemsReader) TakeSnapshot() (AdjustmentSnapshot, error) {
+	var values map[string]int
+
+	err := reader.Queue.Submit("snapshot adjustments", PriorityNormal, defaultCommandTimeout, func() error {
+		if values = reader.readAdjustableValues(); values == nil {
+			return ErrNoDataframes
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return AdjustmentSnapshot{}, err
+	}
+
+	snapshot := AdjustmentSnapshot{Time: time.Now(), ECUID: reader.GetECUStatus().ECUID, Values: values}
+
+	return snapshot, writeSnapshot(snapshot)
+}
+
+// RestoreSnapshot steps each adjustable value back to the snapshot, returns how close each value got
+func (reader *MemsReader) RestoreSnapshot(client string) (AdjustmentSnapshot, []AdjustmentResult, error) {
+	snapshot, err := readSnapshot()
+	if err != nil {
+		return snapshot, nil, err
+	}
+
+	if snapshot.ECUID != reader.GetECUStatus().ECUID {
+		return snapshot, nil, ErrSnapshotECU
+	}
+
+	results := []AdjustmentResult{}
+
+	for _, adjustment := range Adjustments {
+		target, ok := snapshot.Values[adjustment]
+		if !ok {
+			continue
+		}
+
+		result, err := reader.StepToTarget(adjustment, target)
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		reader.auditAdjustment(client, "restore "+adjustment, target, result, err)
+
+		results = append(results, result)
+	}
+
+	return snapshot, results, nil
+}
+
+func getSnapshotFilename() string {
+	return filepath.Join(rosco.GetLogFolder(), snapshotFile)
+}
+
+func writeSnapshot(snapshot AdjustmentSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := getSnapshotFilename()
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err == nil {
+		err = ioutil.WriteFile(filename, data, 0644)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to save the snapshot (%s)", err)
+	}
+
+	log.Infof("saved snapshot of the adjustable values %v", snapshot.Values)
+
+	return nil
+}
+
+func readSnapshot() (AdjustmentSnapshot, error) {
+	var snapshot AdjustmentSnapshot
+
+	data, err := ioutil.ReadFile(getSnapshotFilename())
+	if os.IsNotExist(err) {
+		return snapshot, ErrNoSnapshot
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &snapshot)
+	}
+
+	if err != nil {
+		return snapshot, fmt.Errorf("unable to read the snapshot (%s)", err)
+	}
+
+	return snapshot, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
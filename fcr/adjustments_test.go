@@ -0,0 +1,180 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// testAdjustment simulates an adjustable value that changes by increment each step within the limits
+func testAdjustment(value *int, increment int, min int, max int) func(step int) (int, error) {
+	return func(step int) (int, error) {
+		next := *value + step*increment
+		if next >= min && next <= max {
+			*value = next
+		}
+
+		return *value, nil
+	}
+}
+
+func TestStepToTarget(t *testing.T) {
+	value := 10
+	result, err := stepToTarget(AdjustmentIdleDecay, value, 15, testAdjustment(&value, 1, 0, 100))
+
+	if err != nil || !result.Reached || result.Value != 15 || result.Steps != 5 {
+		t.Errorf("expected the target to be reached in 5 steps, got %+v (%v)", result, err)
+	}
+
+	result, _ = stepToTarget(AdjustmentIdleDecay, value, 5, testAdjustment(&value, 1, 0, 100))
+
+	if !result.Reached || result.Value != 5 || result.Steps != 10 {
+		t.Errorf("expected the target to be reached in 10 steps, got %+v", result)
+	}
+}
+
+func TestStepToTargetLimit(t *testing.T) {
+	value := 10
+	result, err := stepToTarget(AdjustmentIdleSpeed, value, 20, testAdjustment(&value, 1, 0, 12))
+
+	if err != nil || result.Reached || result.Value != 12 || result.Difference != 8 {
+		t.Errorf("expected stepping to stop at the limit, got %+v (%v)", result, err)
+	}
+}
+
+func TestStepToTargetOvershoot(t *testing.T) {
+	value := 0
+	result, _ := stepToTarget(AdjustmentSTFT, value, 8, testAdjustment(&value, 3, -100, 100))
+
+	// 0, 3, 6, 9 is closer than 6
+	if result.Reached || result.Value != 9 || result.Difference != -1 || result.Steps != 3 {
+		t.Errorf("expected the closest value to the target, got %+v", result)
+	}
+
+	value = 0
+	result, _ = stepToTarget(AdjustmentSTFT, value, 7, testAdjustment(&value, 3, -100, 100))
+
+	// 9 is further than 6 so step back
+	if result.Value != 6 || result.Steps != 4 || value != 6 {
+		t.Errorf("expected to step back to the closest value, got %+v", result)
+	}
+}
+
+func TestRestoreWithoutSnapshot(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/restore", "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/snapshot", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	snapshot, err := readSnapshot()
+	if err != nil || len(snapshot.Values) != len(Adjustments) || snapshot.ECUID == "" {
+		t.Fatalf("expected the snapshot to be saved, got %+v (%v)", snapshot, err)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/restore", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	snapshot.ECUID = "00000000"
+	if err := writeSnapshot(snapshot); err != nil {
+		t.Fatalf("unable to write the snapshot (%s)", err)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/restore", "")
+	expectError(t, w, http.StatusConflict, ErrorCodeECUMismatch)
+}
+
+func TestAdjustTargetOutOfRange(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/idlespeed", `{"target":500}`)
+	response := expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	if response.Errors["target"] == "" {
+		t.Errorf("expected the target to be invalid, got %v", response.Errors)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/idlespeed", `{"target":0,"steps":2}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+}
+
+func TestAdjustStepsOutOfRange(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/stft", `{"steps":500}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	entries, _ := webserver.reader.Audit.Read(AuditFilter{})
+	if len(entries) != 1 || entries[0].Result == AuditResultOK || entries[0].Before == nil {
+		t.Errorf("expected the refused adjustment in the audit log, got %+v", entries)
+	}
+}
+
+func TestIdleSpeedLimitsFromDataframe(t *testing.T) {
+	// the log files record an idle speed offset of 0 in the 0x7d dataframe
+	value := getDataframeAdjustableValues(rosco.DataFrame80{}, rosco.DataFrame7d{IdleSpeedOffset: 0})[AdjustmentIdleSpeed]
+
+	min, max, _ := getAdjustmentLimits(AdjustmentIdleSpeed)
+	if value-1 < min || value+1 > max {
+		t.Fatalf("expected the idle speed %d to be stepped either way within %d to %d", value, min, max)
+	}
+
+	for _, target := range []int{value - 2, value + 2} {
+		current := value
+		result, err := stepToTarget(AdjustmentIdleSpeed, current, target, testAdjustment(&current, 1, min, max))
+
+		if err != nil || !result.Reached || result.Steps != 2 {
+			t.Errorf("expected the idle speed to reach %d in 2 steps, got %+v (%v)", target, result, err)
+		}
+	}
+}
+
+func TestAdjustToTarget(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/idledecay", `{"target":35}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var response AdjustmentResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unable to decode the response (%s)", err)
+	}
+
+	if response.Target == nil || *response.Target != 35 || response.Steps < 1 {
+		t.Errorf("expected the target and steps in the response, got %+v", response)
+	}
+
+	entries, _ := webserver.reader.Audit.Read(AuditFilter{})
+	if len(entries) != 1 || entries[0].Before == nil || entries[0].After == nil {
+		t.Fatalf("expected the values before and after in the audit entry, got %+v", entries)
+	}
+
+	// the target is in the units shown in the dataframe, no steps are sent if it has been reached
+	value, err := webserver.reader.getAdjustableValue(AdjustmentSTFT)
+	if err != nil {
+		t.Fatalf("unable to read the short term fuel trim (%s)", err)
+	}
+
+	result, err := webserver.reader.AdjustToTarget("test", AdjustmentSTFT, value)
+	if err != nil || result.Steps != 0 || !result.Reached || result.Initial != value {
+		t.Errorf("expected no steps to reach %d, got %+v (%v)", value, result, err)
+	}
+}
@@ -0,0 +1,179 @@
+package fcr
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// the audit file in the log folder
+const auditFile = "audit.jsonl"
+
+// auditClientServer is the client recorded for the commands the server sends itself
+const auditClientServer = "memsfcr"
+
+// the result of an audited command
+const (
+	AuditResultOK     = "ok"
+	AuditResultFailed = "failed"
+)
+
+// AuditEntry records a command that changed the state of the ECU
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Client is the address of the client that sent the command
+	Client  string `json:"client"`
+	Command string `json:"command"`
+	// Request is the steps or activation requested
+	Request interface{} `json:"request,omitempty"`
+	// Before and After are the values read from the ECU before and after the command
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+	Result string      `json:"result"`
+	Error  string      `json:"error,omitempty"`
+	// ECUID identifies the ECU the command was sent to
+	ECUID string `json:"ecuId,omitempty"`
+}
+
+// AuditFilter selects the audit entries to return, empty fields match every entry
+type AuditFilter struct {
+	From time.Time
+	To   time.Time
+	// Command matches commands starting with the text, e.g. adjust or test fuelpump
+	Command string
+	Client  string
+	Result  string
+	ECUID   string
+	// Limit returns the most recent entries, 0 returns every entry
+	Limit int
+}
+
+// AuditLog appends the audit entries to a file, entries are never changed or removed
+type AuditLog struct {
+	mutex    sync.Mutex
+	filename string
+}
+
+// NewAuditLog creates the audit log in the log folder
+func NewAuditLog() *AuditLog {
+	audit := &AuditLog{}
+	audit.filename = filepath.Join(rosco.GetLogFolder(), auditFile)
+
+	return audit
+}
+
+// Record appends the entry to the audit file, the result is set from the error
+func (audit *AuditLog) Record(entry AuditEntry, err error) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	entry.Result = AuditResultOK
+	if err != nil {
+		entry.Result = AuditResultFailed
+		entry.Error = err.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("unable to encode audit entry %+v (%s)", entry, err)
+		return
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	if err = os.MkdirAll(filepath.Dir(audit.filename), 0755); err != nil {
+		log.Errorf("unable to create the audit folder (%s)", err)
+		return
+	}
+
+	f, err := os.OpenFile(audit.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Errorf("unable to open audit file %s (%s)", audit.filename, err)
+		return
+	}
+
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		log.Errorf("unable to write audit file %s (%s)", audit.filename, err)
+	}
+
+	if err = f.Close(); err != nil {
+		log.Errorf("unable to close audit file %s (%s)", audit.filename, err)
+	}
+}
+
+// Read returns the entries matching the filter, oldest first
+func (audit *AuditLog) Read(filter AuditFilter) ([]AuditEntry, error) {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	entries := []AuditEntry{}
+
+	f, err := os.Open(audit.filename)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return entries, fmt.Errorf("unable to open audit file (%s)", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warnf("skipping invalid audit entry (%s)", err)
+			continue
+		}
+
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("unable to read audit file (%s)", err)
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, nil
+}
+
+func (filter AuditFilter) matches(entry AuditEntry) bool {
+	if !filter.From.IsZero() && entry.Time.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && entry.Time.After(filter.To) {
+		return false
+	}
+
+	if filter.Command != "" && !strings.HasPrefix(entry.Command, filter.Command) {
+		return false
+	}
+
+	if filter.Client != "" && entry.Client != filter.Client {
+		return false
+	}
+
+	if filter.Result != "" && entry.Result != filter.Result {
+		return false
+	}
+
+	return filter.ECUID == "" || entry.ECUID == filter.ECUID
+}
@@ -0,0 +1,93 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func readTestAudit(t *testing.T, webserver *WebServer, query string) []AuditEntry {
+	w := sendTestRequest(t, webserver, http.MethodGet, "/audit"+query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var entries []AuditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("unable to decode the audit entries (%s)", err)
+	}
+
+	return entries
+}
+
+func TestAuditRecordsECUCommands(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/idledecay", `{"steps":2}`)
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/fuelpump", `{"activate":true}`)
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/coil", `{"activate":true}`)
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/reset/faults", "")
+
+	entries := readTestAudit(t, webserver, "")
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 audit entries, got %d %+v", len(entries), entries)
+	}
+
+	adjust := entries[0]
+	if adjust.Command != "adjust idledecay" || adjust.Client != "192.0.2.1" || adjust.ECUID == "" || adjust.Time.IsZero() {
+		t.Errorf("unexpected adjustment entry %+v", adjust)
+	}
+
+	if adjust.Request != float64(2) {
+		t.Errorf("expected the requested steps to be recorded, got %v", adjust.Request)
+	}
+
+	if entries[1].Command != "test fuelpump" || entries[1].Before != false || entries[1].After != true {
+		t.Errorf("unexpected actuator entry %+v", entries[1])
+	}
+
+	if entries[2].Result != AuditResultFailed || entries[2].Error == "" {
+		t.Errorf("expected the coil test to be refused, got %+v", entries[2])
+	}
+
+	failed := readTestAudit(t, webserver, "?result=failed")
+	if len(failed) != 1 || failed[0].Command != "test coil" {
+		t.Errorf("expected only the coil test, got %+v", failed)
+	}
+
+	tests := readTestAudit(t, webserver, "?command=test&limit=1")
+	if len(tests) != 1 || tests[0].Command != "test coil" {
+		t.Errorf("expected the most recent actuator test, got %+v", tests)
+	}
+}
+
+func TestAuditDoesNotMoveScenarioOn(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	// the values read for the audit log are not dataframes played from the scenario
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/reset/faults", "")
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/reset/adjustments", "")
+	sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/snapshot", "")
+
+	if position := webserver.reader.ECU.Responder.Playbook.Position; position != 0 {
+		t.Errorf("expected the scenario to stay at position 0, got %d", position)
+	}
+
+	entries := readTestAudit(t, webserver, "")
+	if len(entries) != 2 || entries[0].Before == nil || entries[0].After == nil || entries[1].After == nil {
+		t.Errorf("expected the fault codes and adjustable values to be recorded, got %+v", entries)
+	}
+}
+
+func TestAuditInvalidFilter(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/audit?from=yesterday&limit=-1", "")
+	response := expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	if response.Errors["from"] == "" || response.Errors["limit"] == "" {
+		t.Errorf("expected from and limit to be invalid, got %v", response.Errors)
+	}
+}
@@ -0,0 +1,183 @@
+package fcr
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// CaptureSummary describes the result of a headless capture
+type CaptureSummary struct {
+	Port     string
+	ECUID    string
+	Started  time.Time
+	Finished time.Time
+	Samples  int
+	Errors   int
+	// Skipped is the number of polls not sent because the ecu was busy with another command
+	Skipped int
+	// Faults is the number of samples each fault was reported in
+	Faults map[string]int
+	// ECUFaults is the number of samples each fault code was reported in
+	ECUFaults map[string]int
+}
+
+// Capture connects to the ECU on the configured port and records the dataframes
+// until the duration has elapsed or the number of samples has been read, whichever is first.
+// A zero duration or sample count is unlimited, the capture can always be ended by shutting down the reader.
+// The ECU logs the dataframes to a CSV file and saves a scenario file when disconnected.
+func (reader *MemsReader) Capture(duration time.Duration, samples int) (CaptureSummary, error) {
+	var connected bool
+	var err error
+
+	var skipped int64
+
+	summary := CaptureSummary{Port: reader.Config.Port, Faults: make(map[string]int), ECUFaults: make(map[string]int)}
+
+	log.Infof("capture connecting to the ecu on %s", reader.Config.Port)
+
+	err = reader.Queue.Submit("connect", PriorityHigh, defaultCommandTimeout, func() error {
+		var err error
+		connected, err = reader.ECU.ConnectAndInitialiseECU(reader.Config.Port)
+		return err
+	})
+
+	if err != nil || !connected {
+		return summary, fmt.Errorf("unable to connect to the ecu on %s (%v)", reader.Config.Port, err)
+	}
+
+	summary.ECUID = reader.GetECUStatus().ECUID
+	summary.Started = time.Now()
+
+	received := make(chan *rosco.MemsData, acquisitionBufferSize)
+	reader.Acquisition.AddListener(func(status rosco.ECUStatus, sample *rosco.MemsData) {
+		// a poll skipped while the ecu is busy is not an error
+		if sample == nil && reader.Acquisition.Skipped() {
+			atomic.AddInt64(&skipped, 1)
+			return
+		}
+
+		select {
+		case received <- sample:
+		default:
+			log.Warnf("capture is not keeping up, dropped sample")
+		}
+	})
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	log.Infof("capture started (duration %v, samples %d)", duration, samples)
+	reader.Acquisition.Start()
+
+capture:
+	for samples == 0 || summary.Samples < samples {
+		select {
+		case sample := <-received:
+			if sample == nil {
+				summary.Errors++
+				continue
+			}
+
+			summary.Samples++
+			for _, fault := range getReportedFaults(sample.Analytics) {
+				summary.Faults[fault]++
+			}
+
+			for _, fault := range DecodeFaults(*sample) {
+				summary.ECUFaults[fault.Code]++
+			}
+		case <-timeout:
+			log.Infof("capture duration elapsed")
+			break capture
+		case <-reader.Done():
+			log.Infof("capture interrupted")
+			break capture
+		}
+	}
+
+	reader.Acquisition.Stop()
+	summary.Finished = time.Now()
+	summary.Skipped = int(atomic.LoadInt64(&skipped))
+
+	// disconnecting closes the log and writes the scenario file
+	if err = reader.Queue.Submit("disconnect", PriorityHigh, defaultCommandTimeout, reader.ECU.Disconnect); err != nil {
+		log.Warnf("capture error disconnecting from the ecu (%s)", err)
+	}
+
+	log.Infof("capture finished (%+v)", summary)
+
+	return summary, err
+}
+
+// WriteSummary writes the capture summary as a readable table
+func (summary CaptureSummary) WriteSummary(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Port:     %s\n", summary.Port)
+	_, _ = fmt.Fprintf(w, "ECU ID:   %s\n", summary.ECUID)
+	_, _ = fmt.Fprintf(w, "Started:  %s\n", summary.Started.Format("2006-01-02 15:04:05"))
+	_, _ = fmt.Fprintf(w, "Duration: %v\n", summary.Finished.Sub(summary.Started).Round(time.Second))
+	_, _ = fmt.Fprintf(w, "Samples:  %d\n", summary.Samples)
+	_, _ = fmt.Fprintf(w, "Errors:   %d\n", summary.Errors)
+	_, _ = fmt.Fprintf(w, "Skipped:  %d\n", summary.Skipped)
+	_, _ = fmt.Fprintf(w, "Logs:     %s\n", rosco.GetLogFolder())
+
+	summary.writeECUFaults(w)
+
+	if len(summary.Faults) == 0 {
+		_, _ = fmt.Fprintf(w, "Faults:   none\n")
+		return
+	}
+
+	faults := make([]string, 0, len(summary.Faults))
+	for fault := range summary.Faults {
+		faults = append(faults, fault)
+	}
+	sort.Strings(faults)
+
+	_, _ = fmt.Fprintf(w, "Faults:\n")
+	for _, fault := range faults {
+		_, _ = fmt.Fprintf(w, "  %-28s %d samples\n", fault, summary.Faults[fault])
+	}
+}
+
+// writeECUFaults writes the fault codes reported by the ECU with their descriptions
+func (summary CaptureSummary) writeECUFaults(w io.Writer) {
+	if len(summary.ECUFaults) == 0 {
+		_, _ = fmt.Fprintf(w, "Codes:    none\n")
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "Codes:\n")
+	for _, fault := range FaultCatalogue {
+		if samples, ok := summary.ECUFaults[fault.Code]; ok {
+			_, _ = fmt.Fprintf(w, "  %-8s %-50s %d samples\n", fault.Code, fault.Description, samples)
+		}
+	}
+}
+
+// getReportedFaults returns the names of the faults set in the analysis report
+func getReportedFaults(report rosco.AnalysisReport) []string {
+	var faults []string
+
+	value := reflect.ValueOf(report)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		if strings.HasSuffix(field.Name, "Fault") && value.Field(i).Kind() == reflect.Bool && value.Field(i).Bool() {
+			faults = append(faults, field.Name)
+		}
+	}
+
+	return faults
+}
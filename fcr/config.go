@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/andrewdjackson/rosco"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,34 +15,112 @@ import (
 
 // Config readmems configuration
 type Config struct {
-	Port       string
-	Ports      []string
-	Debug      string
-	Frequency  string
+	Port  string
+	Ports []string
+	Debug bool
+	// Frequency is the ECU polling interval in milliseconds
+	Frequency  int
 	Version    string
 	Build      string
-	ServerPort string
+	ServerPort int
+	// Profile is the name of the selected vehicle profile
+	Profile string
+	// Token is the bearer token required by the REST API, empty if not required
+	Token string `json:"-"`
+	// Origins are the web page origins allowed to call the REST API in addition to the server's own origin
+	Origins []string
+	// Listen is the address the web server listens on, the default only accepts connections from this computer
+	Listen string
+	// TLS serves the web interface over https
+	TLS bool
+	// TLSCert and TLSKey are the certificate and key files used for https,
+	// if not set a self-signed certificate is generated in the app folder
+	TLSCert string
+	TLSKey  string
+	// Sources reports where the value of each config key came from
+	Sources map[string]string
 }
 
-var config Config
+// the sources of the config values
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceFile    = "file"
+	ConfigSourceProfile = "profile"
+	ConfigSourceEnv     = "env"
+	ConfigSourceFlag    = "flag"
+)
+
+// ConfigKeys are the config file keys, each can be overridden with a
+// MEMSFCR_<KEY> environment variable or a -<key> command line flag
+var ConfigKeys = []string{"port", "serverport", "frequency", "debug", "profile", "token", "origins", "listen", "tls", "tlscert", "tlskey"}
+
+// configMutex guards changes to the running config
+var configMutex sync.RWMutex
+
+// configOverrides are the config values set on the command line
+var configOverrides map[string]string
+
+// default polling interval used if the configured frequency is invalid
+const defaultFrequency = 500
+
+// limits of the ECU polling interval in milliseconds
+const (
+	minFrequency = 100
+	maxFrequency = 60000
+)
+
+// limits of the web server port, 0 selects a free port
+const (
+	minServerPort = 0
+	maxServerPort = 65535
+)
+
+// default address of the web server, use 0.0.0.0 to accept connections from other devices
+const defaultListen = "127.0.0.1"
 
 // NewConfig creates a new instance of readmems config
 func NewConfig() *Config {
+	config := &Config{}
 	config.Port = "/dev/tty.serial"
-	config.Debug = "false"
-	config.Frequency = "500"
+	config.Debug = false
+	config.Frequency = defaultFrequency
 	config.Version = "0.0.0"
-	config.ServerPort = "0"
+	config.ServerPort = 0
+	config.Listen = defaultListen
 
 	currentTime := time.Now()
 	config.Build = currentTime.Format("2006-01-02")
 
-	return &config
+	config.Sources = make(map[string]string)
+	for _, key := range ConfigKeys {
+		config.Sources[key] = ConfigSourceDefault
+	}
+
+	return config
+}
+
+// SetConfigOverrides sets the values given on the command line,
+// these take precedence over the environment and the config file
+func SetConfigOverrides(overrides map[string]string) {
+	configOverrides = overrides
 }
 
-// WriteConfig write the config file
+// IsConfigKey returns true if the name is a config key that can be overridden
+func IsConfigKey(name string) bool {
+	for _, key := range ConfigKeys {
+		if key == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteConfig write the config file, values overridden by the
+// environment or the command line are not written and values
+// from the selected profile are written to the profile
 func WriteConfig(c *Config) {
-	filename := fmt.Sprintf("%s/memsfcr.cfg", rosco.GetHomeFolder())
+	filename := getConfigFilename()
 
 	// create the file if it doesn't exist
 	_, _ = os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
@@ -51,10 +132,17 @@ func WriteConfig(c *Config) {
 
 	cfg.Section("").Key("version").SetValue(c.Version)
 	cfg.Section("").Key("build").SetValue(c.Build)
-	cfg.Section("").Key("port").SetValue(c.Port)
-	cfg.Section("").Key("debug").SetValue(c.Debug)
-	cfg.Section("").Key("frequency").SetValue(c.Frequency)
-	cfg.Section("").Key("serverport").SetValue(c.ServerPort)
+
+	for _, key := range ConfigKeys {
+		switch c.Sources[key] {
+		case ConfigSourceEnv, ConfigSourceFlag:
+			continue
+		case ConfigSourceProfile:
+			cfg.Section(c.Profile).Key(key).SetValue(c.getValue(key))
+		default:
+			cfg.Section("").Key(key).SetValue(c.getValue(key))
+		}
+	}
 
 	err = cfg.SaveTo(filename)
 
@@ -65,31 +153,330 @@ func WriteConfig(c *Config) {
 	log.Infof("updated config: %s", filename)
 }
 
-// ReadConfig reads the config file
+// ReadConfig reads the config file and applies the overrides,
+// the precedence is command line flag > environment variable > profile > config file > default
 func ReadConfig() *Config {
-	filename := fmt.Sprintf("%s/memsfcr.cfg", rosco.GetHomeFolder())
+	filename := getConfigFilename()
 	log.Infof("loading config from %s", filename)
 
 	c := NewConfig()
+	migrate := false
 
 	cfg, err := ini.Load(filename)
 	if err != nil {
 		log.Infof("failed to read file: %v", err)
-		// couldn't read the config so write a new file
+		// couldn't read the config so write a new file with the defaults
+		WriteConfig(c)
+	} else {
+		section := cfg.Section("")
+
+		// older config files stored every value as a string, values that are missing
+		// or can't be parsed are replaced with the default and the file is rewritten
+		for _, key := range ConfigKeys {
+			if !section.HasKey(key) {
+				log.Warnf("missing %s in config, using %s", key, c.getValue(key))
+				migrate = true
+				continue
+			}
+
+			if err := c.setValue(key, section.Key(key).String()); err != nil {
+				log.Warnf("invalid %s in config (%s), using %s", key, err, c.getValue(key))
+				migrate = true
+				continue
+			}
+
+			c.Sources[key] = ConfigSourceFile
+		}
+	}
+
+	// the values read from the file are kept if an override is out of range
+	base := c.copy()
+
+	for _, key := range ConfigKeys {
+		env := fmt.Sprintf("MEMSFCR_%s", strings.ToUpper(key))
+
+		if value, ok := os.LookupEnv(env); ok {
+			if err := c.setValue(key, value); err != nil {
+				log.Warnf("ignoring invalid %s (%s)", env, err)
+			} else {
+				c.Sources[key] = ConfigSourceEnv
+			}
+		}
+
+		if value, ok := configOverrides[key]; ok {
+			if err := c.setValue(key, value); err != nil {
+				log.Warnf("ignoring invalid -%s flag (%s)", key, err)
+			} else {
+				c.Sources[key] = ConfigSourceFlag
+			}
+		}
+	}
+
+	c.restoreInvalidOverrides(&base)
+
+	if c.Profile != "" {
+		if err := c.applyProfile(c.Profile); err != nil {
+			log.Warnf("unable to apply profile %s (%s)", c.Profile, err)
+			c.Profile = ""
+			migrate = true
+		}
+	}
+
+	for field, reason := range c.Validate() {
+		log.Warnf("invalid %s in config, %s", field, reason)
+		c.applyDefault(field)
+		migrate = true
+	}
+
+	if migrate {
+		log.Infof("migrating config file %s", filename)
 		WriteConfig(c)
-		// return the default config
-		return c
 	}
 
-	c.Port = cfg.Section("").Key("port").String()
-	c.Debug = cfg.Section("").Key("debug").String()
-	c.Frequency = cfg.Section("").Key("frequency").String()
-	c.ServerPort = cfg.Section("").Key("serverport").String()
+	// never write the api token to the log
+	logged := *c
+	if logged.Token != "" {
+		logged.Token = "********"
+	}
 
-	log.Infof("MemsFCR Config %+v", c)
+	log.Infof("MemsFCR Config %+v", logged)
 	return c
 }
 
+// Validate checks the config values are in range,
+// returns the reason each invalid field was rejected
+func (c *Config) Validate() FieldErrors {
+	invalid := FieldErrors{}
+
+	if c.Port == "" {
+		invalid["Port"] = "port must not be empty"
+	}
+
+	if c.Frequency < minFrequency || c.Frequency > maxFrequency {
+		invalid["Frequency"] = fmt.Sprintf("frequency must be between %d and %d milliseconds", minFrequency, maxFrequency)
+	}
+
+	if c.ServerPort < minServerPort || c.ServerPort > maxServerPort {
+		invalid["ServerPort"] = fmt.Sprintf("server port must be between %d and %d", minServerPort, maxServerPort)
+	}
+
+	for _, origin := range c.Origins {
+		if !isValidOrigin(origin) {
+			invalid["Origins"] = fmt.Sprintf("%s is not an origin, use scheme://host[:port] or *", origin)
+		}
+	}
+
+	if !isValidListenAddress(c.Listen) {
+		invalid["Listen"] = fmt.Sprintf("%s is not an ip address or host name", c.Listen)
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		invalid["TLSCert"] = "the tls certificate and key must be given together"
+	}
+
+	return invalid
+}
+
+// configFieldKeys are the config keys of each field reported by Validate
+var configFieldKeys = map[string][]string{
+	"Port":       {"port"},
+	"Frequency":  {"frequency"},
+	"ServerPort": {"serverport"},
+	"Origins":    {"origins"},
+	"Listen":     {"listen"},
+	"TLSCert":    {"tlscert", "tlskey"},
+}
+
+// restoreInvalidOverrides puts back the value and source read from the file for each field
+// made invalid by the environment or the command line, so an invalid override is never written
+func (c *Config) restoreInvalidOverrides(base *Config) {
+	for field, reason := range c.Validate() {
+		overridden := false
+
+		for _, key := range configFieldKeys[field] {
+			if source := c.Sources[key]; source == ConfigSourceEnv || source == ConfigSourceFlag {
+				log.Warnf("ignoring %s %s from the %s, %s", key, c.getValue(key), source, reason)
+				overridden = true
+			}
+		}
+
+		if !overridden {
+			continue
+		}
+
+		for _, key := range configFieldKeys[field] {
+			_ = c.setValue(key, base.getValue(key))
+			c.Sources[key] = base.Sources[key]
+		}
+	}
+}
+
+// applyDefault resets the field to its default value
+func (c *Config) applyDefault(field string) {
+	switch field {
+	case "Port":
+		c.Port = "/dev/tty.serial"
+		c.Sources["port"] = ConfigSourceDefault
+	case "Frequency":
+		c.Frequency = defaultFrequency
+		c.Sources["frequency"] = ConfigSourceDefault
+	case "ServerPort":
+		c.ServerPort = 0
+		c.Sources["serverport"] = ConfigSourceDefault
+	case "Origins":
+		c.Origins = nil
+		c.Sources["origins"] = ConfigSourceDefault
+	case "Listen":
+		c.Listen = defaultListen
+		c.Sources["listen"] = ConfigSourceDefault
+	case "TLSCert":
+		c.TLSCert = ""
+		c.TLSKey = ""
+		c.Sources["tlscert"] = ConfigSourceDefault
+		c.Sources["tlskey"] = ConfigSourceDefault
+	}
+}
+
+// setValue parses the value of the config key
+func (c *Config) setValue(key string, value string) error {
+	var err error
+
+	value = strings.TrimSpace(value)
+
+	switch key {
+	case "port":
+		c.Port = value
+	case "debug":
+		var debug bool
+		if debug, err = strconv.ParseBool(value); err == nil {
+			c.Debug = debug
+		}
+	case "frequency":
+		var frequency int
+		if frequency, err = strconv.Atoi(value); err == nil {
+			c.Frequency = frequency
+		}
+	case "serverport":
+		var serverPort int
+		if serverPort, err = strconv.Atoi(value); err == nil {
+			c.ServerPort = serverPort
+		}
+	case "profile":
+		c.Profile = value
+	case "token":
+		c.Token = value
+	case "origins":
+		c.Origins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.Origins = append(c.Origins, origin)
+			}
+		}
+	case "listen":
+		c.Listen = value
+	case "tls":
+		var enabled bool
+		if enabled, err = strconv.ParseBool(value); err == nil {
+			c.TLS = enabled
+		}
+	case "tlscert":
+		c.TLSCert = value
+	case "tlskey":
+		c.TLSKey = value
+	default:
+		err = fmt.Errorf("unknown config key %s", key)
+	}
+
+	return err
+}
+
+// getValue returns the value of the config key as written to the config file
+func (c *Config) getValue(key string) string {
+	switch key {
+	case "port":
+		return c.Port
+	case "debug":
+		return strconv.FormatBool(c.Debug)
+	case "frequency":
+		return strconv.Itoa(c.Frequency)
+	case "serverport":
+		return strconv.Itoa(c.ServerPort)
+	case "profile":
+		return c.Profile
+	case "token":
+		return c.Token
+	case "origins":
+		return strings.Join(c.Origins, ",")
+	case "listen":
+		return c.Listen
+	case "tls":
+		return strconv.FormatBool(c.TLS)
+	case "tlscert":
+		return c.TLSCert
+	case "tlskey":
+		return c.TLSKey
+	}
+
+	return ""
+}
+
+// getToken returns the api token
+func (c *Config) getToken() string {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return c.Token
+}
+
+// getOrigins returns the origins allowed to call the api
+func (c *Config) getOrigins() []string {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return c.Origins
+}
+
+// getListen returns the address the web server listens on
+func (c *Config) getListen() string {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return c.Listen
+}
+
+// copy returns a copy of the config that can be changed independently
+func (c *Config) copy() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	copied := *c
+	copied.Origins = append([]string(nil), c.Origins...)
+	copied.Sources = make(map[string]string, len(c.Sources))
+	for key, source := range c.Sources {
+		copied.Sources[key] = source
+	}
+
+	return copied
+}
+
+// getConfigFilename returns the path of the config file
+func getConfigFilename() string {
+	return fmt.Sprintf("%s/memsfcr.cfg", rosco.GetHomeFolder())
+}
+
+// getFrequency returns the ECU polling interval
+func (c *Config) getFrequency() time.Duration {
+	configMutex.RLock()
+	frequency := c.Frequency
+	configMutex.RUnlock()
+
+	if frequency <= 0 {
+		frequency = defaultFrequency
+	}
+
+	return time.Duration(frequency) * time.Millisecond
+}
+
 func CreateFolders() {
 	err := createFolder(rosco.GetHomeFolder())
 	if err == nil {
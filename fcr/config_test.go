@@ -0,0 +1,173 @@
+package fcr
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+	"gopkg.in/ini.v1"
+)
+
+// newTestConfigFile writes the config file to a temporary home folder
+func newTestConfigFile(t *testing.T, config *Config) {
+	t.Setenv("HOME", t.TempDir())
+	homedir.DisableCache = true
+	CreateFolders()
+
+	WriteConfig(config)
+}
+
+func readTestConfigFile(t *testing.T, key string) string {
+	cfg, err := ini.Load(getConfigFilename())
+	if err != nil {
+		t.Fatalf("unable to read the config file (%s)", err)
+	}
+
+	return cfg.Section("").Key(key).String()
+}
+
+func TestInvalidOverridesKeepFileValues(t *testing.T) {
+	config := NewConfig()
+	config.Frequency = 750
+	config.Listen = "0.0.0.0"
+	newTestConfigFile(t, config)
+
+	t.Setenv("MEMSFCR_FREQUENCY", "5")
+	t.Setenv("MEMSFCR_TLSCERT", "memsfcr.crt")
+
+	SetConfigOverrides(map[string]string{"listen": "http://laptop"})
+	t.Cleanup(func() { SetConfigOverrides(nil) })
+
+	c := ReadConfig()
+
+	if c.Frequency != 750 || c.Sources["frequency"] != ConfigSourceFile {
+		t.Errorf("expected the frequency from the file, got %d from %s", c.Frequency, c.Sources["frequency"])
+	}
+
+	if c.Listen != "0.0.0.0" || c.Sources["listen"] != ConfigSourceFile {
+		t.Errorf("expected the listen address from the file, got %s from %s", c.Listen, c.Sources["listen"])
+	}
+
+	if c.TLSCert != "" || c.TLSKey != "" {
+		t.Errorf("expected the certificate without a key to be ignored, got %s %s", c.TLSCert, c.TLSKey)
+	}
+
+	// the file is left as it was
+	for key, value := range map[string]string{"frequency": "750", "listen": "0.0.0.0", "tlscert": ""} {
+		if saved := readTestConfigFile(t, key); saved != value {
+			t.Errorf("expected %s=%s in the config file, got %s", key, value, saved)
+		}
+	}
+}
+
+func TestValidOverridesAreNotWritten(t *testing.T) {
+	newTestConfigFile(t, NewConfig())
+
+	t.Setenv("MEMSFCR_FREQUENCY", "250")
+	t.Setenv("MEMSFCR_TLSCERT", "memsfcr.crt")
+	t.Setenv("MEMSFCR_TLSKEY", "memsfcr.key")
+
+	c := ReadConfig()
+
+	if c.Frequency != 250 || c.Sources["frequency"] != ConfigSourceEnv || c.TLSCert != "memsfcr.crt" || c.TLSKey != "memsfcr.key" {
+		t.Errorf("expected the environment values, got %+v", c)
+	}
+
+	WriteConfig(c)
+
+	if saved := readTestConfigFile(t, "frequency"); saved != "500" {
+		t.Errorf("expected the config file frequency to be unchanged, got %s", saved)
+	}
+}
+
+func TestConfigSetValue(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    string
+		expected string
+		invalid  bool
+	}{
+		{"frequency", " 250 ", "250", false},
+		{"frequency", "fast", "500", true},
+		{"serverport", "8081", "8081", false},
+		{"serverport", "", "0", true},
+		{"debug", "true", "true", false},
+		{"debug", "yes", "false", true},
+		{"tls", "1", "true", false},
+		{"origins", " http://laptop:8081 , ,http://tablet ", "http://laptop:8081,http://tablet", false},
+		{"listen", "0.0.0.0", "0.0.0.0", false},
+		{"colour", "red", "", true},
+	}
+
+	for _, test := range tests {
+		c := NewConfig()
+		err := c.setValue(test.key, test.value)
+
+		if (err != nil) != test.invalid {
+			t.Errorf("setValue(%s, %q) expected invalid %t, got %v", test.key, test.value, test.invalid, err)
+		}
+
+		if value := c.getValue(test.key); value != test.expected {
+			t.Errorf("setValue(%s, %q) expected %q, got %q", test.key, test.value, test.expected, value)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		invalid []string
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"fastest frequency", func(c *Config) { c.Frequency = minFrequency }, nil},
+		{"frequency too fast", func(c *Config) { c.Frequency = minFrequency - 1 }, []string{"Frequency"}},
+		{"frequency too slow", func(c *Config) { c.Frequency = maxFrequency + 1 }, []string{"Frequency"}},
+		{"server port", func(c *Config) { c.ServerPort = 70000 }, []string{"ServerPort"}},
+		{"empty port", func(c *Config) { c.Port = "" }, []string{"Port"}},
+		{"origin", func(c *Config) { c.Origins = []string{"laptop"} }, []string{"Origins"}},
+		{"listen", func(c *Config) { c.Listen = "127.0.0.1:8081" }, []string{"Listen"}},
+		{"certificate without key", func(c *Config) { c.TLSCert = "memsfcr.crt" }, []string{"TLSCert"}},
+		{"several", func(c *Config) { c.Frequency = 0; c.ServerPort = -1 }, []string{"Frequency", "ServerPort"}},
+	}
+
+	for _, test := range tests {
+		c := NewConfig()
+		test.change(c)
+
+		invalid := c.Validate()
+
+		if len(invalid) != len(test.invalid) {
+			t.Errorf("%s expected %v to be invalid, got %v", test.name, test.invalid, invalid)
+			continue
+		}
+
+		for _, field := range test.invalid {
+			if invalid[field] == "" {
+				t.Errorf("%s expected a reason for %s, got %v", test.name, field, invalid)
+			}
+		}
+	}
+}
+
+func TestMigrateStringConfig(t *testing.T) {
+	newTestConfigFile(t, NewConfig())
+
+	// older config files stored every value as a string and didn't have the newer keys
+	old := "port = /dev/ttyUSB0\ndebug = \nfrequency = fast\nserverport = 8081\n"
+	if err := os.WriteFile(getConfigFilename(), []byte(old), 0644); err != nil {
+		t.Fatalf("unable to write the config file (%s)", err)
+	}
+
+	c := ReadConfig()
+
+	if c.Port != "/dev/ttyUSB0" || c.ServerPort != 8081 || c.Frequency != defaultFrequency || c.Debug || c.Listen != defaultListen {
+		t.Errorf("expected the valid values to be kept and the rest defaulted, got %+v", c)
+	}
+
+	for key, value := range map[string]string{"port": "/dev/ttyUSB0", "frequency": "500", "debug": "false", "serverport": "8081", "listen": defaultListen} {
+		if saved := readTestConfigFile(t, key); saved != value {
+			t.Errorf("expected the migrated config file to have %s=%s, got %s", key, value, saved)
+		}
+	}
+}
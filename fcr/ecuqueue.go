@@ -0,0 +1,272 @@
+package fcr
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// command priorities, higher priority commands are sent to the ECU first
+const (
+	// PriorityLow background polling of the ECU
+	PriorityLow = iota
+	// PriorityNormal user requested reads, adjustments and tests
+	PriorityNormal
+	// PriorityHigh connection management and switching actuators off
+	PriorityHigh
+)
+
+// default time a command may wait in the queue before it's abandoned
+const defaultCommandTimeout = time.Second * 5
+
+// ErrECUQueueTimeout is returned when a command was not sent to the ECU before its timeout
+var ErrECUQueueTimeout = errors.New("timed out waiting for the ecu")
+
+// ErrECUQueueClosed is returned when commands are submitted after the queue has been closed
+var ErrECUQueueClosed = errors.New("ecu command queue is closed")
+
+// ErrECUNotConnected is returned when a command requires a connected ECU
+var ErrECUNotConnected = errors.New("ecu is not connected")
+
+// ECUQueueStatus reports the depth of the queue and the command latency in milliseconds
+type ECUQueueStatus struct {
+	Depth            int     `json:"depth"`
+	Running          string  `json:"running"`
+	Processed        uint64  `json:"processed"`
+	Failed           uint64  `json:"failed"`
+	TimedOut         uint64  `json:"timedOut"`
+	LastWait         float64 `json:"lastWait"`
+	AverageWait      float64 `json:"averageWait"`
+	MaxWait          float64 `json:"maxWait"`
+	LastExecution    float64 `json:"lastExecution"`
+	AverageExecution float64 `json:"averageExecution"`
+	MaxExecution     float64 `json:"maxExecution"`
+}
+
+// ecuCommand is a command waiting in the queue
+type ecuCommand struct {
+	name     string
+	priority int
+	sequence uint64
+	queued   time.Time
+	deadline time.Time
+	execute  func() error
+	result   chan error
+}
+
+// ECUCommandQueue serialises all the commands sent to the ECU
+// so that only one command is using the serial connection at a time
+type ECUCommandQueue struct {
+	mutex          sync.Mutex
+	executing      sync.RWMutex
+	pending        commandHeap
+	sequence       uint64
+	wake           chan struct{}
+	closed         bool
+	status         ECUQueueStatus
+	totalWait      time.Duration
+	totalExecution time.Duration
+}
+
+// NewECUCommandQueue creates the queue and starts sending commands to the ECU
+func NewECUCommandQueue() *ECUCommandQueue {
+	queue := &ECUCommandQueue{}
+	queue.wake = make(chan struct{}, 1)
+
+	go queue.run()
+
+	return queue
+}
+
+// Submit queues the command and waits for it to be executed. The timeout only covers the time
+// spent waiting in the queue, the command is abandoned if it hasn't started within the timeout
+// but once started it runs to completion however long it takes
+func (queue *ECUCommandQueue) Submit(name string, priority int, timeout time.Duration, execute func() error) error {
+	queue.mutex.Lock()
+
+	if queue.closed {
+		queue.mutex.Unlock()
+		return ErrECUQueueClosed
+	}
+
+	now := time.Now()
+	queue.sequence++
+
+	command := &ecuCommand{
+		name:     name,
+		priority: priority,
+		sequence: queue.sequence,
+		queued:   now,
+		deadline: now.Add(timeout),
+		execute:  execute,
+		result:   make(chan error, 1),
+	}
+
+	heap.Push(&queue.pending, command)
+	queue.status.Depth = queue.pending.Len()
+
+	// wake the worker if it's idle
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	queue.mutex.Unlock()
+
+	return <-command.result
+}
+
+// Inspect runs the function whilst no command is being sent to the ECU,
+// use this to read the ECU state without queuing behind other commands
+func (queue *ECUCommandQueue) Inspect(inspect func()) {
+	queue.executing.RLock()
+	defer queue.executing.RUnlock()
+
+	inspect()
+}
+
+// Status returns the current queue statistics
+func (queue *ECUCommandQueue) Status() ECUQueueStatus {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.status
+}
+
+// Close stops the queue, the commands still waiting and any submitted afterwards
+// are rejected, the command being sent to the ECU is allowed to finish
+func (queue *ECUCommandQueue) Close() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		return
+	}
+
+	queue.closed = true
+	close(queue.wake)
+
+	for queue.pending.Len() > 0 {
+		command := heap.Pop(&queue.pending).(*ecuCommand)
+		command.result <- ErrECUQueueClosed
+	}
+
+	queue.status.Depth = 0
+}
+
+// run executes the commands in priority order, one at a time
+func (queue *ECUCommandQueue) run() {
+	for {
+		command := queue.next()
+
+		if command == nil {
+			if _, ok := <-queue.wake; !ok {
+				return
+			}
+			continue
+		}
+
+		started := time.Now()
+		wait := started.Sub(command.queued)
+
+		if started.After(command.deadline) {
+			log.Warnf("ecu command %s timed out after waiting %v", command.name, wait)
+			queue.timedOut()
+			command.result <- ErrECUQueueTimeout
+			continue
+		}
+
+		queue.executing.Lock()
+		err := command.execute()
+		queue.executing.Unlock()
+		execution := time.Since(started)
+
+		if err != nil {
+			log.Warnf("ecu command %s failed (%s)", command.name, err)
+		}
+
+		queue.completed(wait, execution, err)
+		command.result <- err
+	}
+}
+
+// next removes the highest priority command from the queue
+func (queue *ECUCommandQueue) next() *ecuCommand {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.pending.Len() == 0 {
+		queue.status.Running = ""
+		return nil
+	}
+
+	command := heap.Pop(&queue.pending).(*ecuCommand)
+	queue.status.Depth = queue.pending.Len()
+	queue.status.Running = command.name
+
+	return command
+}
+
+func (queue *ECUCommandQueue) timedOut() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.status.TimedOut++
+}
+
+func (queue *ECUCommandQueue) completed(wait time.Duration, execution time.Duration, err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.status.Processed++
+	if err != nil {
+		queue.status.Failed++
+	}
+
+	queue.totalWait += wait
+	queue.totalExecution += execution
+
+	queue.status.LastWait = milliseconds(wait)
+	queue.status.LastExecution = milliseconds(execution)
+	queue.status.AverageWait = milliseconds(queue.totalWait) / float64(queue.status.Processed)
+	queue.status.AverageExecution = milliseconds(queue.totalExecution) / float64(queue.status.Processed)
+
+	if queue.status.LastWait > queue.status.MaxWait {
+		queue.status.MaxWait = queue.status.LastWait
+	}
+
+	if queue.status.LastExecution > queue.status.MaxExecution {
+		queue.status.MaxExecution = queue.status.LastExecution
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// commandHeap orders the commands by priority and then by the order they were submitted
+type commandHeap []*ecuCommand
+
+func (h commandHeap) Len() int { return len(h) }
+func (h commandHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].sequence < h[j].sequence
+}
+func (h commandHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *commandHeap) Push(x interface{}) {
+	*h = append(*h, x.(*ecuCommand))
+}
+
+func (h *commandHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	command := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return command
+}
@@ -0,0 +1,195 @@
+package fcr
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockTestQueue runs a command that holds the queue until it is released
+func blockTestQueue(t *testing.T, queue *ECUCommandQueue, running *int32) (func(), chan error) {
+	release := make(chan struct{})
+	done := make(chan error, 1)
+
+	go func() {
+		done <- queue.Submit("block", PriorityHigh, time.Second, func() error {
+			atomic.StoreInt32(running, 1)
+			<-release
+			atomic.StoreInt32(running, 0)
+			return nil
+		})
+	}()
+
+	waitForTestQueue(t, queue, func(status ECUQueueStatus) bool { return atomic.LoadInt32(running) == 1 })
+
+	var once sync.Once
+	return func() { once.Do(func() { close(release) }) }, done
+}
+
+func waitForTestQueue(t *testing.T, queue *ECUCommandQueue, ready func(status ECUQueueStatus) bool) {
+	deadline := time.Now().Add(time.Second)
+
+	for !ready(queue.Status()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the queue, %+v", queue.Status())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuePriorityOrder(t *testing.T) {
+	queue := NewECUCommandQueue()
+	defer queue.Close()
+
+	var running int32
+	release, _ := blockTestQueue(t, queue, &running)
+	defer release()
+
+	var mutex sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+
+	// queued one at a time so the order they were submitted is known
+	commands := []struct {
+		name     string
+		priority int
+	}{
+		{"poll", PriorityLow},
+		{"read 1", PriorityNormal},
+		{"connect", PriorityHigh},
+		{"read 2", PriorityNormal},
+		{"read 3", PriorityNormal},
+	}
+
+	for i, command := range commands {
+		name := command.name
+		wg.Add(1)
+
+		go func(priority int) {
+			defer wg.Done()
+			_ = queue.Submit(name, priority, time.Second, func() error {
+				mutex.Lock()
+				order = append(order, name)
+				mutex.Unlock()
+				return nil
+			})
+		}(command.priority)
+
+		depth := i + 1
+		waitForTestQueue(t, queue, func(status ECUQueueStatus) bool { return status.Depth == depth })
+	}
+
+	release()
+	wg.Wait()
+
+	expected := []string{"connect", "read 1", "read 2", "read 3", "poll"}
+	for i := range expected {
+		if i >= len(order) || order[i] != expected[i] {
+			t.Fatalf("expected the commands in the order %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestQueueTimeoutWhileQueued(t *testing.T) {
+	queue := NewECUCommandQueue()
+	defer queue.Close()
+
+	var running int32
+	release, _ := blockTestQueue(t, queue, &running)
+
+	executed := false
+	result := make(chan error, 1)
+
+	go func() {
+		result <- queue.Submit("read", PriorityNormal, time.Millisecond*10, func() error {
+			executed = true
+			return nil
+		})
+	}()
+
+	waitForTestQueue(t, queue, func(status ECUQueueStatus) bool { return status.Depth == 1 })
+	time.Sleep(time.Millisecond * 20)
+	release()
+
+	if err := <-result; err != ErrECUQueueTimeout || executed {
+		t.Errorf("expected the command to time out without being sent, got %v (executed %t)", err, executed)
+	}
+
+	if status := queue.Status(); status.TimedOut != 1 {
+		t.Errorf("expected the timeout to be counted, got %+v", status)
+	}
+
+	// the timeout doesn't cover the time taken to execute the command
+	err := queue.Submit("slow", PriorityNormal, time.Millisecond*10, func() error {
+		time.Sleep(time.Millisecond * 30)
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("expected a slow command to complete, got %s", err)
+	}
+}
+
+func TestQueueCloseRejectsCommands(t *testing.T) {
+	queue := NewECUCommandQueue()
+
+	var running int32
+	release, done := blockTestQueue(t, queue, &running)
+
+	executed := false
+	result := make(chan error, 1)
+
+	go func() {
+		result <- queue.Submit("read", PriorityNormal, time.Second, func() error {
+			executed = true
+			return nil
+		})
+	}()
+
+	waitForTestQueue(t, queue, func(status ECUQueueStatus) bool { return status.Depth == 1 })
+	queue.Close()
+
+	if err := <-result; err != ErrECUQueueClosed {
+		t.Errorf("expected the waiting command to be rejected, got %v", err)
+	}
+
+	if err := queue.Submit("read", PriorityNormal, time.Second, func() error { return nil }); err != ErrECUQueueClosed {
+		t.Errorf("expected a new command to be rejected, got %v", err)
+	}
+
+	// the running command is allowed to finish
+	release()
+
+	if err := <-done; err != nil || executed {
+		t.Errorf("expected only the running command to complete, got %v (executed %t)", err, executed)
+	}
+
+	queue.Close()
+}
+
+func TestQueueInspectIsExclusive(t *testing.T) {
+	queue := NewECUCommandQueue()
+	defer queue.Close()
+
+	var running int32
+	release, _ := blockTestQueue(t, queue, &running)
+
+	inspected := make(chan int32, 1)
+	go queue.Inspect(func() {
+		inspected <- atomic.LoadInt32(&running)
+	})
+
+	select {
+	case <-inspected:
+		t.Fatalf("expected the inspection to wait for the running command")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	release()
+
+	if value := <-inspected; value != 0 {
+		t.Errorf("expected the inspection to run after the command finished")
+	}
+}
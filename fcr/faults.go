@@ -0,0 +1,266 @@
+package fcr
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// FaultDefinition describes a fault code bit reported by the ECU
+type FaultDefinition struct {
+	// Code identifies the fault by the dataframe, offset and bit, e.g. 80x0D.0
+	Code string `json:"code"`
+	// Register is the fault code byte in the dataframe, DTC0 to DTC5
+	Register    string `json:"register"`
+	Mask        uint8  `json:"mask"`
+	Description string `json:"description"`
+	// Components are the parts likely to cause the fault
+	Components []string `json:"components"`
+	// Checks are the suggested diagnostic checks
+	Checks []string `json:"checks"`
+}
+
+// FaultHistory records when a fault was seen during the session
+type FaultHistory struct {
+	FaultDefinition
+	Active    bool      `json:"active"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Samples is the number of dataframes the fault was reported in
+	Samples int `json:"samples"`
+}
+
+// FaultReport is the active faults and the faults seen since the ECU was connected
+type FaultReport struct {
+	ECUID   string         `json:"ecuId"`
+	Updated time.Time      `json:"updated"`
+	Active  []FaultHistory `json:"active"`
+	History []FaultHistory `json:"history"`
+	// Raw are the fault code bytes from the latest dataframe
+	Raw map[string]uint8 `json:"raw"`
+}
+
+// FaultCatalogue is every fault code bit the ECU is known to report
+var FaultCatalogue = []FaultDefinition{
+	{
+		Code: "80x0D.0", Register: "DTC0", Mask: rosco.CoolantSensorFaultCode,
+		Description: "Coolant temperature sensor circuit fault",
+		Components:  []string{"coolant temperature sensor", "sensor connector and wiring"},
+		Checks:      []string{"measure the sensor resistance against the coolant temperature (about 2.5k ohms at 20C)", "check the 5V reference and earth at the connector", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "80x0D.1", Register: "DTC0", Mask: rosco.AirSensorFaultCode,
+		Description: "Inlet air temperature sensor circuit fault",
+		Components:  []string{"inlet air temperature sensor", "sensor connector and wiring"},
+		Checks:      []string{"measure the sensor resistance against the air temperature", "check the connector for corrosion", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "80x0D.3", Register: "DTC0", Mask: rosco.TurboOverboostFaultCode,
+		Description: "Turbo overboost",
+		Components:  []string{"wastegate actuator", "boost control valve", "boost hoses"},
+		Checks:      []string{"check the wastegate moves freely", "check the boost control valve and hoses", "compare the boost pressure with the MAP reading"},
+	},
+	{
+		Code: "80x0D.4", Register: "DTC0", Mask: rosco.AmbientTempSensorFaultCode,
+		Description: "Ambient temperature sensor circuit fault",
+		Components:  []string{"ambient temperature sensor", "sensor connector and wiring"},
+		Checks:      []string{"measure the sensor resistance against the air temperature", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "80x0D.5", Register: "DTC0", Mask: rosco.FuelRailTempFaultCode,
+		Description: "Fuel rail temperature sensor circuit fault",
+		Components:  []string{"fuel rail temperature sensor", "sensor connector and wiring"},
+		Checks:      []string{"measure the sensor resistance against the fuel temperature", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "80x0D.6", Register: "DTC0", Mask: rosco.KnockDetectedFaultCode,
+		Description: "Knock detected",
+		Components:  []string{"knock sensor", "fuel quality", "ignition timing"},
+		Checks:      []string{"check the knock sensor is torqued correctly", "check the fuel octane rating", "check the ignition advance offset"},
+	},
+	{
+		Code: "80x0E.0", Register: "DTC1", Mask: rosco.CoolantTempGaugeFaultCode,
+		Description: "Coolant temperature gauge circuit fault",
+		Components:  []string{"coolant temperature gauge", "gauge wiring"},
+		Checks:      []string{"check the gauge output wiring from the ECU", "check the gauge with a known good sender"},
+	},
+	{
+		Code: "80x0E.1", Register: "DTC1", Mask: rosco.FuelPumpFaultCode,
+		Description: "Fuel pump circuit fault",
+		Components:  []string{"fuel pump relay", "fuel pump", "inertia switch", "fuel pump wiring"},
+		Checks:      []string{"run the fuel pump actuator test and listen for the pump", "check the inertia switch has not tripped", "check the relay and the pump fuse"},
+	},
+	{
+		Code: "80x0E.2", Register: "DTC1", Mask: rosco.AirConFaultCode,
+		Description: "Air conditioning clutch circuit fault",
+		Components:  []string{"air conditioning clutch relay", "compressor clutch", "relay wiring"},
+		Checks:      []string{"run the air conditioning actuator test", "check the relay and the clutch wiring"},
+	},
+	{
+		Code: "80x0E.3", Register: "DTC1", Mask: rosco.PurgeValveFaultCode,
+		Description: "Carbon canister purge valve circuit fault",
+		Components:  []string{"purge valve", "purge valve wiring"},
+		Checks:      []string{"run the purge valve actuator test and listen for the valve", "measure the valve resistance", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "80x0E.4", Register: "DTC1", Mask: rosco.MAPSensorFaultCode,
+		Description: "Manifold absolute pressure sensor fault",
+		Components:  []string{"MAP sensor in the ECU", "vacuum pipe to the ECU", "fuel trap"},
+		Checks:      []string{"check the vacuum pipe to the ECU for splits and blockages", "check the fuel trap is clear", "compare the MAP reading with the engine off to the air pressure"},
+	},
+	{
+		Code: "80x0E.5", Register: "DTC1", Mask: rosco.BoostValveFaultCode,
+		Description: "Boost control valve circuit fault",
+		Components:  []string{"boost control valve", "valve wiring"},
+		Checks:      []string{"run the boost valve actuator test", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "80x0E.6", Register: "DTC1", Mask: rosco.ThrottlePotFaultCode,
+		Description: "Throttle potentiometer circuit fault",
+		Components:  []string{"throttle potentiometer", "potentiometer connector and wiring"},
+		Checks:      []string{"check the throttle pot voltage rises smoothly as the throttle is opened", "check the 5V reference and earth at the connector", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "7dx05.2", Register: "DTC2", Mask: rosco.LambdaHeaterRelay,
+		Description: "Lambda sensor heater relay circuit fault",
+		Components:  []string{"lambda heater relay", "lambda sensor heater", "heater wiring"},
+		Checks:      []string{"measure the lambda heater resistance", "check the heater relay and fuse", "check the wiring for open or short circuits"},
+	},
+	{
+		Code: "7dx05.3", Register: "DTC2", Mask: rosco.SecondaryTriggerSync,
+		Description: "Secondary trigger synchronisation fault",
+		Components:  []string{"camshaft sensor", "sensor wiring"},
+		Checks:      []string{"check the sensor air gap and mounting", "check the wiring and screening"},
+	},
+	{
+		Code: "7dx05.4", Register: "DTC2", Mask: rosco.Fan1Control,
+		Description: "Radiator fan 1 control circuit fault",
+		Components:  []string{"fan 1 relay", "radiator fan 1", "fan wiring"},
+		Checks:      []string{"run the fan 1 actuator test", "check the fan relay and fuse", "check the fan motor"},
+	},
+	{
+		Code: "7dx05.6", Register: "DTC2", Mask: rosco.Fan2Control,
+		Description: "Radiator fan 2 control circuit fault",
+		Components:  []string{"fan 2 relay", "radiator fan 2", "fan wiring"},
+		Checks:      []string{"run the fan 2 actuator test", "check the fan relay and fuse", "check the fan motor"},
+	},
+	{
+		Code: "7dx0E.0", Register: "DTC3", Mask: rosco.PrimaryTriggerSync,
+		Description: "Primary trigger (crankshaft sensor) synchronisation fault",
+		Components:  []string{"crankshaft position sensor", "flywheel reluctor ring", "sensor wiring"},
+		Checks:      []string{"check the sensor air gap and mounting", "inspect the reluctor ring for damage or debris", "check the wiring and screening"},
+	},
+}
+
+// getFaultCodes returns the fault code bytes in the dataframe
+func getFaultCodes(memsdata rosco.MemsData) map[string]uint8 {
+	return map[string]uint8{
+		"DTC0": memsdata.DTC0,
+		"DTC1": memsdata.DTC1,
+		"DTC2": memsdata.DTC2,
+		"DTC3": memsdata.DTC3,
+		"DTC4": memsdata.DTC4,
+		"DTC5": memsdata.DTC5,
+	}
+}
+
+// DecodeFaults returns the catalogued faults set in the dataframe
+func DecodeFaults(memsdata rosco.MemsData) []FaultDefinition {
+	faults := []FaultDefinition{}
+	codes := getFaultCodes(memsdata)
+
+	for _, fault := range FaultCatalogue {
+		if codes[fault.Register]&fault.Mask != 0 {
+			faults = append(faults, fault)
+		}
+	}
+
+	return faults
+}
+
+// FaultTracker keeps the history of the faults reported since the ECU was connected
+type FaultTracker struct {
+	mutex     sync.RWMutex
+	ecuID     string
+	connected bool
+	updated   time.Time
+	raw       map[string]uint8
+	history   map[string]*FaultHistory
+}
+
+// NewFaultTracker creates an empty fault history
+func NewFaultTracker() *FaultTracker {
+	tracker := &FaultTracker{}
+	tracker.history = make(map[string]*FaultHistory)
+
+	return tracker
+}
+
+// Update records the faults in the sample, the history is cleared when the ECU is connected.
+// This is an acquisition listener.
+func (tracker *FaultTracker) Update(status rosco.ECUStatus, sample *rosco.MemsData) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if status.Connected && (!tracker.connected || status.ECUID != tracker.ecuID) {
+		// new session
+		tracker.history = make(map[string]*FaultHistory)
+		tracker.raw = nil
+		tracker.ecuID = status.ECUID
+	}
+
+	tracker.connected = status.Connected
+
+	if sample == nil {
+		return
+	}
+
+	now := time.Now()
+	tracker.updated = now
+	tracker.raw = getFaultCodes(*sample)
+
+	for _, history := range tracker.history {
+		history.Active = false
+	}
+
+	for _, fault := range DecodeFaults(*sample) {
+		history, ok := tracker.history[fault.Code]
+		if !ok {
+			history = &FaultHistory{FaultDefinition: fault, FirstSeen: now}
+			tracker.history[fault.Code] = history
+		}
+
+		history.Active = true
+		history.LastSeen = now
+		history.Samples++
+	}
+}
+
+// Report returns the active faults and the history in code order
+func (tracker *FaultTracker) Report() FaultReport {
+	tracker.mutex.RLock()
+	defer tracker.mutex.RUnlock()
+
+	report := FaultReport{ECUID: tracker.ecuID, Updated: tracker.updated, Active: []FaultHistory{}, History: []FaultHistory{}, Raw: tracker.raw}
+
+	for _, history := range tracker.history {
+		report.History = append(report.History, *history)
+
+		if history.Active {
+			report.Active = append(report.Active, *history)
+		}
+	}
+
+	sortFaults(report.Active)
+	sortFaults(report.History)
+
+	return report
+}
+
+func sortFaults(faults []FaultHistory) {
+	sort.Slice(faults, func(i, j int) bool {
+		return faults[i].Code < faults[j].Code
+	})
+}
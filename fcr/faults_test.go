@@ -0,0 +1,78 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+func TestDecodeFaults(t *testing.T) {
+	memsdata := rosco.MemsData{DTC0: rosco.CoolantSensorFaultCode, DTC1: rosco.FuelPumpFaultCode | rosco.ThrottlePotFaultCode}
+
+	faults := DecodeFaults(memsdata)
+	if len(faults) != 3 {
+		t.Fatalf("expected 3 faults, got %+v", faults)
+	}
+
+	if faults[0].Code != "80x0D.0" || faults[1].Code != "80x0E.1" || faults[2].Code != "80x0E.6" {
+		t.Errorf("unexpected fault codes %+v", faults)
+	}
+
+	for _, fault := range FaultCatalogue {
+		if fault.Description == "" || len(fault.Components) == 0 || len(fault.Checks) == 0 {
+			t.Errorf("fault %s is not fully described", fault.Code)
+		}
+	}
+}
+
+func TestFaultHistory(t *testing.T) {
+	tracker := NewFaultTracker()
+	status := rosco.ECUStatus{Connected: true, ECUID: "99000203"}
+
+	tracker.Update(status, &rosco.MemsData{DTC0: rosco.CoolantSensorFaultCode})
+	tracker.Update(status, &rosco.MemsData{DTC1: rosco.FuelPumpFaultCode})
+
+	report := tracker.Report()
+	if len(report.Active) != 1 || report.Active[0].Code != "80x0E.1" {
+		t.Errorf("expected the fuel pump fault to be active, got %+v", report.Active)
+	}
+
+	if len(report.History) != 2 || report.History[0].Active || report.History[0].Samples != 1 {
+		t.Errorf("expected the coolant sensor fault in the history, got %+v", report.History)
+	}
+
+	if report.History[0].LastSeen.Before(report.History[0].FirstSeen) {
+		t.Errorf("last seen is before first seen")
+	}
+
+	// reconnecting starts a new session
+	tracker.Update(rosco.ECUStatus{}, nil)
+	tracker.Update(status, &rosco.MemsData{})
+
+	if report = tracker.Report(); len(report.History) != 0 {
+		t.Errorf("expected the history to be cleared, got %+v", report.History)
+	}
+}
+
+func TestFaultsEndpoint(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	webserver.reader.Acquisition.poll()
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/rosco/faults", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var report FaultReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unable to decode the fault report (%s)", err)
+	}
+
+	if report.ECUID == "" || report.Raw == nil {
+		t.Errorf("expected the faults from the scenario, got %+v", report)
+	}
+}
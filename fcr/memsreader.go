@@ -1,8 +1,13 @@
 package fcr
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	neturl "net/url"
 	"runtime"
+	"time"
 
 	"github.com/andrewdjackson/rosco"
 	"github.com/pkg/browser"
@@ -15,12 +20,36 @@ type MemsReader struct {
 	Config *Config
 	// ECU represents the serial connection to the ECU
 	ECU *rosco.ECUReaderInstance
+	// Queue serialises the commands sent to the ECU
+	Queue *ECUCommandQueue
+	// Acquisition polls the ECU in the background
+	Acquisition *Acquisition
+	// Faults keeps the history of the faults reported by the ECU
+	Faults *FaultTracker
+	// Statistics summarises the dataframes read over the whole session
+	Statistics *SessionStatistics
+	// Audit records the commands that change the state of the ECU
+	Audit *AuditLog
+	// Actuators tracks the actuator tests and switches off actuators left on
+	Actuators *Actuators
+	// Playback controls the replay of scenarios
+	Playback *Playback
+	// Procedures runs the diagnostic procedures
+	Procedures *ProcedureRunner
 	// Webserver
 	WebServer *WebServer
+	// ctx is cancelled when the application should shut down
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewMemsReader(version string, build string, headless bool) *MemsReader {
+// time allowed for the web server to finish the requests in progress when shutting down
+const shutdownTimeout = time.Second * 5
+
+// NewMemsReader creates the reader, cancelling the context shuts the reader down
+func NewMemsReader(ctx context.Context, version string, build string, headless bool) *MemsReader {
 	reader := &MemsReader{}
+	reader.ctx, reader.cancel = context.WithCancel(ctx)
 
 	// read the config
 	reader.Config = ReadConfig()
@@ -32,6 +61,32 @@ func NewMemsReader(version string, build string, headless bool) *MemsReader {
 	// a pre-recorded scenario is played back
 	reader.ECU = rosco.NewECUReaderInstance()
 
+	// all commands to the ECU are sent through the queue
+	reader.Queue = NewECUCommandQueue()
+
+	// scenarios are replayed at the recorded speed as the ECU is polled
+	reader.Playback = NewPlayback()
+
+	// poll the ECU in the background, sampling is independent of the browser
+	reader.Acquisition = NewAcquisition(reader)
+
+	// decode the fault codes in each sample
+	reader.Faults = NewFaultTracker()
+	reader.Acquisition.AddListener(reader.Faults.Update)
+
+	// the session report summarises every sample, not just the recent samples
+	reader.Statistics = NewSessionStatistics()
+	reader.Acquisition.AddListener(reader.Statistics.Update)
+
+	// commands that change the state of the ecu are recorded in the audit log
+	reader.Audit = NewAuditLog()
+
+	// actuator tests are switched off by the server if left on
+	reader.Actuators = NewActuators(reader)
+
+	// diagnostic procedures are run by the server and report their progress to the web server
+	reader.Procedures = NewProcedureRunner(reader)
+
 	// set up the webserver for websocket
 	// and REST endpoints
 	reader.WebServer = NewWebServer(reader, headless)
@@ -39,21 +94,136 @@ func NewMemsReader(version string, build string, headless bool) *MemsReader {
 	return reader
 }
 
+// GetECUStatus returns a copy of the ECU status once any command in progress has completed
+func (reader *MemsReader) GetECUStatus() rosco.ECUStatus {
+	var status rosco.ECUStatus
+
+	reader.Queue.Inspect(func() {
+		status = *reader.ECU.Status
+	})
+
+	return status
+}
+
+// readDataframes reads the raw 0x80 and 0x7d dataframes without writing them to the log,
+// analysing them or moving a scenario on, run this from a queued command
+func (reader *MemsReader) readDataframes() (rosco.DataFrame80, rosco.DataFrame7d, error) {
+	var df80 rosco.DataFrame80
+	var df7d rosco.DataFrame7d
+
+	ecu := reader.ECU
+	if !ecu.Status.Connected || ecu.EcuReader == nil {
+		return df80, df7d, ErrECUNotConnected
+	}
+
+	// the scenario responder moves on once both dataframes have been served
+	if ecu.Responder != nil {
+		position := ecu.Responder.Playbook.Position
+		defer func() {
+			ecu.Responder.Playbook.Position = position
+		}()
+	}
+
+	d80, err := ecu.EcuReader.SendAndReceive(rosco.MEMSReqData80)
+	if err != nil {
+		return df80, df7d, fmt.Errorf("unable to read dataframe 0x80 (%s)", err)
+	}
+
+	d7d, err := ecu.EcuReader.SendAndReceive(rosco.MEMSReqData7D)
+	if err != nil {
+		return df80, df7d, fmt.Errorf("unable to read dataframe 0x7d (%s)", err)
+	}
+
+	if err = binary.Read(bytes.NewReader(d80), binary.BigEndian, &df80); err != nil {
+		return df80, df7d, fmt.Errorf("unable to decode dataframe 0x80 (%s)", err)
+	}
+
+	if err = binary.Read(bytes.NewReader(d7d), binary.BigEndian, &df7d); err != nil {
+		return df80, df7d, fmt.Errorf("unable to decode dataframe 0x7d (%s)", err)
+	}
+
+	return df80, df7d, nil
+}
+
+// ReloadConfig re-reads the config file and applies it to the running reader
+func (reader *MemsReader) ReloadConfig() {
+	config := ReadConfig()
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	config.Version = reader.Config.Version
+	config.Build = reader.Config.Build
+	*reader.Config = *config
+}
+
+// StartAcquisition starts polling the ECU in the background
+func (reader *MemsReader) StartAcquisition() {
+	reader.Acquisition.Start()
+}
+
 func (reader *MemsReader) StartWebServer() {
 	// run the web server as a concurrent process
 	go reader.WebServer.RunHTTPServer()
 
 	// display the web interface, wait for the HTTP Server to start
-	for {
-		if reader.WebServer.ServerRunning {
-			break
+	<-reader.WebServer.started
+}
+
+// Done is closed when the application has been asked to shut down
+func (reader *MemsReader) Done() <-chan struct{} {
+	return reader.ctx.Done()
+}
+
+// Terminate asks the application to shut down
+func (reader *MemsReader) Terminate() {
+	reader.cancel()
+}
+
+// Shutdown stops polling, disconnects the ECU closing the log files
+// and stops the web server once the requests in progress have completed
+func (reader *MemsReader) Shutdown() {
+	log.Infof("shutting down the reader")
+
+	reader.cancel()
+	reader.Procedures.Stop()
+	reader.Acquisition.Stop()
+
+	if reader.GetECUStatus().Connected {
+		if reader.Actuators.IsActive() {
+			if err := reader.Actuators.AllOff(auditClientServer); err != nil {
+				log.Warnf("error switching off the actuators (%s)", err)
+			}
+		}
+
+		// disconnecting closes the serial port and the log, and writes the scenario file
+		if err := reader.Queue.Submit("disconnect", PriorityHigh, defaultCommandTimeout, reader.ECU.Disconnect); err != nil {
+			log.Warnf("error disconnecting from the ecu (%s)", err)
 		}
 	}
+
+	reader.Actuators.Reset()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := reader.WebServer.Shutdown(ctx); err != nil {
+		log.Warnf("error shutting down the web server (%s)", err)
+	}
+
+	reader.Queue.Close()
+
+	log.Infof("reader shut down")
 }
 
 // OpenBrowser opens the browser
 func (reader *MemsReader) OpenBrowser() {
-	url := fmt.Sprintf("http://127.0.0.1:%d/index.html", reader.WebServer.HTTPPort)
+	url := fmt.Sprintf("%s/index.html", reader.WebServer.URL)
+
+	// give the browser the api token, the token is kept in a cookie for the session
+	if token := reader.Config.getToken(); token != "" {
+		url = fmt.Sprintf("%s?%s=%s", url, tokenParameter, neturl.QueryEscape(token))
+	}
 
 	var err error
 
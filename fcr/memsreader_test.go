@@ -0,0 +1,61 @@
+package fcr
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownConnectedReader(t *testing.T) {
+	webserver := newTestWebServer(t)
+	reader := webserver.reader
+
+	served := make(chan struct{})
+	go func() {
+		webserver.RunHTTPServer()
+		close(served)
+	}()
+	<-webserver.started
+
+	if !webserver.ServerRunning {
+		t.Fatalf("expected the web server to be running")
+	}
+
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/fan1", `{"activate":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the fan to be switched on, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	reader.Shutdown()
+
+	// the server switches the fan off before disconnecting
+	entries, _ := reader.Audit.Read(AuditFilter{Command: "test fan1"})
+	if len(entries) != 2 || entries[1].Client != auditClientServer || entries[1].Request != false || entries[1].Result != AuditResultOK {
+		t.Errorf("expected the server to switch the fan off, got %+v", entries)
+	}
+
+	if reader.Actuators.IsActive() || reader.GetECUStatus().Connected {
+		t.Errorf("expected the actuators to be off and the ecu disconnected")
+	}
+
+	select {
+	case <-served:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("expected the web server to stop")
+	}
+
+	select {
+	case <-reader.Done():
+	default:
+		t.Errorf("expected the reader to be done")
+	}
+
+	if err := reader.Queue.Submit("read", PriorityNormal, time.Second, func() error { return nil }); err != ErrECUQueueClosed {
+		t.Errorf("expected the queue to be closed, got %v", err)
+	}
+
+	// shutting down again does nothing
+	reader.Shutdown()
+}
@@ -0,0 +1,364 @@
+package fcr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNotScenario the ecu is not replaying a scenario
+var ErrNotScenario = errors.New("ecu reader is not a scenario playback reader")
+
+// ErrPlaybackSpeed the playback speed is outside the allowed range
+var ErrPlaybackSpeed = fmt.Errorf("playback speed must be between %vx and %vx", minPlaybackSpeed, maxPlaybackSpeed)
+
+// ErrPlaybackMode the playback mode is not one of the playback modes
+var ErrPlaybackMode = fmt.Errorf("playback mode must be %s or %s", PlaybackModePoll, PlaybackModeRealtime)
+
+// ErrPlaybackPosition the position is outside the scenario
+var ErrPlaybackPosition = errors.New("position is outside the scenario")
+
+// range of playback speeds, 1x replays the scenario at the speed it was recorded
+const (
+	minPlaybackSpeed = 0.25
+	maxPlaybackSpeed = 10.0
+)
+
+// playback modes
+const (
+	// PlaybackModePoll moves on by the poll interval each time the ecu is polled,
+	// every dataframe is played even if the polls are slower than the recording
+	PlaybackModePoll = "poll"
+	// PlaybackModeRealtime plays the dataframe recorded at the wall clock time elapsed since play started,
+	// dataframes are skipped if the polls are slower than the recording
+	PlaybackModeRealtime = "realtime"
+)
+
+// PlaybackLoop is the range of positions repeated during playback
+type PlaybackLoop struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// PlaybackState is the state of the scenario playback
+type PlaybackState struct {
+	Playing  bool    `json:"playing"`
+	Mode     string  `json:"mode"`
+	Speed    float64 `json:"speed"`
+	Position int     `json:"position"`
+	Count    int     `json:"count"`
+	// Timestamp is when the dataframe at the position was recorded
+	Timestamp time.Time     `json:"timestamp"`
+	Loop      *PlaybackLoop `json:"loop,omitempty"`
+}
+
+// Playback controls the position the scenario responder replays from.
+// Before each poll the position is moved on by the time elapsed scaled by the speed,
+// using the recorded timestamps, so the scenario plays back at the speed it was recorded.
+// The time elapsed is the poll interval, or the wall clock time in realtime mode.
+type Playback struct {
+	mutex sync.Mutex
+	// scenario is the id of the scenario the ecu was connected to
+	scenario  string
+	responder *rosco.ScenarioResponder
+	// offsets are the recorded times of each dataframe from the start of the scenario
+	offsets  []time.Duration
+	first    time.Time
+	playing  bool
+	mode     string
+	speed    float64
+	position int
+	// cursor is the recorded time being played
+	cursor time.Duration
+	loop   *PlaybackLoop
+	// in realtime mode the cursor is the anchor cursor plus the time elapsed since the anchor time
+	anchor       time.Time
+	anchorCursor time.Duration
+	now          func() time.Time
+}
+
+// NewPlayback creates the playback controls
+func NewPlayback() *Playback {
+	return &Playback{mode: PlaybackModePoll, now: time.Now}
+}
+
+// SetScenario records the scenario the ecu is connected to, empty if the ecu isn't replaying a scenario
+func (playback *Playback) SetScenario(id string) {
+	playback.mutex.Lock()
+	defer playback.mutex.Unlock()
+
+	playback.scenario = strings.TrimPrefix(id, "file:")
+}
+
+// Scenario returns the scenario the ecu is connected to
+func (playback *Playback) Scenario() string {
+	playback.mutex.Lock()
+	defer playback.mutex.Unlock()
+
+	return playback.scenario
+}
+
+// isScenarioReader the ecu is replaying a scenario
+func isScenarioReader(ecu *rosco.ECUReaderInstance) bool {
+	return reflect.TypeOf(ecu.EcuReader) == reflect.TypeOf(&rosco.ScenarioReader{}) && ecu.Responder != nil
+}
+
+// sync loads the scenario when the ecu starts replaying a different scenario,
+// this must be called from an ecu command so the responder isn't changed while it's read
+func (playback *Playback) sync(ecu *rosco.ECUReaderInstance, interval time.Duration) error {
+	if !isScenarioReader(ecu) {
+		playback.responder = nil
+		return ErrNotScenario
+	}
+
+	if playback.responder != ecu.Responder {
+		playback.load(ecu.Responder, interval)
+	}
+
+	return nil
+}
+
+// load resets the playback to play the scenario from the start at 1x
+func (playback *Playback) load(responder *rosco.ScenarioResponder, interval time.Duration) {
+	responses := responder.Playbook.Responses
+
+	playback.responder = responder
+	playback.offsets = make([]time.Duration, len(responses))
+	playback.playing = true
+	playback.speed = 1
+	playback.position = 0
+	playback.cursor = 0
+	playback.loop = nil
+	playback.restart()
+
+	if len(responses) > 0 {
+		playback.first = responses[0].Timestamp
+	}
+
+	for i := 1; i < len(responses); i++ {
+		offset := responses[i].Timestamp.Sub(playback.first)
+
+		// timestamps that couldn't be read are treated as one poll after the previous dataframe
+		if offset <= playback.offsets[i-1] {
+			offset = playback.offsets[i-1] + interval
+		}
+
+		playback.offsets[i] = offset
+	}
+
+	log.Infof("playback loaded %d dataframes (%v)", len(responses), playback.duration())
+}
+
+// prepare moves the responder to the position to be read by the next poll
+func (playback *Playback) prepare(ecu *rosco.ECUReaderInstance, interval time.Duration) {
+	playback.mutex.Lock()
+	defer playback.mutex.Unlock()
+
+	if playback.sync(ecu, interval) != nil || len(playback.offsets) == 0 {
+		return
+	}
+
+	playback.next(interval)
+	playback.responder.Playbook.Position = playback.position
+}
+
+// next moves the position on to the dataframe due to be played
+func (playback *Playback) next(interval time.Duration) {
+	if !playback.playing {
+		return
+	}
+
+	if playback.mode == PlaybackModeRealtime {
+		playback.cursor = playback.anchorCursor + playback.scale(playback.now().Sub(playback.anchor))
+		playback.locate()
+		return
+	}
+
+	playback.advance(playback.scale(interval))
+}
+
+// scale converts the time elapsed to the recorded time played at the playback speed
+func (playback *Playback) scale(elapsed time.Duration) time.Duration {
+	return time.Duration(float64(elapsed) * playback.speed)
+}
+
+// advance moves the cursor on by the recorded time
+func (playback *Playback) advance(elapsed time.Duration) {
+	playback.cursor += elapsed
+	playback.locate()
+}
+
+// restart anchors the realtime playback at the current cursor
+func (playback *Playback) restart() {
+	playback.anchor = playback.now()
+	playback.anchorCursor = playback.cursor
+}
+
+// locate finds the position at the cursor, wrapping to the start of the loop at the end
+func (playback *Playback) locate() {
+	from, to := playback.bounds()
+
+	if playback.cursor > playback.offsets[to] || playback.cursor < playback.offsets[from] {
+		playback.cursor = playback.offsets[from]
+		playback.restart()
+	}
+
+	// the last dataframe recorded at or before the cursor
+	i := sort.Search(to-from+1, func(i int) bool { return playback.offsets[from+i] > playback.cursor })
+	playback.position = from + i - 1
+}
+
+// bounds returns the first and last positions played
+func (playback *Playback) bounds() (int, int) {
+	if playback.loop != nil {
+		return playback.loop.From, playback.loop.To
+	}
+
+	return 0, len(playback.offsets) - 1
+}
+
+func (playback *Playback) duration() time.Duration {
+	if len(playback.offsets) == 0 {
+		return 0
+	}
+
+	return playback.offsets[len(playback.offsets)-1]
+}
+
+// moveTo sets the position, the cursor is moved to when the dataframe was recorded
+func (playback *Playback) moveTo(position int) error {
+	if position < 0 || position >= len(playback.offsets) {
+		return fmt.Errorf("%w (0 to %d)", ErrPlaybackPosition, len(playback.offsets)-1)
+	}
+
+	playback.position = position
+	playback.cursor = playback.offsets[position]
+
+	return nil
+}
+
+// state returns the playback state
+func (playback *Playback) state() PlaybackState {
+	state := PlaybackState{
+		Playing:  playback.playing,
+		Mode:     playback.mode,
+		Speed:    playback.speed,
+		Position: playback.position,
+		Count:    len(playback.offsets),
+	}
+
+	if len(playback.offsets) > 0 {
+		state.Timestamp = playback.first.Add(playback.offsets[playback.position])
+	}
+
+	if playback.loop != nil {
+		loop := *playback.loop
+		state.Loop = &loop
+	}
+
+	return state
+}
+
+// Control changes the playback and moves the responder to the new position,
+// this must be called from an ecu command
+func (playback *Playback) Control(ecu *rosco.ECUReaderInstance, interval time.Duration, change func(playback *Playback) error) (PlaybackState, error) {
+	playback.mutex.Lock()
+	defer playback.mutex.Unlock()
+
+	if err := playback.sync(ecu, interval); err != nil {
+		return PlaybackState{}, err
+	}
+
+	// bring a realtime playback up to date before it's changed
+	if playback.mode == PlaybackModeRealtime && len(playback.offsets) > 0 {
+		playback.next(interval)
+	}
+
+	err := change(playback)
+
+	playback.restart()
+	playback.responder.Playbook.Position = playback.position
+
+	return playback.state(), err
+}
+
+// play starts the playback from the current position
+func (playback *Playback) play() error {
+	playback.playing = true
+	return nil
+}
+
+// pause holds the playback at the current position
+func (playback *Playback) pause() error {
+	playback.playing = false
+	return nil
+}
+
+// step pauses the playback and moves forward, or back if negative, by the number of dataframes
+func (playback *Playback) step(frames int) error {
+	playback.playing = false
+
+	position := playback.position + frames
+	if position < 0 {
+		position = 0
+	}
+
+	if position >= len(playback.offsets) {
+		position = len(playback.offsets) - 1
+	}
+
+	return playback.moveTo(position)
+}
+
+// seek moves to the position
+func (playback *Playback) seek(position int) error {
+	return playback.moveTo(position)
+}
+
+// setSpeed sets the playback speed, 1 plays at the recorded speed
+func (playback *Playback) setSpeed(speed float64) error {
+	if speed < minPlaybackSpeed || speed > maxPlaybackSpeed {
+		return ErrPlaybackSpeed
+	}
+
+	playback.speed = speed
+	return nil
+}
+
+// setMode sets how the time elapsed between polls is measured
+func (playback *Playback) setMode(mode string) error {
+	if mode != PlaybackModePoll && mode != PlaybackModeRealtime {
+		return ErrPlaybackMode
+	}
+
+	playback.mode = mode
+	return nil
+}
+
+// setLoop repeats the playback between the positions, nil plays the whole scenario
+func (playback *Playback) setLoop(loop *PlaybackLoop) error {
+	if loop == nil {
+		playback.loop = nil
+		return nil
+	}
+
+	if loop.From < 0 || loop.To >= len(playback.offsets) || loop.From >= loop.To {
+		return fmt.Errorf("%w, the loop must be from 0 to %d and end after it starts", ErrPlaybackPosition, len(playback.offsets)-1)
+	}
+
+	playback.loop = &PlaybackLoop{From: loop.From, To: loop.To}
+
+	// start the loop if the position is outside it
+	if playback.position < loop.From || playback.position > loop.To {
+		return playback.moveTo(loop.From)
+	}
+
+	return nil
+}
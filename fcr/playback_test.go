@@ -0,0 +1,219 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// newTestPlayback loads a scenario recorded every 500ms
+func newTestPlayback(count int) *Playback {
+	responder := rosco.NewResponder()
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < count; i++ {
+		responder.Playbook.Responses = append(responder.Playbook.Responses, rosco.PlaybookResponse{Timestamp: start.Add(time.Duration(i) * time.Millisecond * 500)})
+	}
+
+	responder.Playbook.Count = count
+
+	playback := NewPlayback()
+	playback.load(responder, time.Second)
+
+	return playback
+}
+
+func TestPlaybackUsesRecordedTimestamps(t *testing.T) {
+	tests := []struct {
+		speed    float64
+		polls    int
+		position int
+	}{
+		// polled every 250ms, the position moves every other poll at 1x
+		{1, 1, 0},
+		{1, 2, 1},
+		{1, 6, 3},
+		{0.25, 7, 0},
+		{0.25, 8, 1},
+		{4, 3, 6},
+		// wraps to the start at the end of the scenario
+		{10, 4, 0},
+	}
+
+	for _, test := range tests {
+		playback := newTestPlayback(10)
+		_ = playback.setSpeed(test.speed)
+
+		for i := 0; i < test.polls; i++ {
+			playback.advance(time.Duration(float64(time.Millisecond*250) * playback.speed))
+		}
+
+		if playback.position != test.position {
+			t.Errorf("expected position %d after %d polls at %vx, got %d", test.position, test.polls, test.speed, playback.position)
+		}
+	}
+}
+
+func TestRealtimePlaybackFollowsWallClock(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	playback := newTestPlayback(20)
+	playback.now = func() time.Time { return now }
+	_ = playback.setMode(PlaybackModeRealtime)
+	playback.restart()
+
+	tests := []struct {
+		elapsed  time.Duration
+		polls    int
+		position int
+	}{
+		// the number of polls makes no difference, only the time elapsed
+		{time.Millisecond * 1200, 1, 2},
+		{0, 5, 2},
+		{time.Millisecond * 300, 1, 3},
+		{time.Second * 2, 1, 7},
+	}
+
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+
+		for i := 0; i < test.polls; i++ {
+			playback.next(time.Second)
+		}
+
+		if playback.position != test.position {
+			t.Errorf("expected position %d after %v, got %d", test.position, test.elapsed, playback.position)
+		}
+	}
+
+	// changing the speed continues from the current position
+	_ = playback.setSpeed(2)
+	playback.restart()
+
+	now = now.Add(time.Second)
+	playback.next(time.Second)
+
+	if playback.position != 11 {
+		t.Errorf("expected position 11 after 1s at 2x, got %d", playback.position)
+	}
+
+	// paused playback doesn't move however much time passes
+	_ = playback.pause()
+	now = now.Add(time.Minute)
+	playback.next(time.Second)
+
+	if playback.position != 11 {
+		t.Errorf("expected the paused playback to stay at 11, got %d", playback.position)
+	}
+}
+
+func TestPlaybackLoop(t *testing.T) {
+	playback := newTestPlayback(10)
+
+	if err := playback.setLoop(&PlaybackLoop{From: 4, To: 6}); err != nil {
+		t.Fatalf("unable to set the loop (%s)", err)
+	}
+
+	if playback.position != 4 {
+		t.Fatalf("expected the playback to move to the start of the loop, got %d", playback.position)
+	}
+
+	var positions []int
+	for i := 0; i < 4; i++ {
+		playback.advance(time.Millisecond * 500)
+		positions = append(positions, playback.position)
+	}
+
+	if positions[0] != 5 || positions[1] != 6 || positions[2] != 4 || positions[3] != 5 {
+		t.Errorf("expected the playback to repeat 5, 6, 4, 5 got %v", positions)
+	}
+
+	for _, loop := range []PlaybackLoop{{From: 6, To: 4}, {From: -1, To: 4}, {From: 2, To: 10}} {
+		if err := playback.setLoop(&loop); err == nil {
+			t.Errorf("expected the loop %+v to be rejected", loop)
+		}
+	}
+}
+
+func TestPlaybackStep(t *testing.T) {
+	playback := newTestPlayback(10)
+
+	_ = playback.step(3)
+	if playback.playing || playback.position != 3 {
+		t.Errorf("expected the playback to pause at 3, got %d (playing %t)", playback.position, playback.playing)
+	}
+
+	_ = playback.step(-5)
+	if playback.position != 0 {
+		t.Errorf("expected the step back to stop at the start, got %d", playback.position)
+	}
+
+	_ = playback.step(20)
+	if playback.position != 9 {
+		t.Errorf("expected the step forward to stop at the end, got %d", playback.position)
+	}
+}
+
+func TestPlaybackEndpoints(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/pause", "")
+	expectError(t, w, http.StatusServiceUnavailable, ErrorCodeNotConnected)
+
+	connectTestScenario(t, webserver)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/speed", `{"speed":20}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/mode", `{"mode":"fast"}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/loop", `{"from":5,"to":50}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/step", `{"frames":5}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var state PlaybackState
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatalf("unable to decode the playback state (%s)", err)
+	}
+
+	if state.Playing || state.Position != 5 || state.Count != 20 {
+		t.Errorf("expected the playback to be paused at 5 of 20, got %+v", state)
+	}
+
+	// paused playback reads the same dataframe on every poll
+	for i := 0; i < 3; i++ {
+		webserver.reader.Acquisition.poll()
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/playback", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &state)
+
+	if state.Position != 5 {
+		t.Errorf("expected the paused playback to stay at 5, got %d", state.Position)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/speed", `{"speed":2}`)
+	_ = json.Unmarshal(w.Body.Bytes(), &state)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/play", "")
+	_ = json.Unmarshal(w.Body.Bytes(), &state)
+
+	if !state.Playing || state.Speed != 2 || state.Mode != PlaybackModePoll {
+		t.Errorf("expected the playback to play at 2x, got %+v", state)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/playback/mode", `{"mode":"realtime"}`)
+	_ = json.Unmarshal(w.Body.Bytes(), &state)
+
+	if state.Mode != PlaybackModeRealtime {
+		t.Errorf("expected realtime playback, got %+v", state)
+	}
+}
@@ -0,0 +1,465 @@
+package fcr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// the diagnostic procedures
+const (
+	ProcedureIAC      = "iac"
+	ProcedureLambda   = "lambda"
+	ProcedureCoolant  = "coolant"
+	ProcedureFuelPump = "fuelpump"
+)
+
+// procedureTimings are how long each procedure waits and samples for
+type procedureTimings struct {
+	// settle is the time the engine is given to respond to a change
+	settle time.Duration
+	// sample is how long the dataframes are sampled for a measurement
+	sample time.Duration
+	// wait is how long the technician is given to do what the instruction asks
+	wait time.Duration
+	// warmUp is how long the engine is given to reach operating temperature
+	warmUp time.Duration
+	// prime is how long the fuel pump is run
+	prime time.Duration
+}
+
+var defaultProcedureTimings = procedureTimings{
+	settle: time.Second * 5,
+	sample: time.Second * 10,
+	wait:   time.Minute * 2,
+	warmUp: time.Minute * 15,
+	prime:  time.Second * 3,
+}
+
+// pass / fail thresholds
+const (
+	// idle speed range and how much it may hunt while idling
+	idleMinRPM       = 600
+	idleMaxRPM       = 1200
+	idleMaxVariation = 150
+	// steps the IAC valve is opened and the rise in idle speed expected
+	iacTestSteps    = 10
+	iacMinRPMChange = 50
+	// the idle speed should return to within this of the base idle
+	iacMaxRPMReturn = 100
+	// rpm the lambda switching is measured at
+	lambdaTestRPM = 2000
+	// the lambda sensor switches either side of the midpoint,
+	// reaching below the lean and above the rich voltages (mV)
+	lambdaMidpoint    = 450
+	lambdaLeanVoltage = 300
+	lambdaRichVoltage = 600
+	// minimum number of switches every 10 seconds
+	lambdaMinSwitches = 5
+	// operating temperature and the plausible coolant readings (°C)
+	coolantWarmTemp = 80
+	coolantMinTemp  = -30
+	coolantMaxTemp  = 130
+	// the largest fall in temperature allowed while the engine warms up
+	coolantMaxDrop = 3
+)
+
+// getDiagnosticProcedures returns the procedures in the order they are listed
+func getDiagnosticProcedures(timings procedureTimings) []*Procedure {
+	return []*Procedure{
+		getIACProcedure(timings),
+		getLambdaProcedure(timings),
+		getCoolantProcedure(timings),
+		getFuelPumpProcedure(timings),
+	}
+}
+
+// getIACProcedure checks the idle air control valve changes the idle speed
+func getIACProcedure(timings procedureTimings) *Procedure {
+	return &Procedure{
+		Name:        ProcedureIAC,
+		Title:       "Idle air control check",
+		Description: "Opens the idle air control valve and checks the idle speed rises and then returns to the base idle.",
+		Steps: []ProcedureStep{
+			{
+				Name:        "idle",
+				Instruction: "Start the engine and let it idle with the throttle closed.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("idle between %d and %d rpm varying by less than %d rpm", idleMinRPM, idleMaxRPM, idleMaxVariation)
+
+					if _, err := run.waitFor(timings.wait, isIdling); err != nil {
+						return err
+					}
+
+					samples, err := run.collectSamples(timings.sample)
+					if err != nil {
+						return err
+					}
+
+					rpm := getStatistics(samples, func(memsdata rosco.MemsData) float64 { return float64(memsdata.EngineRPM) })
+					report.Measured = map[string]float64{"rpm": rpm.mean, "minRpm": rpm.min, "maxRpm": rpm.max}
+					run.values["rpm"] = rpm.mean
+
+					if rpm.mean < idleMinRPM || rpm.mean > idleMaxRPM {
+						return fmt.Errorf("idle speed %.0f rpm is outside %d to %d rpm", rpm.mean, idleMinRPM, idleMaxRPM)
+					}
+
+					if rpm.max-rpm.min > idleMaxVariation {
+						return fmt.Errorf("idle speed is hunting by %.0f rpm", rpm.max-rpm.min)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:        "open",
+				Instruction: "Keep the engine idling while the idle air control valve is opened.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("idle speed rises by at least %d rpm", iacMinRPMChange)
+
+					if err := run.adjust(AdjustmentIAC, iacTestSteps); err != nil {
+						return err
+					}
+					run.values["iacSteps"] = iacTestSteps
+
+					rpm, err := run.settleAndMeasureRPM(timings)
+					if err != nil {
+						return err
+					}
+
+					change := rpm - run.values["rpm"]
+					report.Measured = map[string]float64{"rpm": rpm, "change": change}
+
+					if change < iacMinRPMChange {
+						return fmt.Errorf("idle speed changed by %.0f rpm, the valve may be sticking", change)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:        "close",
+				Instruction: "Keep the engine idling while the idle air control valve is returned.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("idle speed returns to within %d rpm of the base idle", iacMaxRPMReturn)
+
+					if err := run.adjust(AdjustmentIAC, -iacTestSteps); err != nil {
+						return err
+					}
+					run.values["iacSteps"] = 0
+
+					rpm, err := run.settleAndMeasureRPM(timings)
+					if err != nil {
+						return err
+					}
+
+					difference := rpm - run.values["rpm"]
+					report.Measured = map[string]float64{"rpm": rpm, "difference": difference}
+
+					if math.Abs(difference) > iacMaxRPMReturn {
+						return fmt.Errorf("idle speed is %.0f rpm from the base idle", difference)
+					}
+
+					return nil
+				},
+			},
+		},
+		cleanup: func(run *ProcedureRun) {
+			// return the valve if the procedure stopped while it was open
+			if steps := int(run.values["iacSteps"]); steps != 0 {
+				_ = run.adjust(AdjustmentIAC, -steps)
+			}
+		},
+	}
+}
+
+// getLambdaProcedure checks the lambda sensor switches between rich and lean in closed loop
+func getLambdaProcedure(timings procedureTimings) *Procedure {
+	return &Procedure{
+		Name:        ProcedureLambda,
+		Title:       "Lambda switching test",
+		Description: "Checks the lambda sensor switches between rich and lean while the engine is warm and in closed loop.",
+		Steps: []ProcedureStep{
+			{
+				Name:        "warm",
+				Instruction: "Run the engine until it reaches operating temperature.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("coolant at least %d°C", coolantWarmTemp)
+
+					memsdata, err := run.waitFor(timings.warmUp, func(memsdata rosco.MemsData) bool {
+						return memsdata.EngineRPM > 0 && memsdata.CoolantTemp >= coolantWarmTemp
+					})
+
+					report.Measured = map[string]float64{"coolant": float64(memsdata.CoolantTemp)}
+					return err
+				},
+			},
+			{
+				Name:        "closedloop",
+				Instruction: fmt.Sprintf("Hold the engine above %d rpm until the ECU enters closed loop.", lambdaTestRPM),
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = "closed loop"
+
+					memsdata, err := run.waitFor(timings.wait, isClosedLoop)
+
+					report.Measured = map[string]float64{"rpm": float64(memsdata.EngineRPM)}
+					return err
+				},
+			},
+			{
+				Name:        "switching",
+				Instruction: fmt.Sprintf("Keep holding the engine above %d rpm.", lambdaTestRPM),
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("switches below %dmV and above %dmV at least %d times every 10 seconds", lambdaLeanVoltage, lambdaRichVoltage, lambdaMinSwitches)
+
+					samples, err := run.collectSamples(timings.sample)
+					if err != nil {
+						return err
+					}
+
+					lambda := getStatistics(samples, func(memsdata rosco.MemsData) float64 { return float64(memsdata.LambdaVoltage) })
+					switches := float64(countLambdaSwitches(samples)) * float64(time.Second*10) / float64(timings.sample)
+					report.Measured = map[string]float64{"minVoltage": lambda.min, "maxVoltage": lambda.max, "switches": switches}
+
+					for _, memsdata := range samples {
+						if !isClosedLoop(memsdata) {
+							return fmt.Errorf("the engine left closed loop at %d rpm", memsdata.EngineRPM)
+						}
+					}
+
+					if lambda.min > lambdaLeanVoltage || lambda.max < lambdaRichVoltage {
+						return fmt.Errorf("lambda voltage only ranged from %.0fmV to %.0fmV, the sensor may be lazy", lambda.min, lambda.max)
+					}
+
+					if switches < lambdaMinSwitches {
+						return fmt.Errorf("lambda switched %.1f times every 10 seconds, the sensor may be slow", switches)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// getCoolantProcedure checks the coolant temperature sensor reading rises steadily as the engine warms up
+func getCoolantProcedure(timings procedureTimings) *Procedure {
+	return &Procedure{
+		Name:        ProcedureCoolant,
+		Title:       "Coolant sensor warm-up test",
+		Description: "Follows the coolant temperature from a cold start to operating temperature checking it rises steadily.",
+		Steps: []ProcedureStep{
+			{
+				Name:        "start",
+				Instruction: "Start the engine from cold and let it idle.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("coolant between %d°C and %d°C", coolantMinTemp, coolantWarmTemp)
+
+					memsdata, err := run.waitFor(timings.wait, func(memsdata rosco.MemsData) bool { return memsdata.EngineRPM > 0 })
+					if err != nil {
+						return err
+					}
+
+					report.Measured = map[string]float64{"coolant": float64(memsdata.CoolantTemp)}
+					run.values["coolant"] = float64(memsdata.CoolantTemp)
+
+					if memsdata.CoolantTempSensorFault {
+						return fmt.Errorf("the ECU reports a coolant sensor fault")
+					}
+
+					if memsdata.CoolantTemp < coolantMinTemp || memsdata.CoolantTemp > coolantMaxTemp {
+						return fmt.Errorf("coolant reading %d°C is not plausible", memsdata.CoolantTemp)
+					}
+
+					if memsdata.CoolantTemp >= coolantWarmTemp {
+						return fmt.Errorf("the engine is already warm (%d°C)", memsdata.CoolantTemp)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:        "warmup",
+				Instruction: "Keep the engine idling until it reaches operating temperature.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = fmt.Sprintf("reaches %d°C without falling by more than %d°C", coolantWarmTemp, coolantMaxDrop)
+
+					started := time.Now()
+					highest := int(run.values["coolant"])
+
+					var failure error
+					memsdata, err := run.waitFor(timings.warmUp, func(memsdata rosco.MemsData) bool {
+						switch {
+						case memsdata.CoolantTempSensorFault:
+							failure = fmt.Errorf("the ECU reports a coolant sensor fault")
+						case highest-memsdata.CoolantTemp > coolantMaxDrop:
+							failure = fmt.Errorf("coolant reading fell from %d°C to %d°C, the sensor may be erratic", highest, memsdata.CoolantTemp)
+						case memsdata.CoolantTemp > highest:
+							highest = memsdata.CoolantTemp
+						}
+
+						return failure != nil || memsdata.CoolantTemp >= coolantWarmTemp
+					})
+
+					minutes := time.Since(started).Minutes()
+					report.Measured = map[string]float64{"coolant": float64(highest), "minutes": minutes}
+					if minutes > 0 {
+						report.Measured["rate"] = (float64(highest) - run.values["coolant"]) / minutes
+					}
+
+					if err == ErrProcedureTimeout {
+						return fmt.Errorf("coolant only reached %d°C, the thermostat may be stuck open", highest)
+					}
+
+					if err != nil {
+						return err
+					}
+
+					report.Measured["coolant"] = float64(memsdata.CoolantTemp)
+					return failure
+				},
+			},
+		},
+	}
+}
+
+// getFuelPumpProcedure runs the fuel pump with the engine stopped and checks no circuit fault is reported
+func getFuelPumpProcedure(timings procedureTimings) *Procedure {
+	return &Procedure{
+		Name:        ProcedureFuelPump,
+		Title:       "Fuel pump prime test",
+		Description: "Runs the fuel pump with the ignition on and the engine stopped, checking the ECU doesn't report a fuel pump circuit fault.",
+		Steps: []ProcedureStep{
+			{
+				Name:        "stopped",
+				Instruction: "Switch the ignition on without starting the engine.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = "engine stopped"
+
+					_, err := run.waitFor(timings.wait, func(memsdata rosco.MemsData) bool { return memsdata.EngineRPM == 0 })
+					return err
+				},
+			},
+			{
+				Name:        "prime",
+				Instruction: "Listen for the fuel pump running.",
+				run: func(run *ProcedureRun, report *ProcedureStepReport) error {
+					report.Threshold = "no fuel pump circuit fault"
+
+					if err := run.reader.Actuators.Activate(run.report.Client, ActuatorFuelPump, true); err != nil {
+						return err
+					}
+
+					samples, err := run.collectSamples(timings.prime)
+
+					if offErr := run.reader.Actuators.Activate(run.report.Client, ActuatorFuelPump, false); err == nil {
+						err = offErr
+					}
+
+					if err != nil {
+						return err
+					}
+
+					faults := 0
+					for _, memsdata := range samples {
+						if memsdata.FuelPumpCircuitFault || memsdata.DTC1&rosco.FuelPumpFaultCode != 0 {
+							faults++
+						}
+					}
+
+					report.Measured = map[string]float64{"samples": float64(len(samples)), "faults": float64(faults)}
+
+					if faults > 0 {
+						return fmt.Errorf("the ECU reported a fuel pump circuit fault in %d samples", faults)
+					}
+
+					return nil
+				},
+			},
+		},
+		cleanup: func(run *ProcedureRun) {
+			// make sure the pump isn't left running if the procedure was aborted
+			if state, err := run.reader.Actuators.State(ActuatorFuelPump); err == nil && state.Active {
+				_ = run.reader.Actuators.Activate(run.report.Client, ActuatorFuelPump, false)
+			}
+		},
+	}
+}
+
+// isIdling the engine is running with the throttle closed
+func isIdling(memsdata rosco.MemsData) bool {
+	return memsdata.EngineRPM > 0 && memsdata.IdleSwitch
+}
+
+// isClosedLoop the engine is above the lambda test speed in closed loop
+func isClosedLoop(memsdata rosco.MemsData) bool {
+	return memsdata.ClosedLoop && memsdata.EngineRPM >= lambdaTestRPM
+}
+
+// countLambdaSwitches counts the times the lambda voltage crosses the midpoint
+func countLambdaSwitches(samples []rosco.MemsData) int {
+	switches := 0
+
+	for i := 1; i < len(samples); i++ {
+		rich := samples[i].LambdaVoltage > lambdaMidpoint
+		if rich != (samples[i-1].LambdaVoltage > lambdaMidpoint) {
+			switches++
+		}
+	}
+
+	return switches
+}
+
+// statistics are the minimum, mean and maximum of a dataframe value
+type statistics struct {
+	min, mean, max float64
+}
+
+// getStatistics returns the statistics of the value over the samples
+func getStatistics(samples []rosco.MemsData, value func(memsdata rosco.MemsData) float64) statistics {
+	var stats statistics
+
+	if len(samples) == 0 {
+		return stats
+	}
+
+	stats.min = math.Inf(1)
+	stats.max = math.Inf(-1)
+
+	for _, memsdata := range samples {
+		v := value(memsdata)
+		stats.min = math.Min(stats.min, v)
+		stats.max = math.Max(stats.max, v)
+		stats.mean += v
+	}
+
+	stats.mean /= float64(len(samples))
+
+	return stats
+}
+
+// collectSamples collects the samples over the duration, returning an error if no samples were read
+func (run *ProcedureRun) collectSamples(duration time.Duration) ([]rosco.MemsData, error) {
+	samples, err := run.collect(duration)
+	if err == nil && len(samples) == 0 {
+		err = ErrNoDataframes
+	}
+
+	return samples, err
+}
+
+// settleAndMeasureRPM waits for the engine to settle and returns the mean engine speed
+func (run *ProcedureRun) settleAndMeasureRPM(timings procedureTimings) (float64, error) {
+	if err := run.pause(timings.settle); err != nil {
+		return 0, err
+	}
+
+	samples, err := run.collectSamples(timings.sample)
+	if err != nil {
+		return 0, err
+	}
+
+	return getStatistics(samples, func(memsdata rosco.MemsData) float64 { return float64(memsdata.EngineRPM) }).mean, nil
+}
@@ -0,0 +1,419 @@
+package fcr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrUnknownProcedure the procedure is not one of the diagnostic procedures
+var ErrUnknownProcedure = errors.New("unknown procedure")
+
+// ErrProcedureRunning only one procedure can be run at a time
+var ErrProcedureRunning = errors.New("a procedure is already running")
+
+// ErrProcedureAborted the procedure was aborted before it finished
+var ErrProcedureAborted = errors.New("procedure aborted")
+
+// ErrProcedureTimeout the condition the step was waiting for was not met in time
+var ErrProcedureTimeout = errors.New("timed out waiting for the condition")
+
+// the state of a procedure and each of its steps
+const (
+	ProcedurePending = "pending"
+	ProcedureRunning = "running"
+	ProcedurePassed  = "passed"
+	ProcedureFailed  = "failed"
+	ProcedureAborted = "aborted"
+	ProcedureSkipped = "skipped"
+)
+
+// number of samples buffered for the running procedure
+const procedureSampleBufferSize = 64
+
+// Procedure is a scripted diagnostic test, each step is run in turn until one fails
+type Procedure struct {
+	Name        string          `json:"name"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Steps       []ProcedureStep `json:"steps"`
+	// cleanup puts the engine back as it was, it is run however the procedure finishes
+	cleanup func(run *ProcedureRun)
+}
+
+// ProcedureStep is a single state of a procedure
+type ProcedureStep struct {
+	Name string `json:"name"`
+	// Instruction tells the technician what to do during the step
+	Instruction string `json:"instruction"`
+	// run returns an error if the step failed
+	run func(run *ProcedureRun, report *ProcedureStepReport) error
+}
+
+// ProcedureStepReport is the progress and result of a step
+type ProcedureStepReport struct {
+	Name        string    `json:"name"`
+	Instruction string    `json:"instruction"`
+	State       string    `json:"state"`
+	Started     time.Time `json:"started,omitempty"`
+	Finished    time.Time `json:"finished,omitempty"`
+	// Measured are the values measured during the step
+	Measured map[string]float64 `json:"measured,omitempty"`
+	// Threshold describes the pass criteria
+	Threshold string `json:"threshold,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ProcedureReport is the progress and result of running a procedure
+type ProcedureReport struct {
+	Procedure string                `json:"procedure"`
+	Title     string                `json:"title"`
+	ECUID     string                `json:"ecuId"`
+	Client    string                `json:"client"`
+	State     string                `json:"state"`
+	Started   time.Time             `json:"started"`
+	Finished  time.Time             `json:"finished,omitempty"`
+	Step      int                   `json:"step"`
+	Steps     []ProcedureStepReport `json:"steps"`
+	Message   string                `json:"message,omitempty"`
+}
+
+// ProcedureRun is a procedure in progress
+type ProcedureRun struct {
+	reader *MemsReader
+	runner *ProcedureRunner
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed once the procedure has finished and cleaned up
+	done    chan struct{}
+	samples chan rosco.MemsData
+	report  ProcedureReport
+	// values are measured by one step and used by the later steps
+	values map[string]float64
+}
+
+// ProcedureListener is called with the report each time a procedure makes progress
+type ProcedureListener func(report ProcedureReport)
+
+// ProcedureRunner runs the diagnostic procedures one at a time and keeps the last report for each
+type ProcedureRunner struct {
+	mutex      sync.Mutex
+	reader     *MemsReader
+	procedures map[string]*Procedure
+	names      []string
+	current    *ProcedureRun
+	reports    map[string]ProcedureReport
+	listeners  []ProcedureListener
+}
+
+// NewProcedureRunner creates the runner with the diagnostic procedures
+func NewProcedureRunner(reader *MemsReader) *ProcedureRunner {
+	runner := &ProcedureRunner{}
+	runner.reader = reader
+	runner.procedures = make(map[string]*Procedure)
+	runner.reports = make(map[string]ProcedureReport)
+
+	for _, procedure := range getDiagnosticProcedures(defaultProcedureTimings) {
+		runner.add(procedure)
+	}
+
+	reader.Acquisition.AddListener(runner.update)
+
+	return runner
+}
+
+func (runner *ProcedureRunner) add(procedure *Procedure) {
+	runner.procedures[procedure.Name] = procedure
+	runner.names = append(runner.names, procedure.Name)
+}
+
+// AddListener registers a listener to be notified as procedures make progress
+func (runner *ProcedureRunner) AddListener(listener ProcedureListener) {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	runner.listeners = append(runner.listeners, listener)
+}
+
+// Procedures returns the available procedures
+func (runner *ProcedureRunner) Procedures() []Procedure {
+	procedures := make([]Procedure, 0, len(runner.names))
+	for _, name := range runner.names {
+		procedures = append(procedures, *runner.procedures[name])
+	}
+
+	return procedures
+}
+
+// Start runs the procedure in the background for the client, progress is sent to the listeners
+func (runner *ProcedureRunner) Start(name string, client string) (ProcedureReport, error) {
+	procedure, ok := runner.procedures[name]
+	if !ok {
+		return ProcedureReport{}, ErrUnknownProcedure
+	}
+
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	if runner.current != nil {
+		return runner.reports[runner.current.report.Procedure], ErrProcedureRunning
+	}
+
+	run := &ProcedureRun{reader: runner.reader, runner: runner}
+	run.ctx, run.cancel = context.WithCancel(runner.reader.ctx)
+	run.samples = make(chan rosco.MemsData, procedureSampleBufferSize)
+	run.values = make(map[string]float64)
+	run.done = make(chan struct{})
+	run.report = ProcedureReport{
+		Procedure: procedure.Name,
+		Title:     procedure.Title,
+		ECUID:     runner.reader.GetECUStatus().ECUID,
+		Client:    client,
+		State:     ProcedureRunning,
+		Started:   time.Now(),
+		Steps:     make([]ProcedureStepReport, len(procedure.Steps)),
+	}
+
+	for i, step := range procedure.Steps {
+		run.report.Steps[i] = ProcedureStepReport{Name: step.Name, Instruction: step.Instruction, State: ProcedurePending}
+	}
+
+	report := run.report.copy()
+	runner.current = run
+	runner.reports[name] = report
+
+	log.Infof("procedure %s started", name)
+	go run.run(procedure)
+
+	return report, nil
+}
+
+// Abort stops the procedure if it is running and waits for it to clean up
+func (runner *ProcedureRunner) Abort(name string) error {
+	if _, ok := runner.procedures[name]; !ok {
+		return ErrUnknownProcedure
+	}
+
+	runner.mutex.Lock()
+	run := runner.current
+	runner.mutex.Unlock()
+
+	if run != nil && run.report.Procedure == name {
+		run.cancel()
+		<-run.done
+	}
+
+	return nil
+}
+
+// Stop aborts the running procedure, if there is one, and waits for it to clean up
+func (runner *ProcedureRunner) Stop() {
+	runner.mutex.Lock()
+	run := runner.current
+	runner.mutex.Unlock()
+
+	if run != nil {
+		run.cancel()
+		<-run.done
+	}
+}
+
+// Report returns the progress of the running procedure or the report from the last time it was run
+func (runner *ProcedureRunner) Report(name string) (ProcedureReport, bool) {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	report, ok := runner.reports[name]
+	return report, ok
+}
+
+// update passes the sample to the running procedure, this is an acquisition listener
+func (runner *ProcedureRunner) update(status rosco.ECUStatus, sample *rosco.MemsData) {
+	runner.mutex.Lock()
+	run := runner.current
+	runner.mutex.Unlock()
+
+	if run == nil || sample == nil {
+		return
+	}
+
+	select {
+	case run.samples <- *sample:
+	default:
+		log.Warnf("procedure %s is not keeping up, dropped sample", run.report.Procedure)
+	}
+}
+
+// publish saves the report and sends it to the listeners
+func (runner *ProcedureRunner) publish(report ProcedureReport) {
+	report = report.copy()
+
+	runner.mutex.Lock()
+	runner.reports[report.Procedure] = report
+	listeners := runner.listeners
+	runner.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(report)
+	}
+}
+
+// finish clears the running procedure
+func (runner *ProcedureRunner) finish(run *ProcedureRun) {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	if runner.current == run {
+		runner.current = nil
+	}
+}
+
+// copy returns a copy of the report that isn't changed as the procedure runs
+func (report ProcedureReport) copy() ProcedureReport {
+	report.Steps = append([]ProcedureStepReport(nil), report.Steps...)
+	return report
+}
+
+// run steps through the procedure until a step fails or the procedure is aborted
+func (run *ProcedureRun) run(procedure *Procedure) {
+	defer close(run.done)
+	defer run.runner.finish(run)
+	defer run.cancel()
+
+	// the procedure passes if every step passes
+	state := ProcedurePassed
+
+	for i, step := range procedure.Steps {
+		report := &run.report.Steps[i]
+
+		if state != ProcedurePassed {
+			report.State = ProcedureSkipped
+			continue
+		}
+
+		run.report.Step = i
+		report.State = ProcedureRunning
+		report.Started = time.Now()
+		run.runner.publish(run.report)
+
+		log.Infof("procedure %s step %s", procedure.Name, step.Name)
+
+		err := run.checkConnected()
+		if err == nil {
+			err = step.run(run, report)
+		}
+
+		report.Finished = time.Now()
+
+		switch {
+		case err == nil:
+			report.State = ProcedurePassed
+		case run.ctx.Err() != nil:
+			report.State = ProcedureAborted
+			report.Message = ErrProcedureAborted.Error()
+		default:
+			report.State = ProcedureFailed
+			report.Message = err.Error()
+		}
+
+		state = report.State
+		run.runner.publish(run.report)
+	}
+
+	if procedure.cleanup != nil {
+		procedure.cleanup(run)
+	}
+
+	run.report.State = state
+	run.report.Finished = time.Now()
+	run.report.Message = fmt.Sprintf("%s %s", procedure.Title, run.report.State)
+
+	log.Infof("procedure %s %s", procedure.Name, run.report.State)
+	run.runner.publish(run.report)
+}
+
+// checkConnected returns an error if the ECU is not connected
+func (run *ProcedureRun) checkConnected() error {
+	if !run.reader.GetECUStatus().Connected {
+		return ErrECUNotConnected
+	}
+
+	return nil
+}
+
+// collect returns the samples read by the acquisition over the duration
+func (run *ProcedureRun) collect(duration time.Duration) ([]rosco.MemsData, error) {
+	var samples []rosco.MemsData
+
+	run.drain()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	for {
+		select {
+		case sample := <-run.samples:
+			samples = append(samples, sample)
+		case <-timer.C:
+			return samples, nil
+		case <-run.ctx.Done():
+			return samples, ErrProcedureAborted
+		}
+	}
+}
+
+// waitFor waits until a sample meets the condition
+func (run *ProcedureRun) waitFor(timeout time.Duration, condition func(memsdata rosco.MemsData) bool) (rosco.MemsData, error) {
+	run.drain()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case sample := <-run.samples:
+			if condition(sample) {
+				return sample, nil
+			}
+		case <-timer.C:
+			return rosco.MemsData{}, ErrProcedureTimeout
+		case <-run.ctx.Done():
+			return rosco.MemsData{}, ErrProcedureAborted
+		}
+	}
+}
+
+// pause waits for the duration unless the procedure is aborted
+func (run *ProcedureRun) pause(duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-run.ctx.Done():
+		return ErrProcedureAborted
+	}
+}
+
+// drain discards the samples read before the current step
+func (run *ProcedureRun) drain() {
+	for {
+		select {
+		case <-run.samples:
+		default:
+			return
+		}
+	}
+}
+
+// adjust steps the adjustment, the adjustment is recorded in the audit log in the same way as a manual adjustment
+func (run *ProcedureRun) adjust(adjustment string, steps int) error {
+	_, err := run.reader.AdjustBySteps(run.report.Client, adjustment, steps)
+	return err
+}
@@ -0,0 +1,217 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+var testProcedureTimings = procedureTimings{
+	settle: time.Millisecond * 10,
+	sample: time.Millisecond * 100,
+	wait:   time.Millisecond * 500,
+	warmUp: time.Millisecond * 500,
+	prime:  time.Millisecond * 50,
+}
+
+// newTestProcedureRunner connects the test scenario and shortens the procedures so the tests run quickly
+func newTestProcedureRunner(t *testing.T) (*WebServer, *ProcedureRunner) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	runner := webserver.reader.Procedures
+	for _, procedure := range getDiagnosticProcedures(testProcedureTimings) {
+		runner.procedures[procedure.Name] = procedure
+	}
+
+	return webserver, runner
+}
+
+// runTestProcedure feeds the samples to the running procedure until it finishes
+func runTestProcedure(t *testing.T, runner *ProcedureRunner, name string, sample func(i int) rosco.MemsData) ProcedureReport {
+	if _, err := runner.Start(name, "test"); err != nil {
+		t.Fatalf("unable to start procedure %s (%s)", name, err)
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for i := 0; time.Now().Before(deadline); i++ {
+		if report, _ := runner.Report(name); report.State != ProcedureRunning {
+			return report
+		}
+
+		memsdata := sample(i)
+		runner.update(rosco.ECUStatus{Connected: true}, &memsdata)
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	t.Fatalf("procedure %s did not finish", name)
+	return ProcedureReport{}
+}
+
+func TestFuelPumpProcedurePasses(t *testing.T) {
+	webserver, runner := newTestProcedureRunner(t)
+
+	report := runTestProcedure(t, runner, ProcedureFuelPump, func(i int) rosco.MemsData {
+		return rosco.MemsData{EngineRPM: 0}
+	})
+
+	if report.State != ProcedurePassed {
+		t.Fatalf("expected the procedure to pass, got %s (%+v)", report.State, report.Steps)
+	}
+
+	if webserver.reader.Actuators.IsActive() {
+		t.Errorf("expected the fuel pump to be switched off")
+	}
+
+	entries, _ := webserver.reader.Audit.Read(AuditFilter{Command: "test " + ActuatorFuelPump})
+	if len(entries) != 2 {
+		t.Errorf("expected the fuel pump on and off to be audited, got %d entries", len(entries))
+	}
+}
+
+func TestFuelPumpProcedureFailsWithCircuitFault(t *testing.T) {
+	_, runner := newTestProcedureRunner(t)
+
+	report := runTestProcedure(t, runner, ProcedureFuelPump, func(i int) rosco.MemsData {
+		return rosco.MemsData{EngineRPM: 0, DTC1: rosco.FuelPumpFaultCode}
+	})
+
+	if report.State != ProcedureFailed || report.Steps[1].State != ProcedureFailed {
+		t.Fatalf("expected the prime step to fail, got %s (%+v)", report.State, report.Steps)
+	}
+
+	if report.Steps[1].Measured["faults"] == 0 {
+		t.Errorf("expected the faults to be measured")
+	}
+}
+
+func TestLambdaProcedure(t *testing.T) {
+	tests := []struct {
+		name    string
+		voltage func(i int) int
+		state   string
+	}{
+		{"switching", func(i int) int { return []int{150, 800}[i%2] }, ProcedurePassed},
+		{"lazy", func(i int) int { return 400 + (i%2)*100 }, ProcedureFailed},
+		{"stuck", func(i int) int { return 450 }, ProcedureFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, runner := newTestProcedureRunner(t)
+
+			report := runTestProcedure(t, runner, ProcedureLambda, func(i int) rosco.MemsData {
+				return rosco.MemsData{EngineRPM: 2500, CoolantTemp: 90, ClosedLoop: true, LambdaVoltage: test.voltage(i)}
+			})
+
+			if report.State != test.state {
+				t.Errorf("expected %s, got %s (%+v)", test.state, report.State, report.Steps)
+			}
+		})
+	}
+}
+
+func TestCoolantProcedureFailsErraticSensor(t *testing.T) {
+	_, runner := newTestProcedureRunner(t)
+
+	report := runTestProcedure(t, runner, ProcedureCoolant, func(i int) rosco.MemsData {
+		// the reading jumps about rather than rising steadily
+		return rosco.MemsData{EngineRPM: 900, CoolantTemp: 20 + (i%3)*10}
+	})
+
+	if report.State != ProcedureFailed || report.Steps[1].State != ProcedureFailed {
+		t.Errorf("expected the warm-up step to fail, got %s (%+v)", report.State, report.Steps)
+	}
+}
+
+func TestIACProcedureReturnsValveWhenFailed(t *testing.T) {
+	webserver, runner := newTestProcedureRunner(t)
+
+	// the idle speed doesn't change as the valve is opened
+	report := runTestProcedure(t, runner, ProcedureIAC, func(i int) rosco.MemsData {
+		return rosco.MemsData{EngineRPM: 850, IdleSwitch: true}
+	})
+
+	if report.State != ProcedureFailed || report.Steps[1].State != ProcedureFailed || report.Steps[2].State != ProcedureSkipped {
+		t.Fatalf("expected the open step to fail, got %s (%+v)", report.State, report.Steps)
+	}
+
+	entries, _ := webserver.reader.Audit.Read(AuditFilter{Command: "adjust " + AdjustmentIAC})
+	if len(entries) != 2 || entries[0].Request != float64(iacTestSteps) || entries[1].Request != float64(-iacTestSteps) {
+		t.Errorf("expected the valve to be opened and returned, got %+v", entries)
+	}
+
+	for _, entry := range entries {
+		if entry.Before == nil || entry.After == nil {
+			t.Errorf("expected the values before and after in the audit entry, got %+v", entry)
+		}
+	}
+}
+
+func TestAbortProcedure(t *testing.T) {
+	webserver, runner := newTestProcedureRunner(t)
+
+	// waits for the engine to start, no samples are sent so it waits until aborted
+	if _, err := runner.Start(ProcedureCoolant, "test"); err != nil {
+		t.Fatalf("unable to start the procedure (%s)", err)
+	}
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/procedures/"+ProcedureLambda, "")
+	expectError(t, w, http.StatusConflict, ErrorCodeProcedureRunning)
+
+	w = sendTestRequest(t, webserver, http.MethodDelete, "/rosco/procedures/"+ProcedureCoolant, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var report ProcedureReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unable to decode the report (%s)", err)
+	}
+
+	if report.State != ProcedureAborted || report.Steps[1].State != ProcedureSkipped {
+		t.Errorf("expected the procedure to be aborted, got %s (%+v)", report.State, report.Steps)
+	}
+}
+
+func TestProcedureEndpoints(t *testing.T) {
+	webserver, _ := newTestProcedureRunner(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/procedures/unknown", "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/rosco/procedures/"+ProcedureIAC, "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/procedures/"+ProcedureIAC, "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/rosco/procedures", "")
+
+	var listing []ProcedureListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("unable to decode the procedures (%s)", err)
+	}
+
+	if len(listing) != 4 || listing[0].Name != ProcedureIAC || listing[0].Report == nil || len(listing[0].Steps) != 3 {
+		t.Errorf("expected the 4 procedures with the iac check running, got %+v", listing)
+	}
+
+	webserver.reader.Procedures.Stop()
+}
+
+func TestCountLambdaSwitches(t *testing.T) {
+	var samples []rosco.MemsData
+	for _, voltage := range []int{100, 800, 700, 200, 300, 900} {
+		samples = append(samples, rosco.MemsData{LambdaVoltage: voltage})
+	}
+
+	if switches := countLambdaSwitches(samples); switches != 3 {
+		t.Errorf("expected 3 switches, got %d", switches)
+	}
+}
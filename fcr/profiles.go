@@ -0,0 +1,203 @@
+package fcr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ini.v1"
+)
+
+// ErrProfileNotFound is returned when the named profile is not in the config file
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Profile is a named vehicle configuration, each profile is stored as
+// a section in the config file named after the profile
+type Profile struct {
+	Name string `json:"name"`
+	// Port is the serial port of the adapter used with the vehicle
+	Port string `json:"port"`
+	// Frequency is the ECU polling interval in milliseconds, 0 uses the config frequency
+	Frequency    int    `json:"frequency"`
+	VIN          string `json:"vin"`
+	Registration string `json:"registration"`
+	ECUID        string `json:"ecuId"`
+	Notes        string `json:"notes"`
+}
+
+// Profiles lists the profiles and the selected profile
+type Profiles struct {
+	Selected string    `json:"selected"`
+	Profiles []Profile `json:"profiles"`
+}
+
+// Validate checks the profile can be saved and applied,
+// returns the reason each invalid field was rejected
+func (profile *Profile) Validate() FieldErrors {
+	invalid := FieldErrors{}
+
+	name := strings.TrimSpace(profile.Name)
+	if name == "" {
+		invalid["name"] = "name must not be empty"
+	} else if strings.ContainsAny(name, "[]") || strings.EqualFold(name, ini.DefaultSection) {
+		invalid["name"] = fmt.Sprintf("%s is not a valid profile name", name)
+	}
+
+	if profile.Port == "" {
+		invalid["port"] = "port must not be empty"
+	}
+
+	if profile.Frequency != 0 && (profile.Frequency < minFrequency || profile.Frequency > maxFrequency) {
+		invalid["frequency"] = fmt.Sprintf("frequency must be 0 or between %d and %d milliseconds", minFrequency, maxFrequency)
+	}
+
+	return invalid
+}
+
+// ReadProfiles returns the profiles in the config file sorted by name
+func ReadProfiles() ([]Profile, error) {
+	profiles := []Profile{}
+
+	cfg, err := ini.Load(getConfigFilename())
+	if err != nil {
+		return profiles, err
+	}
+
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+
+		profiles = append(profiles, readProfile(section))
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles, nil
+}
+
+// ReadProfile returns the named profile
+func ReadProfile(name string) (Profile, error) {
+	cfg, err := ini.Load(getConfigFilename())
+	if err != nil {
+		return Profile{}, err
+	}
+
+	section, err := cfg.GetSection(name)
+	if err != nil || name == ini.DefaultSection {
+		return Profile{}, ErrProfileNotFound
+	}
+
+	return readProfile(section), nil
+}
+
+// WriteProfile creates or replaces the profile in the config file
+func WriteProfile(profile Profile) error {
+	filename := getConfigFilename()
+
+	cfg, err := ini.LooseLoad(filename)
+	if err != nil {
+		return err
+	}
+
+	profile.Name = strings.TrimSpace(profile.Name)
+	cfg.DeleteSection(profile.Name)
+	section := cfg.Section(profile.Name)
+
+	section.Key("port").SetValue(profile.Port)
+	section.Key("frequency").SetValue(strconv.Itoa(profile.Frequency))
+	section.Key("vin").SetValue(profile.VIN)
+	section.Key("registration").SetValue(profile.Registration)
+	section.Key("ecuid").SetValue(profile.ECUID)
+	section.Key("notes").SetValue(profile.Notes)
+
+	log.Infof("saving profile %s to %s", profile.Name, filename)
+
+	return cfg.SaveTo(filename)
+}
+
+// DeleteProfile removes the profile from the config file,
+// the profile is deselected if it's the selected profile
+func DeleteProfile(name string) error {
+	filename := getConfigFilename()
+
+	cfg, err := ini.Load(filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err = cfg.GetSection(name); err != nil || name == ini.DefaultSection {
+		return ErrProfileNotFound
+	}
+
+	cfg.DeleteSection(name)
+
+	if cfg.Section("").Key("profile").String() == name {
+		cfg.Section("").Key("profile").SetValue("")
+	}
+
+	log.Infof("deleting profile %s from %s", name, filename)
+
+	return cfg.SaveTo(filename)
+}
+
+// SelectProfile saves the profile as the selected profile, an empty name deselects the profile
+func SelectProfile(name string) error {
+	filename := getConfigFilename()
+
+	cfg, err := ini.LooseLoad(filename)
+	if err != nil {
+		return err
+	}
+
+	if name != "" {
+		if _, err = cfg.GetSection(name); err != nil || name == ini.DefaultSection {
+			return ErrProfileNotFound
+		}
+	}
+
+	cfg.Section("").Key("profile").SetValue(name)
+
+	log.Infof("selecting profile '%s' in %s", name, filename)
+
+	return cfg.SaveTo(filename)
+}
+
+// applyProfile applies the port and frequency of the profile to the config,
+// values overridden by the environment or command line are not changed
+func (c *Config) applyProfile(name string) error {
+	profile, err := ReadProfile(name)
+	if err != nil {
+		return err
+	}
+
+	if source := c.Sources["port"]; source != ConfigSourceEnv && source != ConfigSourceFlag {
+		c.Port = profile.Port
+		c.Sources["port"] = ConfigSourceProfile
+	}
+
+	if source := c.Sources["frequency"]; profile.Frequency != 0 && source != ConfigSourceEnv && source != ConfigSourceFlag {
+		c.Frequency = profile.Frequency
+		c.Sources["frequency"] = ConfigSourceProfile
+	}
+
+	return nil
+}
+
+func readProfile(section *ini.Section) Profile {
+	profile := Profile{Name: section.Name()}
+
+	profile.Port = section.Key("port").String()
+	profile.Frequency, _ = section.Key("frequency").Int()
+	profile.VIN = section.Key("vin").String()
+	profile.Registration = section.Key("registration").String()
+	profile.ECUID = section.Key("ecuid").String()
+	profile.Notes = section.Key("notes").String()
+
+	return profile
+}
@@ -0,0 +1,49 @@
+package fcr
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConvertLogToScenario converts a CSV log file into a scenario file alongside the log
+func ConvertLogToScenario(source string) (ScenarioConversion, error) {
+	conversion := ScenarioConversion{Source: source}
+
+	if !strings.HasSuffix(strings.ToLower(source), ".csv") {
+		return conversion, fmt.Errorf("cannot convert %s, only csv log files can be converted to scenarios", source)
+	}
+
+	log.Infof("converting logfile %s to scenario", source)
+
+	scenarioFile := source[:len(source)-len(".csv")] + ".fcr"
+	s := rosco.NewScenarioFile(scenarioFile)
+
+	if err := s.ConvertLogToScenario(source); err != nil {
+		return conversion, fmt.Errorf("error converting scenario file %s (%s)", scenarioFile, err)
+	}
+
+	if err := s.Write(); err != nil {
+		return conversion, fmt.Errorf("error writing scenario file %s (%s)", scenarioFile, err)
+	}
+
+	conversion.Result = true
+	conversion.Destination = scenarioFile
+
+	return conversion, nil
+}
+
+// ExportScenario writes the contents of the scenario file
+func ExportScenario(id string, w io.Writer) error {
+	data, err := rosco.GetScenarioContents(id)
+
+	if err != nil {
+		return fmt.Errorf("unable to read scenario %s (%s)", id, err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,176 @@
+package fcr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBookmarkNotFound the scenario has no bookmark with the name
+var ErrBookmarkNotFound = errors.New("bookmark not found")
+
+// bookmarks are saved alongside the scenario file, scenario.fcr has the bookmarks in scenario.bookmarks.json
+const bookmarkFileSuffix = ".bookmarks.json"
+
+// ScenarioBookmark names a position in the scenario
+type ScenarioBookmark struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Note     string `json:"note,omitempty"`
+	// Time is when the dataframe at the position was recorded
+	Time    string    `json:"time"`
+	Created time.Time `json:"created"`
+}
+
+// ScenarioBookmarks are the bookmarks for a scenario in position order
+type ScenarioBookmarks struct {
+	Scenario  string             `json:"scenario"`
+	Bookmarks []ScenarioBookmark `json:"bookmarks"`
+}
+
+// bookmarkMutex serialises changes to the bookmark files
+var bookmarkMutex sync.Mutex
+
+// getBookmarkFile returns the path to the bookmarks for the scenario file
+func getBookmarkFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + bookmarkFileSuffix
+}
+
+// ReadBookmarks returns the bookmarks saved for the scenario
+func ReadBookmarks(id string) (ScenarioBookmarks, error) {
+	bookmarkMutex.Lock()
+	defer bookmarkMutex.Unlock()
+
+	return readBookmarks(id)
+}
+
+func readBookmarks(id string) (ScenarioBookmarks, error) {
+	bookmarks := ScenarioBookmarks{Scenario: id, Bookmarks: []ScenarioBookmark{}}
+
+	file, err := getScenarioFile(id)
+	if err != nil {
+		return bookmarks, err
+	}
+
+	data, err := ioutil.ReadFile(getBookmarkFile(file))
+	if os.IsNotExist(err) {
+		return bookmarks, nil
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &bookmarks)
+	}
+
+	if err != nil {
+		return bookmarks, fmt.Errorf("unable to read the bookmarks for %s (%s)", id, err)
+	}
+
+	bookmarks.Scenario = id
+	return bookmarks, nil
+}
+
+// GetBookmark returns the named bookmark
+func GetBookmark(id string, name string) (ScenarioBookmark, error) {
+	bookmarks, err := ReadBookmarks(id)
+	if err != nil {
+		return ScenarioBookmark{}, err
+	}
+
+	for _, bookmark := range bookmarks.Bookmarks {
+		if bookmark.Name == name {
+			return bookmark, nil
+		}
+	}
+
+	return ScenarioBookmark{}, fmt.Errorf("%w (%s)", ErrBookmarkNotFound, name)
+}
+
+// SaveBookmark adds the bookmark to the scenario, replacing any bookmark with the same name
+func SaveBookmark(id string, bookmark ScenarioBookmark, samples []rosco.MemsData) (ScenarioBookmark, error) {
+	if bookmark.Position < 0 || bookmark.Position >= len(samples) {
+		return bookmark, fmt.Errorf("%w (0 to %d)", ErrPlaybackPosition, len(samples)-1)
+	}
+
+	bookmark.Time = samples[bookmark.Position].Time
+	bookmark.Created = time.Now()
+
+	bookmarkMutex.Lock()
+	defer bookmarkMutex.Unlock()
+
+	bookmarks, err := readBookmarks(id)
+	if err != nil {
+		return bookmark, err
+	}
+
+	saved := []ScenarioBookmark{bookmark}
+	for _, existing := range bookmarks.Bookmarks {
+		if existing.Name != bookmark.Name {
+			saved = append(saved, existing)
+		}
+	}
+
+	bookmarks.Bookmarks = saved
+
+	log.Infof("saving bookmark %s at %d in %s", bookmark.Name, bookmark.Position, id)
+	return bookmark, writeBookmarks(id, bookmarks)
+}
+
+// DeleteBookmark removes the named bookmark
+func DeleteBookmark(id string, name string) error {
+	bookmarkMutex.Lock()
+	defer bookmarkMutex.Unlock()
+
+	bookmarks, err := readBookmarks(id)
+	if err != nil {
+		return err
+	}
+
+	saved := []ScenarioBookmark{}
+	for _, existing := range bookmarks.Bookmarks {
+		if existing.Name != name {
+			saved = append(saved, existing)
+		}
+	}
+
+	if len(saved) == len(bookmarks.Bookmarks) {
+		return fmt.Errorf("%w (%s)", ErrBookmarkNotFound, name)
+	}
+
+	bookmarks.Bookmarks = saved
+
+	log.Infof("deleting bookmark %s in %s", name, id)
+	return writeBookmarks(id, bookmarks)
+}
+
+// writeBookmarks saves the bookmarks in position order
+func writeBookmarks(id string, bookmarks ScenarioBookmarks) error {
+	file, err := getScenarioFile(id)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(bookmarks.Bookmarks, func(i, j int) bool {
+		return bookmarks.Bookmarks[i].Position < bookmarks.Bookmarks[j].Position
+	})
+
+	data, err := json.MarshalIndent(bookmarks, "", " ")
+	if err == nil {
+		err = ioutil.WriteFile(getBookmarkFile(file), data, 0644)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to save the bookmarks for %s (%s)", id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package fcr
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+const scenarioUsage = `usage: memsfcr scenario <command> [arguments]
+
+commands:
+  list    [-json] [-folder path]        list the scenarios and log files
+  info    [-json] <scenario>            show the details of a scenario
+  convert [-json] <log.csv|folder>...   convert csv log files to scenario files
+  export  [-o file] [-format fcr|csv|jsonl|columnar] <scenario>
+                                        write the scenario to a file or stdout
+  trim    [-json] [-o name] <scenario> <from> <to>
+                                        write the positions from and to, inclusive, to a new scenario
+  split   [-json] [-bookmark name | -at position] <scenario>
+                                        split the scenario in two at a bookmark or position
+  merge   [-json] [-o name] <scenario> <scenario>...
+                                        concatenate scenarios from the same ecu into a new scenario
+`
+
+// RunScenarioCommand runs the scenario subcommand without starting the web server,
+// the output is written as a table or as JSON
+func RunScenarioCommand(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing scenario command\n%s", scenarioUsage)
+	}
+
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "list":
+		return listScenariosCommand(args, stdout)
+	case "info":
+		return scenarioInfoCommand(args, stdout)
+	case "convert":
+		return convertScenariosCommand(args, stdout)
+	case "export":
+		return exportScenarioCommand(args, stdout)
+	case "trim":
+		return trimScenarioCommand(args, stdout)
+	case "split":
+		return splitScenarioCommand(args, stdout)
+	case "merge":
+		return mergeScenariosCommand(args, stdout)
+	default:
+		return fmt.Errorf("unknown scenario command %s\n%s", command, scenarioUsage)
+	}
+}
+
+func listScenariosCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output as json")
+	folder := flags.String("folder", "", "folder containing the scenarios (default the log folder)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *folder != "" {
+		// the scenario folder is expected to end with a path separator
+		*folder = filepath.Clean(*folder) + string(filepath.Separator)
+	}
+
+	scenarios, err := rosco.GetScenarios(*folder)
+	if err != nil {
+		return fmt.Errorf("unable to list scenarios (%s)", err)
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, scenarios)
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "NAME\tTYPE\tDATE\tDURATION\tCOUNT\tSUMMARY")
+
+	for _, scenario := range scenarios {
+		_, _ = fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			scenario.Name,
+			scenario.FileType,
+			scenario.Date.Format("2006-01-02 15:04:05"),
+			scenario.Duration,
+			scenario.Count,
+			scenario.Summary)
+	}
+
+	return table.Flush()
+}
+
+func scenarioInfoCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output as json")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected a single scenario\n%s", scenarioUsage)
+	}
+
+	id := flags.Arg(0)
+	scenario := rosco.GetScenario(id)
+
+	if scenario.Count == 0 {
+		return fmt.Errorf("scenario %s not found or empty", id)
+	}
+
+	if *asJSON {
+		return writeJSON(stdout, scenario)
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(table, "Name:\t%s\n", scenario.Name)
+	_, _ = fmt.Fprintf(table, "Records:\t%d\n", scenario.Count)
+	_, _ = fmt.Fprintf(table, "First:\t%s\n", scenario.Details.First.Timestamp.Format("2006-01-02 15:04:05.000"))
+	_, _ = fmt.Fprintf(table, "Last:\t%s\n", scenario.Details.Last.Timestamp.Format("2006-01-02 15:04:05.000"))
+	_, _ = fmt.Fprintf(table, "Duration:\t%v\n", scenario.Details.Last.Timestamp.Sub(scenario.Details.First.Timestamp))
+
+	return table.Flush()
+}
+
+func convertScenariosCommand(args []string, stdout io.Writer) error {
+	var conversions []ScenarioConversion
+	var failed int
+
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output as json")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expected log files or folders to convert\n%s", scenarioUsage)
+	}
+
+	sources, err := getLogFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		conversion, err := ConvertLogToScenario(source)
+		if err != nil {
+			failed++
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+
+		conversions = append(conversions, conversion)
+	}
+
+	if *asJSON {
+		err = writeJSON(stdout, conversions)
+	} else {
+		table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(table, "SOURCE\tDESTINATION\tRESULT")
+
+		for _, conversion := range conversions {
+			_, _ = fmt.Fprintf(table, "%s\t%s\t%t\n", conversion.Source, conversion.Destination, conversion.Result)
+		}
+
+		err = table.Flush()
+	}
+
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d of %d log files failed to convert", failed, len(sources))
+	}
+
+	return err
+}
+
+func exportScenarioCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the scenario to (default stdout)")
+	format := flags.String("format", ExportFCR, "format to export, one of "+strings.Join(ExportFormats, ", "))
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected a single scenario\n%s", scenarioUsage)
+	}
+
+	*format = strings.ToLower(*format)
+	if !isExportFormat(*format) {
+		return fmt.Errorf("%w %s\n%s", ErrExportFormat, *format, scenarioUsage)
+	}
+
+	w := stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("unable to create %s (%s)", *output, err)
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	return ExportScenarioAs(flags.Arg(0), *format, w)
+}
+
+func trimScenarioCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("trim", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output as json")
+	name := flags.String("o", "", "name of the new scenario (default named after the scenario and positions)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 3 {
+		return fmt.Errorf("expected a scenario and the positions to trim to\n%s", scenarioUsage)
+	}
+
+	from, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid from position %s\n%s", flags.Arg(1), scenarioUsage)
+	}
+
+	to, err := strconv.Atoi(flags.Arg(2))
+	if err != nil {
+		return fmt.Errorf("invalid to position %s\n%s", flags.Arg(2), scenarioUsage)
+	}
+
+	edit, err := TrimScenario(flags.Arg(0), from, to, *name)
+	if err != nil {
+		return err
+	}
+
+	return writeScenarioEdit(stdout, edit, *asJSON)
+}
+
+func splitScenarioCommand(args []string, stdout io.Writer) error {
+	var edit ScenarioEdit
+	var err error
+
+	flags := flag.NewFlagSet("split", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output as json")
+	bookmark := flags.String("bookmark", "", "split at the named bookmark")
+	position := flags.Int("at", 0, "split at the position, the position starts the second scenario")
+
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected a single scenario\n%s", scenarioUsage)
+	}
+
+	if *bookmark != "" {
+		edit, err = SplitScenarioAtBookmark(flags.Arg(0), *bookmark)
+	} else {
+		edit, err = SplitScenario(flags.Arg(0), *position)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return writeScenarioEdit(stdout, edit, *asJSON)
+}
+
+func mergeScenariosCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "output as json")
+	name := flags.String("o", "", "name of the new scenario (default named after the first scenario)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("expected at least two scenarios to merge\n%s", scenarioUsage)
+	}
+
+	edit, err := MergeScenarios(flags.Args(), *name)
+	if err != nil {
+		return err
+	}
+
+	return writeScenarioEdit(stdout, edit, *asJSON)
+}
+
+// writeScenarioEdit lists the scenarios created by the edit
+func writeScenarioEdit(w io.Writer, edit ScenarioEdit, asJSON bool) error {
+	if asJSON {
+		return writeJSON(w, edit)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "NAME\tCOUNT\tFIRST\tLAST\tFILE")
+
+	for _, scenario := range edit.Scenarios {
+		_, _ = fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%s\n", scenario.Name, scenario.Count, scenario.First, scenario.Last, scenario.File)
+	}
+
+	return table.Flush()
+}
+
+// getLogFiles expands any folders into the csv log files they contain
+func getLogFiles(paths []string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+
+		if err != nil || !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return files, fmt.Errorf("unable to read folder %s (%s)", path, err)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), ".csv") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	return files, nil
+}
+
+func writeJSON(w io.Writer, data interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(data)
+}
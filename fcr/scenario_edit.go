@@ -0,0 +1,322 @@
+package fcr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrScenarioExists scenarios are never overwritten by trimming, splitting or merging
+var ErrScenarioExists = errors.New("scenario already exists")
+
+// ErrScenarioRange the positions are outside the scenario
+var ErrScenarioRange = errors.New("invalid scenario range")
+
+// ErrScenarioECU only scenarios recorded from the same ECU can be merged
+var ErrScenarioECU = errors.New("scenarios were recorded from different ecus")
+
+// the operations that create new scenarios from existing ones
+const (
+	ScenarioTrim  = "trim"
+	ScenarioSplit = "split"
+	ScenarioMerge = "merge"
+)
+
+// ScenarioPart is a scenario created by trimming, splitting or merging
+type ScenarioPart struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Count int    `json:"count"`
+	// First and Last are when the first and last dataframes were recorded
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// ScenarioEdit is the result of trimming, splitting or merging scenarios
+type ScenarioEdit struct {
+	Operation string         `json:"operation"`
+	Sources   []string       `json:"sources"`
+	Scenarios []ScenarioPart `json:"scenarios"`
+}
+
+// TrimScenario writes the dataframes from position to position, inclusive, to a new scenario.
+// The new scenario is named after the source and the positions unless a name is given
+func TrimScenario(id string, from int, to int, name string) (ScenarioEdit, error) {
+	edit := ScenarioEdit{Operation: ScenarioTrim, Sources: []string{id}, Scenarios: []ScenarioPart{}}
+
+	source, err := loadScenarioFile(id)
+	if err != nil {
+		return edit, err
+	}
+
+	if from < 0 || to >= len(source.RawData) || from > to {
+		return edit, fmt.Errorf("%w, %d to %d is not within 0 to %d", ErrScenarioRange, from, to, len(source.RawData)-1)
+	}
+
+	if name == "" {
+		name = getScenarioPartName(id, fmt.Sprintf("%d-%d", from, to))
+	}
+
+	summary := fmt.Sprintf("%s trimmed to positions %d to %d", source.Name, from, to)
+	part, err := writeScenarioPart(name, source, source.RawData[from:to+1], summary)
+	if err != nil {
+		return edit, err
+	}
+
+	copyBookmarks(id, part.Name, from, to, -from)
+	edit.Scenarios = append(edit.Scenarios, part)
+
+	return edit, nil
+}
+
+// SplitScenario writes the dataframes before the position and from the position onwards
+// to two new scenarios, named after the source with -1 and -2 suffixes
+func SplitScenario(id string, position int) (ScenarioEdit, error) {
+	edit := ScenarioEdit{Operation: ScenarioSplit, Sources: []string{id}, Scenarios: []ScenarioPart{}}
+
+	source, err := loadScenarioFile(id)
+	if err != nil {
+		return edit, err
+	}
+
+	// both parts must have at least one dataframe
+	if position < 1 || position >= len(source.RawData) {
+		return edit, fmt.Errorf("%w, split at %d is not within 1 to %d", ErrScenarioRange, position, len(source.RawData)-1)
+	}
+
+	// check both parts can be written before writing either
+	names := []string{getScenarioPartName(id, "1"), getScenarioPartName(id, "2")}
+	for _, name := range names {
+		if _, err := getScenarioPartFile(name); err != nil {
+			return edit, err
+		}
+	}
+
+	parts := []struct {
+		from int
+		to   int
+	}{
+		{0, position - 1},
+		{position, len(source.RawData) - 1},
+	}
+
+	for i, p := range parts {
+		summary := fmt.Sprintf("%s split at %d, part %d of 2", source.Name, position, i+1)
+		part, err := writeScenarioPart(names[i], source, source.RawData[p.from:p.to+1], summary)
+		if err != nil {
+			return edit, err
+		}
+
+		copyBookmarks(id, part.Name, p.from, p.to, -p.from)
+		edit.Scenarios = append(edit.Scenarios, part)
+	}
+
+	return edit, nil
+}
+
+// SplitScenarioAtBookmark splits the scenario at the position of the named bookmark
+func SplitScenarioAtBookmark(id string, bookmark string) (ScenarioEdit, error) {
+	b, err := GetBookmark(id, bookmark)
+	if err != nil {
+		return ScenarioEdit{Operation: ScenarioSplit, Sources: []string{id}, Scenarios: []ScenarioPart{}}, err
+	}
+
+	return SplitScenario(id, b.Position)
+}
+
+// MergeScenarios concatenates the scenarios in the order they were recorded into a new scenario.
+// The scenarios must have been recorded from the same ECU
+func MergeScenarios(ids []string, name string) (ScenarioEdit, error) {
+	var sources []*rosco.ScenarioFile
+
+	edit := ScenarioEdit{Operation: ScenarioMerge, Sources: ids, Scenarios: []ScenarioPart{}}
+
+	if len(ids) < 2 {
+		return edit, fmt.Errorf("%w, at least two scenarios are needed to merge", ErrScenarioRange)
+	}
+
+	for _, id := range ids {
+		source, err := loadScenarioFile(id)
+		if err != nil {
+			return edit, err
+		}
+
+		if len(source.RawData) == 0 {
+			return edit, fmt.Errorf("%w, %s is empty", ErrScenarioRange, id)
+		}
+
+		sources = append(sources, source)
+	}
+
+	for i, source := range sources {
+		if source.ECUID != sources[0].ECUID {
+			return edit, fmt.Errorf("%w, %s is from ecu %q and %s is from ecu %q", ErrScenarioECU, ids[0], sources[0].ECUID, ids[i], source.ECUID)
+		}
+	}
+
+	// order by the time the first dataframe was recorded, the recorded times sort as text
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return sources[order[i]].RawData[0].Time < sources[order[j]].RawData[0].Time
+	})
+
+	if name == "" {
+		name = getScenarioPartName(ids[order[0]], "merged")
+	}
+
+	var rawdata []*rosco.RawData
+	var names []string
+
+	for _, i := range order {
+		rawdata = append(rawdata, sources[i].RawData...)
+		names = append(names, sources[i].Name)
+	}
+
+	summary := fmt.Sprintf("merged from %s", strings.Join(names, ", "))
+	part, err := writeScenarioPart(name, sources[order[0]], rawdata, summary)
+	if err != nil {
+		return edit, err
+	}
+
+	offset := 0
+	for _, i := range order {
+		copyBookmarks(ids[i], part.Name, 0, len(sources[i].RawData)-1, offset)
+		offset += len(sources[i].RawData)
+	}
+
+	edit.Scenarios = append(edit.Scenarios, part)
+
+	return edit, nil
+}
+
+// loadScenarioFile reads the scenario, csv log files are converted as they are read
+func loadScenarioFile(id string) (*rosco.ScenarioFile, error) {
+	file, err := getScenarioFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario := rosco.NewScenarioFile(file)
+
+	if strings.HasSuffix(strings.ToLower(file), ".csv") {
+		err = scenario.ConvertLogToScenario(file)
+	} else {
+		err = scenario.Read()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read scenario %s (%s)", id, err)
+	}
+
+	if scenario.Name == "" {
+		scenario.Name = filepath.Base(file)
+	}
+
+	return scenario, nil
+}
+
+// getScenarioPartName names the new scenario after the source with the suffix
+func getScenarioPartName(id string, suffix string) string {
+	name := strings.TrimSuffix(id, filepath.Ext(id))
+	return fmt.Sprintf("%s-%s.fcr", name, suffix)
+}
+
+// getScenarioPartFile returns the path to the new scenario in the log folder, an error if it already exists
+func getScenarioPartFile(name string) (string, error) {
+	if !strings.HasSuffix(strings.ToLower(name), ".fcr") {
+		name = name + ".fcr"
+	}
+
+	file, err := getScenarioPath(name)
+	if err != nil {
+		return file, err
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		return file, fmt.Errorf("%w (%s)", ErrScenarioExists, filepath.Base(file))
+	}
+
+	return file, nil
+}
+
+// writeScenarioPart writes the dataframes to a new scenario, keeping the ECU details of the source
+func writeScenarioPart(name string, source *rosco.ScenarioFile, rawdata []*rosco.RawData, summary string) (ScenarioPart, error) {
+	part := ScenarioPart{}
+
+	file, err := getScenarioPartFile(name)
+	if err != nil {
+		return part, err
+	}
+
+	scenario := rosco.NewScenarioFile(file)
+	scenario.Name = filepath.Base(file)
+	scenario.Count = len(rawdata)
+	scenario.Summary = summary
+	scenario.ECUID = source.ECUID
+	scenario.ECUSerial = source.ECUSerial
+	scenario.RawData = rawdata
+
+	// the scenario is dated when the first dataframe was recorded
+	if recorded, err := rosco.ConvertTimeFieldToDate(rawdata[0].Time); err == nil {
+		scenario.Date = recorded
+	}
+
+	log.Infof("writing %d dataframes to scenario %s (%s)", scenario.Count, file, summary)
+
+	if err = scenario.Write(); err != nil {
+		return part, fmt.Errorf("unable to write scenario %s (%s)", scenario.Name, err)
+	}
+
+	part.Name = scenario.Name
+	part.File = file
+	part.Count = scenario.Count
+	part.First = rawdata[0].Time
+	part.Last = rawdata[len(rawdata)-1].Time
+
+	return part, nil
+}
+
+// copyBookmarks copies the bookmarks between the positions to the new scenario, moving them by the offset
+func copyBookmarks(id string, scenario string, from int, to int, offset int) {
+	bookmarkMutex.Lock()
+	defer bookmarkMutex.Unlock()
+
+	source, err := readBookmarks(id)
+	if err != nil {
+		log.Warnf("unable to copy the bookmarks from %s (%s)", id, err)
+		return
+	}
+
+	bookmarks, err := readBookmarks(scenario)
+	if err != nil {
+		log.Warnf("unable to copy the bookmarks to %s (%s)", scenario, err)
+		return
+	}
+
+	count := len(bookmarks.Bookmarks)
+	for _, bookmark := range source.Bookmarks {
+		if bookmark.Position >= from && bookmark.Position <= to {
+			bookmark.Position += offset
+			bookmarks.Bookmarks = append(bookmarks.Bookmarks, bookmark)
+		}
+	}
+
+	if len(bookmarks.Bookmarks) == count {
+		return
+	}
+
+	bookmarks.Scenario = scenario
+	if err = writeBookmarks(scenario, bookmarks); err != nil {
+		log.Warnf("unable to copy the bookmarks to %s (%s)", scenario, err)
+	}
+}
@@ -0,0 +1,176 @@
+package fcr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+func readTestScenarioFile(t *testing.T, name string) *rosco.ScenarioFile {
+	scenario := rosco.NewScenarioFile(filepath.Join(rosco.GetLogFolder(), name))
+
+	if err := scenario.Read(); err != nil {
+		t.Fatalf("unable to read %s (%s)", name, err)
+	}
+
+	return scenario
+}
+
+func TestTrimScenario(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"misfire","position":12}`)
+	sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"cranking","position":2}`)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/trim/"+scenario, `{"from":10,"to":14}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the scenario to be trimmed, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var edit ScenarioEdit
+	_ = json.Unmarshal(w.Body.Bytes(), &edit)
+
+	if len(edit.Scenarios) != 1 || edit.Scenarios[0].Name != "scenario-10-14.fcr" || edit.Scenarios[0].Count != 5 {
+		t.Fatalf("expected a 5 dataframe scenario, got %+v", edit)
+	}
+
+	trimmed := readTestScenarioFile(t, "scenario-10-14.fcr")
+
+	if trimmed.ECUID != "99000203" || trimmed.ECUSerial != "ABNMP002" || trimmed.RawData[0].Time != "2023-01-01 10:00:05.000" {
+		t.Errorf("expected the ecu and timestamps to be kept, got %+v", trimmed)
+	}
+
+	// only the bookmarks in the range are kept
+	bookmarks, _ := ReadBookmarks("scenario-10-14.fcr")
+	if len(bookmarks.Bookmarks) != 1 || bookmarks.Bookmarks[0].Name != "misfire" || bookmarks.Bookmarks[0].Position != 2 {
+		t.Errorf("expected the misfire bookmark at position 2, got %+v", bookmarks)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/trim/"+scenario, `{"from":10,"to":14}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeScenarioExists)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/trim/"+scenario, `{"from":10,"to":20}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/trim/"+scenario, `{"from":0,"to":1,"name":"../escape.fcr"}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+}
+
+func TestSplitScenarioAtBookmark(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"warm","position":8}`)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/split/"+scenario, `{"bookmark":"warm"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the scenario to be split, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var edit ScenarioEdit
+	_ = json.Unmarshal(w.Body.Bytes(), &edit)
+
+	if len(edit.Scenarios) != 2 || edit.Scenarios[0].Count != 8 || edit.Scenarios[1].Count != 12 {
+		t.Fatalf("expected the scenario to be split 8 and 12, got %+v", edit)
+	}
+
+	if edit.Scenarios[0].Last != "2023-01-01 10:00:03.500" || edit.Scenarios[1].First != "2023-01-01 10:00:04.000" {
+		t.Errorf("expected the split at 10:00:04.000, got %+v", edit.Scenarios)
+	}
+
+	// the new scenarios are in the scenario list
+	scenarios, _ := rosco.GetScenarios("")
+	if len(scenarios) != 3 {
+		t.Errorf("expected 3 scenarios, got %+v", scenarios)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/split/"+scenario, `{"bookmark":"unknown"}`)
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/split/"+scenario, `{"position":0}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+}
+
+func TestMergeScenarios(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	if _, err := SplitScenario(scenario, 5); err != nil {
+		t.Fatalf("unable to split %s (%s)", scenario, err)
+	}
+
+	// merged in the order recorded whatever the order requested
+	w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/merge", `{"scenarios":["scenario-2.fcr","scenario-1.fcr"],"name":"rejoined"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the scenarios to be merged, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	original := readTestScenarioFile(t, scenario)
+	merged := readTestScenarioFile(t, "rejoined.fcr")
+
+	if merged.Count != original.Count || merged.ECUID != original.ECUID {
+		t.Fatalf("expected the merged scenario to match the original, got %+v", merged)
+	}
+
+	for i := range original.RawData {
+		if *merged.RawData[i] != *original.RawData[i] {
+			t.Errorf("expected dataframe %d to be %+v, got %+v", i, original.RawData[i], merged.RawData[i])
+		}
+	}
+
+	// scenarios from another ecu can't be merged
+	other := rosco.NewScenarioFile(filepath.Join(rosco.GetLogFolder(), "other.fcr"))
+	other.ECUID = "99000000"
+	other.RawData = original.RawData
+	other.Count = original.Count
+	_ = other.Write()
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/merge", `{"scenarios":["scenario-2.fcr","other.fcr"]}`)
+	expectError(t, w, http.StatusConflict, ErrorCodeECUMismatch)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/merge", `{"scenarios":["scenario-2.fcr"]}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+}
+
+func TestScenariosOutsideLogFolderRefused(t *testing.T) {
+	webserver := newTestWebServer(t)
+	copyTestScenario(t)
+
+	// the scenarios can only be read from the log folder
+	source, _ := filepath.Abs(testScenario)
+
+	for _, scenarios := range []string{
+		`["` + source + `","scenario.fcr"]`,
+		`["../scenario.fcr","scenario.fcr"]`,
+		`["..\\scenario.fcr","scenario.fcr"]`,
+	} {
+		w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/merge", `{"scenarios":`+scenarios+`}`)
+		expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(source), "scenario-merged.fcr")); err == nil {
+		t.Errorf("expected the merged scenario not to be written outside the log folder")
+	}
+
+	for _, id := range []string{"..%5Cscenario.fcr", "..."} {
+		w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+id+"?format=csv", "")
+		expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+		w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/bookmarks/"+id, "")
+		expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+	}
+
+	if _, err := TrimScenario(source, 0, 1, ""); !errors.Is(err, ErrScenarioPath) {
+		t.Errorf("expected trimming a scenario outside the log folder to be refused, got %v", err)
+	}
+
+	if _, err := getScenarioPartFile("../escape"); !errors.Is(err, ErrScenarioPath) {
+		t.Errorf("expected a new scenario outside the log folder to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+package fcr
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// ErrExportFormat the scenario can't be exported in the format
+var ErrExportFormat = errors.New("unknown export format")
+
+// the formats a scenario can be exported to
+const (
+	// ExportFCR is the scenario file as it was saved
+	ExportFCR = "fcr"
+	// ExportCSV has the same columns as the log files written by rosco
+	ExportCSV = "csv"
+	// ExportJSONL is a line of json for each dataframe
+	ExportJSONL = "jsonl"
+	// ExportColumnar is a compressed binary file with the values of each field stored together
+	ExportColumnar = "columnar"
+)
+
+// ExportFormats are the formats a scenario can be exported to
+var ExportFormats = []string{ExportFCR, ExportCSV, ExportJSONL, ExportColumnar}
+
+// exportFormat describes how the dataframes are written in the format
+type exportFormat struct {
+	contentType string
+	extension   string
+	write       func(w io.Writer, samples []rosco.MemsData) error
+}
+
+var exportFormats = map[string]exportFormat{
+	ExportCSV:      {"text/csv; charset=UTF-8", ".csv", writeCSVDataframes},
+	ExportJSONL:    {"application/x-ndjson", ".jsonl", writeJSONLDataframes},
+	ExportColumnar: {"application/octet-stream", ".fcol", WriteColumnarDataframes},
+}
+
+// columnar file layout, the file is gzip compressed:
+//
+//	magic, version
+//	uvarint rows, uvarint columns
+//	for each column: uvarint name length, name, type
+//	for each column: the values for every row
+//
+// integers are stored as the varint difference from the previous row, floats as little endian float32,
+// bools packed 8 to a byte and strings as a uvarint length and the bytes. The time is stored as the
+// string recorded in the scenario, log files only record the time of day.
+const (
+	columnarMagic   = "MFCC"
+	columnarVersion = 2
+	// version 1 files stored the time as milliseconds since the unix epoch
+	columnarTimeVersion = 1
+	// longest string expected in a column, a longer string is a corrupt file
+	columnarMaxString = 4096
+	// most rows and columns expected in a file, a day of dataframes polled at the
+	// fastest frequency, more than this is a corrupt file
+	columnarMaxRows    = 24 * 60 * 60 * 1000 / minFrequency
+	columnarMaxColumns = 1024
+
+	columnInt    = 'i'
+	columnFloat  = 'f'
+	columnBool   = 'b'
+	columnString = 's'
+	// times in version 1 files
+	columnTime = 't'
+)
+
+// column is a field of the dataframe
+type column struct {
+	name  string
+	kind  byte
+	index []int
+}
+
+// ExportScenarioAs writes the scenario in the format, the scenario is decoded as it's
+// played back so every field of the dataframe can be exported
+func ExportScenarioAs(id string, format string, w io.Writer) error {
+	if format == ExportFCR || format == "" {
+		return ExportScenario(id, w)
+	}
+
+	if !isExportFormat(format) {
+		return fmt.Errorf("%w %s, expected one of %s", ErrExportFormat, format, strings.Join(ExportFormats, ", "))
+	}
+
+	samples, err := decodeScenario(id, func(f func()) { f() })
+	if err != nil {
+		return err
+	}
+
+	return exportFormats[format].write(w, samples)
+}
+
+// isExportFormat returns true if scenarios can be exported in the format
+func isExportFormat(format string) bool {
+	_, ok := exportFormats[format]
+	return ok || format == ExportFCR
+}
+
+// getExportFilename names the exported file after the scenario
+func getExportFilename(id string, format string) string {
+	name := filepath.Base(id)
+	if export, ok := exportFormats[format]; ok {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + export.extension
+	}
+
+	return name
+}
+
+// writeCSVDataframes writes the dataframes with the columns used in the rosco log files,
+// the csv can be converted back to a scenario
+func writeCSVDataframes(w io.Writer, samples []rosco.MemsData) error {
+	writer := csv.NewWriter(w)
+
+	header := strings.Split(rosco.MemsDataHeader+","+rosco.DiagnosticsCSVHeader, ",")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, memsdata := range samples {
+		if err := writer.Write(getCSVRecord(memsdata)); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// getCSVRecord formats the dataframe in the order of the csv header
+func getCSVRecord(data rosco.MemsData) []string {
+	i := strconv.Itoa
+	b := strconv.FormatBool
+	u := func(v uint8) string { return strconv.Itoa(int(v)) }
+	f := func(v float32) string { return strconv.FormatFloat(float64(v), 'f', 2, 32) }
+
+	a := data.Analytics
+
+	return []string{
+		data.Time,
+		i(data.EngineRPM), i(data.CoolantTemp), i(data.AmbientTemp), i(data.IntakeAirTemp), i(data.FuelTemp),
+		f(data.ManifoldAbsolutePressure), f(data.BatteryVoltage), f(data.ThrottlePotSensor), b(data.IdleSwitch), b(data.AirconSwitch),
+		b(data.ParkNeutralSwitch), u(data.DTC0), i(data.IdleSetPoint), i(data.IdleHot), i(data.Uk8011),
+		i(data.IACPosition), i(data.IdleSpeedDeviation), i(data.IgnitionAdvanceOffset80), f(data.IgnitionAdvance), f(data.CoilTime),
+		i(data.CrankshaftPositionSensor), i(data.Uk801a), i(data.Uk801b),
+		b(data.IgnitionSwitch), i(data.ThrottleAngle), i(data.Uk7d03), f(data.AirFuelRatio), u(data.DTC2),
+		i(data.LambdaVoltage), i(data.LambdaFrequency), i(data.LambdaDutycycle), i(data.LambdaStatus), b(data.ClosedLoop),
+		i(data.LongTermFuelTrim), i(data.ShortTermFuelTrim), i(data.CarbonCanisterPurgeValve), u(data.DTC3), i(data.IdleBasePosition),
+		i(data.Uk7d10), u(data.DTC4), i(data.IgnitionAdvanceOffset7d), i(data.IdleSpeedOffset), i(data.Uk7d14),
+		i(data.Uk7d15), u(data.DTC5), i(data.Uk7d17), i(data.Uk7d18), i(data.Uk7d19),
+		i(data.Uk7d1a), i(data.Uk7d1b), i(data.Uk7d1c), i(data.Uk7d1d), i(data.Uk7d1e), i(data.JackCount),
+		strings.ToUpper(data.Dataframe7d), strings.ToUpper(data.Dataframe80),
+		// the diagnostics rosco doesn't calculate are always false in the log files
+		b(a.IsEngineRunning), b(a.IsEngineWarming), b(a.IsAtOperatingTemp), b(a.IsEngineIdle),
+		b(a.IsEngineIdleFault), b(a.IdleSpeedFault), b(false), b(a.IdleHotFault),
+		b(false), b(a.IsClosedLoop), b(false), b(false),
+		b(a.IsThrottleActive), b(a.MapFault), b(a.VacuumFault), b(a.IdleAirControlFault),
+		b(false), b(a.IdleAirControlJackFault), b(a.O2SystemFault), b(a.LambdaRangeFault),
+		b(a.LambdaOscillationFault), b(a.ThermostatFault), b(a.CrankshaftSensorFault), b(a.CoilFault),
+	}
+}
+
+// writeJSONLDataframes writes each dataframe as a line of json
+func writeJSONLDataframes(w io.Writer, samples []rosco.MemsData) error {
+	encoder := json.NewEncoder(w)
+
+	for _, memsdata := range samples {
+		if err := encoder.Encode(memsdata); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteColumnarDataframes writes the dataframes to the compressed columnar format
+func WriteColumnarDataframes(w io.Writer, samples []rosco.MemsData) error {
+	columns := getColumns()
+
+	compressed := gzip.NewWriter(w)
+	writer := bufio.NewWriter(compressed)
+
+	buffer := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		_, _ = writer.Write(buffer[:binary.PutUvarint(buffer, v)])
+	}
+	putVarint := func(v int64) {
+		_, _ = writer.Write(buffer[:binary.PutVarint(buffer, v)])
+	}
+
+	_, _ = writer.WriteString(columnarMagic)
+	_ = writer.WriteByte(columnarVersion)
+
+	putUvarint(uint64(len(samples)))
+	putUvarint(uint64(len(columns)))
+
+	for _, c := range columns {
+		putUvarint(uint64(len(c.name)))
+		_, _ = writer.WriteString(c.name)
+		_ = writer.WriteByte(c.kind)
+	}
+
+	for _, c := range columns {
+		var previous int64
+		var packed byte
+
+		for row, memsdata := range samples {
+			value := reflect.ValueOf(memsdata).FieldByIndex(c.index)
+
+			switch c.kind {
+			case columnInt:
+				v := getIntValue(value)
+				putVarint(v - previous)
+				previous = v
+			case columnFloat:
+				binary.LittleEndian.PutUint32(buffer, math.Float32bits(float32(value.Float())))
+				_, _ = writer.Write(buffer[:4])
+			case columnBool:
+				if value.Bool() {
+					packed |= 1 << (row % 8)
+				}
+
+				if row%8 == 7 || row == len(samples)-1 {
+					_ = writer.WriteByte(packed)
+					packed = 0
+				}
+			case columnString:
+				putUvarint(uint64(value.Len()))
+				_, _ = writer.WriteString(value.String())
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return compressed.Close()
+}
+
+// ReadColumnarDataframes reads the dataframes from the compressed columnar format
+func ReadColumnarDataframes(r io.Reader) ([]rosco.MemsData, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a columnar file (%s)", err)
+	}
+	defer compressed.Close()
+
+	reader := bufio.NewReader(compressed)
+
+	magic := make([]byte, len(columnarMagic)+1)
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic[:len(columnarMagic)]) != columnarMagic {
+		return nil, fmt.Errorf("not a columnar file")
+	}
+
+	if version := magic[len(columnarMagic)]; version != columnarVersion && version != columnarTimeVersion {
+		return nil, fmt.Errorf("unsupported columnar file version %d", version)
+	}
+
+	rows, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if rows > columnarMaxRows {
+		return nil, fmt.Errorf("invalid columnar row count %d", rows)
+	}
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > columnarMaxColumns {
+		return nil, fmt.Errorf("invalid columnar column count %d", count)
+	}
+
+	known := make(map[string]column)
+	for _, c := range getColumns() {
+		known[c.name] = c
+	}
+
+	columns := make([]column, count)
+	for i := range columns {
+		if columns[i].name, err = readColumnarString(reader); err != nil {
+			return nil, err
+		}
+
+		if columns[i].kind, err = reader.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	samples := make([]rosco.MemsData, rows)
+	float := make([]byte, 4)
+
+	for _, c := range columns {
+		var previous int64
+		var packed byte
+
+		// fields that no longer exist are read and discarded
+		field, ok := known[c.name]
+
+		for row := range samples {
+			var value reflect.Value
+			if ok && (field.kind == c.kind || (c.kind == columnTime && field.kind == columnString)) {
+				value = reflect.ValueOf(&samples[row]).Elem().FieldByIndex(field.index)
+			}
+
+			switch c.kind {
+			case columnTime, columnInt:
+				delta, err := binary.ReadVarint(reader)
+				if err != nil {
+					return nil, err
+				}
+
+				previous += delta
+
+				if value.IsValid() && c.kind == columnTime {
+					value.SetString(getColumnarTime(previous))
+				} else if value.IsValid() {
+					setIntValue(value, previous)
+				}
+			case columnFloat:
+				if _, err = io.ReadFull(reader, float); err != nil {
+					return nil, err
+				}
+
+				if value.IsValid() {
+					value.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(float))))
+				}
+			case columnBool:
+				if row%8 == 0 {
+					if packed, err = reader.ReadByte(); err != nil {
+						return nil, err
+					}
+				}
+
+				if value.IsValid() {
+					value.SetBool(packed&(1<<(row%8)) != 0)
+				}
+			case columnString:
+				s, err := readColumnarString(reader)
+				if err != nil {
+					return nil, err
+				}
+
+				if value.IsValid() {
+					value.SetString(s)
+				}
+			default:
+				return nil, fmt.Errorf("unknown column type %c for %s", c.kind, c.name)
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+// getColumns returns a column for each field of the dataframe, the fields of the analytics
+// are prefixed with Analytics.
+func getColumns() []column {
+	var columns []column
+	var add func(t reflect.Type, prefix string, index []int)
+
+	add = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			c := column{name: prefix + field.Name, index: append(append([]int(nil), index...), i)}
+
+			switch field.Type.Kind() {
+			case reflect.Struct:
+				add(field.Type, c.name+".", c.index)
+				continue
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				c.kind = columnInt
+			case reflect.Float32, reflect.Float64:
+				c.kind = columnFloat
+			case reflect.Bool:
+				c.kind = columnBool
+			case reflect.String:
+				c.kind = columnString
+			default:
+				continue
+			}
+
+			columns = append(columns, c)
+		}
+	}
+
+	add(reflect.TypeOf(rosco.MemsData{}), "", nil)
+	return columns
+}
+
+func getIntValue(value reflect.Value) int64 {
+	if value.Kind() >= reflect.Uint && value.Kind() <= reflect.Uint64 {
+		return int64(value.Uint())
+	}
+
+	return value.Int()
+}
+
+func setIntValue(value reflect.Value, v int64) {
+	if value.Kind() >= reflect.Uint && value.Kind() <= reflect.Uint64 {
+		value.SetUint(uint64(v))
+	} else {
+		value.SetInt(v)
+	}
+}
+
+// getColumnarTime formats the time stored in a version 1 file
+func getColumnarTime(milliseconds int64) string {
+	recorded := time.UnixMilli(milliseconds).UTC()
+	return recorded.Format("2006-01-02 15:04:05.000")
+}
+
+func readColumnarString(reader *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if length > columnarMaxString {
+		return "", fmt.Errorf("invalid columnar string length %d", length)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+
+	return string(data), err
+}
@@ -0,0 +1,230 @@
+package fcr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+func TestExportCSVConvertsBackToScenario(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+scenario+"?format=csv", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the scenario to be exported, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="scenario.csv"` {
+		t.Errorf("expected the csv to be downloaded as scenario.csv, got %s", disposition)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(w.Body.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid csv (%s)", err)
+	}
+
+	header := strings.Split(rosco.MemsDataHeader+","+rosco.DiagnosticsCSVHeader, ",")
+	if len(records) != 21 || !reflect.DeepEqual(records[0], header) {
+		t.Fatalf("expected the log file header and 20 dataframes, got %d records with header %v", len(records), records[0])
+	}
+
+	if records[1][0] != "2023-01-01 10:00:00.000" || records[1][1] != "800" {
+		t.Errorf("expected the first dataframe at 10:00:00.000 and 800 rpm, got %v", records[1][:2])
+	}
+
+	// the csv is read back in the same way as a log file
+	log := filepath.Join(rosco.GetLogFolder(), "exported.csv")
+	_ = os.WriteFile(log, w.Body.Bytes(), 0644)
+
+	conversion, err := ConvertLogToScenario(log)
+	if err != nil {
+		t.Fatalf("unable to convert the exported csv (%s)", err)
+	}
+
+	original := readTestScenarioFile(t, scenario)
+	converted := readTestScenarioFile(t, filepath.Base(conversion.Destination))
+
+	// the log files have the dataframes in upper case
+	last, expected := converted.RawData[19], original.RawData[19]
+
+	if converted.Count != original.Count || last.Time != expected.Time || !strings.EqualFold(last.Dataframe80, expected.Dataframe80) || !strings.EqualFold(last.Dataframe7d, expected.Dataframe7d) {
+		t.Errorf("expected the converted scenario to match, got %+v", last)
+	}
+}
+
+func TestExportJSONL(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+scenario+"?format=jsonl", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the scenario to be exported, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var lines int
+	scanner := bufio.NewScanner(bytes.NewReader(w.Body.Bytes()))
+
+	for scanner.Scan() {
+		var memsdata rosco.MemsData
+		if err := json.Unmarshal(scanner.Bytes(), &memsdata); err != nil {
+			t.Fatalf("line %d is not a dataframe (%s)", lines, err)
+		}
+
+		if memsdata.EngineRPM != 800+lines*10 {
+			t.Errorf("expected %d rpm on line %d, got %d", 800+lines*10, lines, memsdata.EngineRPM)
+		}
+
+		lines++
+	}
+
+	if lines != 20 {
+		t.Errorf("expected 20 lines, got %d", lines)
+	}
+}
+
+func TestExportColumnarRoundTrip(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+scenario+"?format=columnar", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the scenario to be exported, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	samples, err := ReadColumnarDataframes(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("unable to read the columnar export (%s)", err)
+	}
+
+	expected, _ := webserver.reader.DecodeScenario(scenario)
+
+	if !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected the columnar export to read back the same dataframes\n%+v\n%+v", samples[0], expected[0])
+	}
+
+	if _, err = ReadColumnarDataframes(strings.NewReader("not columnar")); err == nil {
+		t.Errorf("expected an error reading a file that isn't columnar")
+	}
+}
+
+func TestExportColumnarLogTimes(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	// the log files written by rosco only record the time of day
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+scenario+"?format=csv", "")
+	records, err := csv.NewReader(bytes.NewReader(w.Body.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid csv (%s)", err)
+	}
+
+	for _, record := range records[1:] {
+		record[0] = strings.TrimPrefix(record[0], "2023-01-01 ")
+	}
+
+	var logged bytes.Buffer
+	_ = csv.NewWriter(&logged).WriteAll(records)
+
+	log := filepath.Join(rosco.GetLogFolder(), "logged.csv")
+	_ = os.WriteFile(log, logged.Bytes(), 0644)
+
+	if _, err = ConvertLogToScenario(log); err != nil {
+		t.Fatalf("unable to convert the log (%s)", err)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/logged.fcr?format=columnar", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the scenario to be exported, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	samples, err := ReadColumnarDataframes(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("unable to read the columnar export (%s)", err)
+	}
+
+	expected, _ := webserver.reader.DecodeScenario("logged.fcr")
+
+	if len(samples) != 20 || samples[0].Time != "10:00:00.000" || !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected the columnar export to keep the log times, got %d dataframes starting %+v", len(samples), samples[0])
+	}
+
+	// the example log is too old to have the raw dataframes, it can't be decoded
+	data, _ := os.ReadFile("../logs/example.csv")
+	log = filepath.Join(rosco.GetLogFolder(), "example.csv")
+	_ = os.WriteFile(log, data, 0644)
+
+	if _, err = ConvertLogToScenario(log); err != nil {
+		t.Fatalf("unable to convert the example log (%s)", err)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/example.fcr?format=columnar", "")
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+}
+
+func TestReadColumnarVersion1(t *testing.T) {
+	var file bytes.Buffer
+
+	// a version 1 file with a single time stored as milliseconds since the epoch
+	compressed := gzip.NewWriter(&file)
+	header := []byte(columnarMagic)
+	header = append(header, columnarTimeVersion, 1, 1, 4)
+	header = append(header, "Time"...)
+	header = append(header, columnTime)
+	buffer := make([]byte, binary.MaxVarintLen64)
+	header = append(header, buffer[:binary.PutVarint(buffer, time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli())]...)
+	_, _ = compressed.Write(header)
+	_ = compressed.Close()
+
+	samples, err := ReadColumnarDataframes(&file)
+	if err != nil || len(samples) != 1 || samples[0].Time != "2023-01-01 10:00:00.000" {
+		t.Errorf("expected the version 1 time to be read, got %+v (%v)", samples, err)
+	}
+}
+
+func TestReadColumnarRowLimit(t *testing.T) {
+	var file bytes.Buffer
+
+	compressed := gzip.NewWriter(&file)
+	buffer := make([]byte, binary.MaxVarintLen64)
+	header := append([]byte(columnarMagic), columnarVersion)
+	header = append(header, buffer[:binary.PutUvarint(buffer, columnarMaxRows+1)]...)
+	header = append(header, 0)
+	_, _ = compressed.Write(header)
+	_ = compressed.Close()
+
+	if _, err := ReadColumnarDataframes(&file); err == nil {
+		t.Errorf("expected an error reading more rows than the limit")
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+scenario+"?format=parquet", "")
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/unknown.fcr?format=csv", "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+
+	// the scenario file is downloaded as it is if the format isn't given
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/export/"+scenario, "")
+	original, _ := os.ReadFile(testScenario)
+
+	if !bytes.Equal(w.Body.Bytes(), original) {
+		t.Errorf("expected the scenario file to be downloaded unchanged")
+	}
+}
@@ -0,0 +1,358 @@
+package fcr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrScenarioNotFound the scenario file does not exist
+var ErrScenarioNotFound = errors.New("scenario not found")
+
+// ErrScenarioPath the scenario id includes a folder
+var ErrScenarioPath = errors.New("the scenario cannot include a folder")
+
+// ErrScenarioDataframes the scenario doesn't have the raw dataframes needed to replay it
+var ErrScenarioDataframes = errors.New("the scenario cannot be decoded")
+
+// ErrInvalidQuery the search query could not be understood
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// the lambda sensor is stuck if it hasn't switched for this long in closed loop
+const lambdaStuckTime = time.Second * 10
+
+// searchMetrics are the dataframe values that can be compared in a search
+var searchMetrics = map[string]func(m rosco.MemsData) float64{
+	"rpm":       func(m rosco.MemsData) float64 { return float64(m.EngineRPM) },
+	"coolant":   func(m rosco.MemsData) float64 { return float64(m.CoolantTemp) },
+	"intakeair": func(m rosco.MemsData) float64 { return float64(m.IntakeAirTemp) },
+	"map":       func(m rosco.MemsData) float64 { return float64(m.ManifoldAbsolutePressure) },
+	"battery":   func(m rosco.MemsData) float64 { return float64(m.BatteryVoltage) },
+	"throttle":  func(m rosco.MemsData) float64 { return float64(m.ThrottlePotSensor) },
+	"iac":       func(m rosco.MemsData) float64 { return float64(m.IACPosition) },
+	"advance":   func(m rosco.MemsData) float64 { return float64(m.IgnitionAdvance) },
+	"coil":      func(m rosco.MemsData) float64 { return float64(m.CoilTime) },
+	"lambda":    func(m rosco.MemsData) float64 { return float64(m.LambdaVoltage) },
+	"stft":      func(m rosco.MemsData) float64 { return float64(m.ShortTermFuelTrim) },
+	"ltft":      func(m rosco.MemsData) float64 { return float64(m.LongTermFuelTrim) },
+}
+
+// searchFlags are the conditions that are either set or not in each dataframe
+var searchFlags = map[string]func(m rosco.MemsData) bool{
+	"closedloop": func(m rosco.MemsData) bool { return m.ClosedLoop },
+	"openloop":   func(m rosco.MemsData) bool { return !m.ClosedLoop },
+	"idle":       func(m rosco.MemsData) bool { return m.IdleSwitch },
+	"running":    func(m rosco.MemsData) bool { return m.EngineRPM > 0 },
+	"stopped":    func(m rosco.MemsData) bool { return m.EngineRPM == 0 },
+	"fault":      func(m rosco.MemsData) bool { return len(DecodeFaults(m)) > 0 },
+}
+
+// a comparison of a metric with a value, e.g. coolant > 100
+var searchComparison = regexp.MustCompile(`^([a-z]+)\s*(>=|<=|==|!=|>|<|=)\s*(-?[0-9]+(?:\.[0-9]+)?)$`)
+
+// clauses are joined with and, or while to read more naturally
+var searchConjunction = regexp.MustCompile(`\s+(?:and|while)\s+`)
+
+// searchPhrases rewrites the phrases used to describe conditions as flags and comparisons,
+// so "any fault set" is fault and "rpm drop below 500" is rpm < 500
+var searchPhrases = strings.NewReplacer(
+	"any fault set", "fault",
+	"lambda stuck", "lambdastuck",
+	"closed loop", "closedloop",
+	"open loop", "openloop",
+	"drops below", "<",
+	"drop below", "<",
+	"below", "<",
+	"rises above", ">",
+	"rise above", ">",
+	"above", ">",
+)
+
+// ScenarioMatch is a run of consecutive dataframes matching the search
+type ScenarioMatch struct {
+	Position int    `json:"position"`
+	End      int    `json:"end"`
+	Time     string `json:"time"`
+}
+
+// ScenarioSearchResult lists where the search matched the scenario
+type ScenarioSearchResult struct {
+	Scenario string          `json:"scenario"`
+	Query    string          `json:"query"`
+	Count    int             `json:"count"`
+	Matches  []ScenarioMatch `json:"matches"`
+}
+
+// decodedScenario caches the last scenario decoded, searches are usually repeated on the same scenario
+var decodedScenario struct {
+	sync.Mutex
+	file     string
+	modified time.Time
+	samples  []rosco.MemsData
+}
+
+// getScenarioFile returns the path to the scenario file in the log folder if it exists
+func getScenarioFile(id string) (string, error) {
+	file, err := getScenarioPath(id)
+	if err != nil {
+		return file, err
+	}
+
+	// the rosco file readers create missing files, so check before loading
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return file, fmt.Errorf("%w (%s)", ErrScenarioNotFound, id)
+	}
+
+	return file, nil
+}
+
+// getScenarioPath returns the path to the scenario in the log folder, scenarios are
+// only read and written in the log folder so the id can't include a folder
+func getScenarioPath(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return "", fmt.Errorf("%w (%s)", ErrScenarioPath, id)
+	}
+
+	folder := filepath.Clean(rosco.GetLogFolder())
+	file := filepath.Join(folder, id)
+
+	if filepath.Dir(file) != folder {
+		return "", fmt.Errorf("%w (%s)", ErrScenarioPath, id)
+	}
+
+	return file, nil
+}
+
+// DecodeScenario returns the dataframes in the scenario as they would be read from the ECU
+func (reader *MemsReader) DecodeScenario(id string) ([]rosco.MemsData, error) {
+	return decodeScenario(id, reader.Queue.Inspect)
+}
+
+// decodeScenario decodes the scenario, the scenario is loaded by inspect so it
+// can be kept apart from the ecu commands
+func decodeScenario(id string, inspect func(func())) ([]rosco.MemsData, error) {
+	file, err := getScenarioFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	info, _ := os.Stat(file)
+
+	decodedScenario.Lock()
+	defer decodedScenario.Unlock()
+
+	if decodedScenario.file == file && decodedScenario.modified.Equal(info.ModTime()) {
+		return decodedScenario.samples, nil
+	}
+
+	// replay the scenario through a separate ecu so the dataframes are decoded and analysed
+	// exactly as they are when played back, rosco shares its responses between readers
+	// so the reader is created between ecu commands
+	var scenario *rosco.ScenarioReader
+	ecu := rosco.NewECUReaderInstance()
+
+	inspect(func() {
+		scenario = rosco.NewScenarioReader(file)
+		_, err = scenario.Connect()
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to load scenario %s (%s)", id, err)
+	}
+
+	ecu.EcuReader = scenario
+	defer func() { _ = scenario.Disconnect() }()
+
+	log.Infof("decoding %d dataframes in scenario %s", scenario.Responder.Playbook.Count, id)
+
+	samples := make([]rosco.MemsData, 0, scenario.Responder.Playbook.Count)
+	for i := 0; i < scenario.Responder.Playbook.Count; i++ {
+		// older log files don't record the raw dataframes, rosco can't replay them
+		if response := scenario.Responder.Playbook.Responses[i]; len(response.Dataframe80) < 29 || len(response.Dataframe7d) < 33 {
+			return nil, fmt.Errorf("%w, scenario %s has no raw dataframes at %d", ErrScenarioDataframes, id, i)
+		}
+
+		memsdata, err := ecu.GetDataframes()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode scenario %s at %d (%s)", id, i, err)
+		}
+
+		// use the time the dataframe was recorded
+		memsdata.Time = scenario.Responder.RawData[i].Time
+		samples = append(samples, memsdata)
+	}
+
+	decodedScenario.file = file
+	decodedScenario.modified = info.ModTime()
+	decodedScenario.samples = samples
+
+	return samples, nil
+}
+
+// SearchScenario finds the dataframes in the scenario matching the query.
+// The query is one or more conditions joined by and, or while, each condition is either
+// a flag (closedloop, openloop, idle, running, stopped, fault, lambdastuck) or a
+// comparison of a metric with a value, e.g. "rpm < 500 while closedloop"
+func (reader *MemsReader) SearchScenario(id string, query string) (ScenarioSearchResult, error) {
+	result := ScenarioSearchResult{Scenario: id, Query: query, Matches: []ScenarioMatch{}}
+
+	conditions, err := parseSearchQuery(query)
+	if err != nil {
+		return result, err
+	}
+
+	samples, err := reader.DecodeScenario(id)
+	if err != nil {
+		return result, err
+	}
+
+	result.Count = len(samples)
+	result.Matches = searchSamples(samples, conditions)
+
+	log.Infof("search of %s for %q found %d matches", id, query, len(result.Matches))
+
+	return result, nil
+}
+
+// searchSamples returns the runs of consecutive dataframes matching all the conditions
+func searchSamples(samples []rosco.MemsData, conditions []searchCondition) []ScenarioMatch {
+	matches := []ScenarioMatch{}
+
+	matched := make([]bool, len(samples))
+	for i := range matched {
+		matched[i] = true
+	}
+
+	for _, condition := range conditions {
+		for i, match := range condition(samples) {
+			matched[i] = matched[i] && match
+		}
+	}
+
+	// consecutive matching dataframes are a single match
+	for i := 0; i < len(matched); i++ {
+		if !matched[i] {
+			continue
+		}
+
+		match := ScenarioMatch{Position: i, End: i, Time: samples[i].Time}
+		for i+1 < len(matched) && matched[i+1] {
+			i++
+		}
+
+		match.End = i
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// searchCondition returns whether each of the dataframes matches the condition
+type searchCondition func(samples []rosco.MemsData) []bool
+
+// parseSearchQuery parses the query into conditions
+func parseSearchQuery(query string) ([]searchCondition, error) {
+	var conditions []searchCondition
+
+	query = searchPhrases.Replace(strings.ToLower(strings.TrimSpace(query)))
+	if query == "" {
+		return nil, fmt.Errorf("%w, the query is empty", ErrInvalidQuery)
+	}
+
+	for _, clause := range searchConjunction.Split(query, -1) {
+		condition, err := parseSearchClause(strings.TrimSpace(clause))
+		if err != nil {
+			return nil, err
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+// parseSearchClause parses a single flag or comparison
+func parseSearchClause(clause string) (searchCondition, error) {
+	if clause == "lambdastuck" {
+		return isLambdaStuck, nil
+	}
+
+	if flag, ok := searchFlags[clause]; ok {
+		return func(samples []rosco.MemsData) []bool {
+			matched := make([]bool, len(samples))
+			for i, memsdata := range samples {
+				matched[i] = flag(memsdata)
+			}
+			return matched
+		}, nil
+	}
+
+	parts := searchComparison.FindStringSubmatch(clause)
+	if parts == nil {
+		return nil, fmt.Errorf("%w, %q is not a condition or a comparison", ErrInvalidQuery, clause)
+	}
+
+	metric, ok := searchMetrics[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("%w, %q is not a metric", ErrInvalidQuery, parts[1])
+	}
+
+	value, _ := strconv.ParseFloat(parts[3], 64)
+	compare := getComparison(parts[2])
+
+	return func(samples []rosco.MemsData) []bool {
+		matched := make([]bool, len(samples))
+		for i, memsdata := range samples {
+			matched[i] = compare(metric(memsdata), value)
+		}
+		return matched
+	}, nil
+}
+
+func getComparison(operator string) func(a float64, b float64) bool {
+	switch operator {
+	case ">":
+		return func(a float64, b float64) bool { return a > b }
+	case ">=":
+		return func(a float64, b float64) bool { return a >= b }
+	case "<":
+		return func(a float64, b float64) bool { return a < b }
+	case "<=":
+		return func(a float64, b float64) bool { return a <= b }
+	case "!=":
+		return func(a float64, b float64) bool { return a != b }
+	default:
+		return func(a float64, b float64) bool { return a == b }
+	}
+}
+
+// isLambdaStuck matches the dataframes where the lambda sensor hasn't switched
+// either side of the midpoint for the stuck time while in closed loop
+func isLambdaStuck(samples []rosco.MemsData) []bool {
+	matched := make([]bool, len(samples))
+
+	var switched time.Time
+	for i, memsdata := range samples {
+		recorded, _ := rosco.ConvertTimeFieldToDate(memsdata.Time)
+
+		if !memsdata.ClosedLoop || i == 0 || switched.IsZero() ||
+			(memsdata.LambdaVoltage > lambdaMidpoint) != (samples[i-1].LambdaVoltage > lambdaMidpoint) {
+			// the sensor is only expected to switch in closed loop
+			switched = recorded
+			continue
+		}
+
+		matched[i] = recorded.Sub(switched) >= lambdaStuckTime
+	}
+
+	return matched
+}
@@ -0,0 +1,260 @@
+package fcr
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// copyTestScenario copies the test scenario into the logs folder so the bookmarks are kept out of testdata
+func copyTestScenario(t *testing.T) string {
+	data, err := os.ReadFile(testScenario)
+	if err != nil {
+		t.Fatalf("unable to read %s (%s)", testScenario, err)
+	}
+
+	_ = os.MkdirAll(rosco.GetLogFolder(), 0755)
+
+	if err = os.WriteFile(filepath.Join(rosco.GetLogFolder(), "scenario.fcr"), data, 0644); err != nil {
+		t.Fatalf("unable to copy %s (%s)", testScenario, err)
+	}
+
+	return "scenario.fcr"
+}
+
+func TestSearchScenario(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	// the test scenario rpm rises from 800 to 990 in open loop with a fault set throughout
+	tests := []struct {
+		query   string
+		matches []ScenarioMatch
+	}{
+		{"coolant > 100", []ScenarioMatch{}},
+		{"any fault set", []ScenarioMatch{{Position: 0, End: 19}}},
+		{"rpm >= 950", []ScenarioMatch{{Position: 15, End: 19}}},
+		{"RPM drop below 830 while open loop", []ScenarioMatch{{Position: 0, End: 2}}},
+		{"rpm < 830 while closed loop", []ScenarioMatch{}},
+		{"rpm < 820 or rpm > 970", nil},
+		{"lambda stuck", []ScenarioMatch{}},
+	}
+
+	for _, test := range tests {
+		result, err := webserver.reader.SearchScenario(scenario, test.query)
+
+		if test.matches == nil {
+			if err == nil {
+				t.Errorf("expected %q to be an invalid query", test.query)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("search for %q failed (%s)", test.query, err)
+			continue
+		}
+
+		if len(result.Matches) != len(test.matches) {
+			t.Errorf("expected %d matches for %q, got %+v", len(test.matches), test.query, result.Matches)
+			continue
+		}
+
+		for i, match := range test.matches {
+			if result.Matches[i].Position != match.Position || result.Matches[i].End != match.End {
+				t.Errorf("expected %q to match %d to %d, got %+v", test.query, match.Position, match.End, result.Matches[i])
+			}
+		}
+	}
+}
+
+// getClosedLoopSamples returns a minute of dataframes half a second apart, open loop for the first 5 seconds,
+// closed loop with the lambda switching until 9.5 seconds and then stuck lean, open loop again from 25 to 27.5 seconds
+func getClosedLoopSamples() []rosco.MemsData {
+	samples := make([]rosco.MemsData, 60)
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := range samples {
+		samples[i].Time = start.Add(time.Millisecond * time.Duration(i*500)).Format("2006-01-02 15:04:05.000")
+		samples[i].EngineRPM = 850
+		samples[i].ClosedLoop = i >= 10 && (i < 50 || i >= 55)
+		samples[i].LambdaVoltage = 200
+
+		if i >= 10 && i < 20 && i%2 == 0 {
+			samples[i].LambdaVoltage = 700
+		}
+	}
+
+	return samples
+}
+
+func TestSearchClosedLoop(t *testing.T) {
+	samples := getClosedLoopSamples()
+
+	// the lambda last switched at 19 and is stuck from 10 seconds later until open loop at 50
+	tests := []struct {
+		query   string
+		matches []ScenarioMatch
+	}{
+		{"closed loop", []ScenarioMatch{{Position: 10, End: 49}, {Position: 55, End: 59}}},
+		{"rpm < 900 while closed loop", []ScenarioMatch{{Position: 10, End: 49}, {Position: 55, End: 59}}},
+		{"lambda > 450 and closed loop", []ScenarioMatch{{Position: 10, End: 10}, {Position: 12, End: 12}, {Position: 14, End: 14}, {Position: 16, End: 16}, {Position: 18, End: 18}}},
+		{"lambda stuck", []ScenarioMatch{{Position: 39, End: 49}}},
+		{"lambda stuck and open loop", []ScenarioMatch{}},
+	}
+
+	for _, test := range tests {
+		conditions, err := parseSearchQuery(test.query)
+		if err != nil {
+			t.Errorf("unable to parse %q (%s)", test.query, err)
+			continue
+		}
+
+		matches := searchSamples(samples, conditions)
+
+		if len(matches) != len(test.matches) {
+			t.Errorf("expected %d matches for %q, got %+v", len(test.matches), test.query, matches)
+			continue
+		}
+
+		for i, match := range test.matches {
+			if matches[i].Position != match.Position || matches[i].End != match.End {
+				t.Errorf("expected %q to match %d to %d, got %+v", test.query, match.Position, match.End, matches[i])
+			}
+		}
+	}
+}
+
+func TestSearchScenarioWhileConnected(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+	connectTestScenario(t, webserver)
+
+	// the commands sent to the connected ecu use rosco's responses whilst the scenario is decoded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			on := i%2 == 0
+			webserver.reader.Acquisition.poll()
+			_ = webserver.reader.Queue.Submit("test fan1", PriorityNormal, time.Second, func() error {
+				return webserver.reader.ECU.TestFan1(on)
+			})
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		// decode the scenario each time rather than use the cached dataframes
+		decodedScenario.Lock()
+		decodedScenario.file = ""
+		decodedScenario.Unlock()
+
+		if _, err := webserver.reader.SearchScenario(scenario, "rpm >= 950"); err != nil {
+			t.Errorf("search whilst connected failed (%s)", err)
+		}
+	}
+
+	<-done
+}
+
+func TestSearchScenarioEndpoint(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/search/"+scenario+"?query=rpm+%3E%3D+950", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the search to succeed, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	var result ScenarioSearchResult
+	_ = json.Unmarshal(w.Body.Bytes(), &result)
+
+	if result.Count != 20 || len(result.Matches) != 1 || result.Matches[0].Time != "2023-01-01 10:00:07.500" {
+		t.Errorf("expected a single match at 10:00:07.500, got %+v", result)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/search/"+scenario+"?query=boost+%3E+1", "")
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/search/unknown.fcr?query=fault", "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+
+	if _, err := os.Stat(filepath.Join(rosco.GetLogFolder(), "unknown.fcr")); err == nil {
+		t.Errorf("expected the search not to create the missing scenario")
+	}
+}
+
+func TestScenarioBookmarks(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"misfire","position":12,"note":"stumbles under load"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the bookmark to be created, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(rosco.GetLogFolder(), "scenario.bookmarks.json")); err != nil {
+		t.Errorf("expected the bookmarks to be saved alongside the scenario (%s)", err)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"","position":1}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"past the end","position":20}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	// saving the same name moves the bookmark
+	sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"start","position":3}`)
+	sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"start","position":0}`)
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/scenario/bookmarks/"+scenario, "")
+
+	var bookmarks ScenarioBookmarks
+	_ = json.Unmarshal(w.Body.Bytes(), &bookmarks)
+
+	if len(bookmarks.Bookmarks) != 2 || bookmarks.Bookmarks[0].Name != "start" || bookmarks.Bookmarks[0].Position != 0 {
+		t.Fatalf("expected the bookmarks in position order, got %+v", bookmarks)
+	}
+
+	if bookmarks.Bookmarks[1].Note != "stumbles under load" || bookmarks.Bookmarks[1].Time != "2023-01-01 10:00:06.000" {
+		t.Errorf("expected the note and recorded time to be saved, got %+v", bookmarks.Bookmarks[1])
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodDelete, "/scenario/bookmarks/"+scenario+"/start", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the bookmark to be deleted, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodDelete, "/scenario/bookmarks/"+scenario+"/start", "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+}
+
+func TestSeekToBookmark(t *testing.T) {
+	webserver := newTestWebServer(t)
+	scenario := copyTestScenario(t)
+
+	sendTestRequest(t, webserver, http.MethodPost, "/scenario/bookmarks/"+scenario, `{"name":"misfire","position":12}`)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/connect", `{"port":"`+scenario+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unable to connect to %s (%d %s)", scenario, w.Code, w.Body.String())
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/seek", `{"Bookmark":"misfire"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected to seek to the bookmark, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if position := webserver.reader.Playback.state().Position; position != 12 {
+		t.Errorf("expected to seek to position 12, got %d", position)
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/seek", `{"Bookmark":"unknown"}`)
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+}
@@ -0,0 +1,286 @@
+package fcr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+// size of the charts drawn in the session report
+const (
+	chartWidth  = 640
+	chartHeight = 160
+	// the samples are thinned to keep the report a reasonable size
+	maxChartPoints = chartWidth / 2
+)
+
+// reportMetric is a dataframe value summarised in the session report
+type reportMetric struct {
+	name  string
+	unit  string
+	chart bool
+	value func(memsdata rosco.MemsData) float64
+}
+
+var reportMetrics = []reportMetric{
+	{"Engine Speed", "rpm", true, func(m rosco.MemsData) float64 { return float64(m.EngineRPM) }},
+	{"Coolant Temperature", "°C", true, func(m rosco.MemsData) float64 { return float64(m.CoolantTemp) }},
+	{"Intake Air Temperature", "°C", false, func(m rosco.MemsData) float64 { return float64(m.IntakeAirTemp) }},
+	{"Battery Voltage", "V", true, func(m rosco.MemsData) float64 { return float64(m.BatteryVoltage) }},
+	{"Manifold Absolute Pressure", "kPa", true, func(m rosco.MemsData) float64 { return float64(m.ManifoldAbsolutePressure) }},
+	{"Throttle Pot", "V", false, func(m rosco.MemsData) float64 { return float64(m.ThrottlePotSensor) }},
+	{"Idle Air Control Position", "steps", false, func(m rosco.MemsData) float64 { return float64(m.IACPosition) }},
+	{"Ignition Advance", "°", false, func(m rosco.MemsData) float64 { return float64(m.IgnitionAdvance) }},
+	{"Coil Time", "ms", false, func(m rosco.MemsData) float64 { return float64(m.CoilTime) }},
+	{"Lambda Voltage", "mV", true, func(m rosco.MemsData) float64 { return float64(m.LambdaVoltage) }},
+	{"Short Term Fuel Trim", "%", true, func(m rosco.MemsData) float64 { return float64(m.ShortTermFuelTrim) }},
+	{"Long Term Fuel Trim", "%", false, func(m rosco.MemsData) float64 { return float64(m.LongTermFuelTrim) }},
+}
+
+// MetricSummary is the minimum, mean and maximum of a dataframe value over the session
+type MetricSummary struct {
+	Name string
+	Unit string
+	Min  float64
+	Mean float64
+	Max  float64
+}
+
+// SVGChart is a line chart of a dataframe value drawn as SVG
+type SVGChart struct {
+	Title  string
+	Unit   string
+	Width  int
+	Height int
+	// Points is the polyline of the samples scaled to the chart
+	Points string
+	Min    float64
+	Max    float64
+	From   string
+	To     string
+}
+
+// SessionReport summarises the session with the ECU for the customer
+type SessionReport struct {
+	Generated time.Time
+	Version   string
+	Build     string
+	Status    rosco.ECUStatus
+	Started   time.Time
+	// Samples is the number of dataframes read in the session, the metrics are drawn from all of them
+	Samples int
+	From    string
+	To      string
+	// ChartSamples is the number of recent dataframes the charts are drawn from,
+	// only the recent dataframes are kept so the charts may not cover the whole session
+	ChartSamples int
+	ChartFrom    string
+	ChartTo      string
+	Faults       FaultReport
+	// OperationalFaults are the faults found by the analysis of the latest dataframes
+	OperationalFaults []string
+	Metrics           []MetricSummary
+	Charts            []SVGChart
+	// Adjustments are the changes made to the adjustable values, including resets
+	Adjustments []AuditEntry
+	// ActuatorTests are the actuators switched on and off
+	ActuatorTests []AuditEntry
+	Procedures    []ProcedureReport
+}
+
+// GetSessionReport gathers the report for the current, or last, ECU session
+func (reader *MemsReader) GetSessionReport() (SessionReport, error) {
+	config := reader.Config.copy()
+
+	report := SessionReport{
+		Generated: time.Now(),
+		Version:   config.Version,
+		Build:     config.Build,
+		Status:    reader.GetECUStatus(),
+		Started:   reader.Acquisition.Started(),
+		Faults:    reader.Faults.Report(),
+	}
+
+	report.Samples, report.From, report.To, report.Metrics = reader.Statistics.Summary()
+
+	samples := reader.Acquisition.Samples()
+	report.ChartSamples = len(samples)
+
+	if len(samples) > 0 {
+		report.ChartFrom = samples[0].Time
+		report.ChartTo = samples[len(samples)-1].Time
+		report.OperationalFaults = getReportedFaults(samples[len(samples)-1].Analytics)
+
+		for _, metric := range reportMetrics {
+			if metric.chart {
+				report.Charts = append(report.Charts, getSVGChart(samples, metric))
+			}
+		}
+	}
+
+	entries, err := reader.Audit.Read(AuditFilter{From: report.Started, ECUID: report.Status.ECUID})
+	if err != nil {
+		return report, err
+	}
+
+	for _, entry := range entries {
+		switch {
+		case strings.HasPrefix(entry.Command, "test "):
+			report.ActuatorTests = append(report.ActuatorTests, entry)
+		case isAdjustmentCommand(entry.Command):
+			report.Adjustments = append(report.Adjustments, entry)
+		}
+	}
+
+	for _, procedure := range reader.Procedures.Procedures() {
+		if run, ok := reader.Procedures.Report(procedure.Name); ok && !run.Started.Before(report.Started) {
+			report.Procedures = append(report.Procedures, run)
+		}
+	}
+
+	return report, nil
+}
+
+// SessionStatistics keeps the minimum, mean and maximum of the report metrics over the whole session,
+// the acquisition only keeps the recent samples
+type SessionStatistics struct {
+	mutex     sync.RWMutex
+	ecuID     string
+	connected bool
+	samples   int
+	from      string
+	to        string
+	min       []float64
+	max       []float64
+	sum       []float64
+}
+
+// NewSessionStatistics creates empty session statistics
+func NewSessionStatistics() *SessionStatistics {
+	statistics := &SessionStatistics{}
+	statistics.reset()
+
+	return statistics
+}
+
+// Update adds the sample to the statistics, the statistics are cleared when the ECU is connected.
+// This is an acquisition listener.
+func (statistics *SessionStatistics) Update(status rosco.ECUStatus, sample *rosco.MemsData) {
+	statistics.mutex.Lock()
+	defer statistics.mutex.Unlock()
+
+	if status.Connected && (!statistics.connected || status.ECUID != statistics.ecuID) {
+		// new session
+		statistics.reset()
+		statistics.ecuID = status.ECUID
+	}
+
+	statistics.connected = status.Connected
+
+	if sample == nil {
+		return
+	}
+
+	if statistics.samples == 0 {
+		statistics.from = sample.Time
+	}
+
+	statistics.samples++
+	statistics.to = sample.Time
+
+	for i, metric := range reportMetrics {
+		v := metric.value(*sample)
+		statistics.min[i] = math.Min(statistics.min[i], v)
+		statistics.max[i] = math.Max(statistics.max[i], v)
+		statistics.sum[i] += v
+	}
+}
+
+// Summary returns the number of samples in the session, when the first and last were read
+// and the summary of each metric, the metrics are empty if no samples have been read
+func (statistics *SessionStatistics) Summary() (int, string, string, []MetricSummary) {
+	statistics.mutex.RLock()
+	defer statistics.mutex.RUnlock()
+
+	var metrics []MetricSummary
+
+	if statistics.samples == 0 {
+		return 0, "", "", metrics
+	}
+
+	for i, metric := range reportMetrics {
+		mean := statistics.sum[i] / float64(statistics.samples)
+		metrics = append(metrics, MetricSummary{Name: metric.name, Unit: metric.unit, Min: statistics.min[i], Mean: mean, Max: statistics.max[i]})
+	}
+
+	return statistics.samples, statistics.from, statistics.to, metrics
+}
+
+func (statistics *SessionStatistics) reset() {
+	statistics.samples = 0
+	statistics.from = ""
+	statistics.to = ""
+	statistics.min = make([]float64, len(reportMetrics))
+	statistics.max = make([]float64, len(reportMetrics))
+	statistics.sum = make([]float64, len(reportMetrics))
+
+	for i := range reportMetrics {
+		statistics.min[i] = math.Inf(1)
+		statistics.max[i] = math.Inf(-1)
+	}
+}
+
+// isAdjustmentCommand the audited command changed the adjustable values or cleared the faults
+func isAdjustmentCommand(command string) bool {
+	for _, prefix := range []string{"adjust ", "restore ", "reset ", "clear "} {
+		if strings.HasPrefix(command, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getSVGChart draws the value over the samples as a polyline scaled to fit the chart
+func getSVGChart(samples []rosco.MemsData, metric reportMetric) SVGChart {
+	stats := getStatistics(samples, metric.value)
+
+	chart := SVGChart{
+		Title:  metric.name,
+		Unit:   metric.unit,
+		Width:  chartWidth,
+		Height: chartHeight,
+		Min:    stats.min,
+		Max:    stats.max,
+		From:   samples[0].Time,
+		To:     samples[len(samples)-1].Time,
+	}
+
+	// thin the samples to the maximum number of points
+	step := (len(samples) + maxChartPoints - 1) / maxChartPoints
+	scale := stats.max - stats.min
+
+	var points []string
+	for i := 0; i < len(samples); i += step {
+		x := 0.0
+		if len(samples) > 1 {
+			x = float64(i) * chartWidth / float64(len(samples)-1)
+		}
+
+		// a constant value is drawn across the middle of the chart
+		y := chartHeight / 2.0
+		if scale > 0 {
+			y = chartHeight - (metric.value(samples[i])-stats.min)*chartHeight/scale
+		}
+
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+
+	chart.Points = strings.Join(points, " ")
+
+	return chart
+}
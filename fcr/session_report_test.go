@@ -0,0 +1,113 @@
+package fcr
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andrewdjackson/rosco"
+)
+
+func TestSessionReport(t *testing.T) {
+	webserver := newTestWebServer(t)
+	webserver.paths.Webroot = "../resources"
+	connectTestScenario(t, webserver)
+
+	// the session starts with the first poll after connecting
+	for i := 0; i < 5; i++ {
+		webserver.reader.Acquisition.poll()
+	}
+
+	// the idle speed in the test scenario is above the ecu limits, step it back towards them
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/idlespeed", `{"steps":-1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/fuelpump", `{"activate":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodGet, "/session/report?download", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("expected an html report, got %s", contentType)
+	}
+
+	if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment;") {
+		t.Errorf("expected the report to be downloaded, got %q", disposition)
+	}
+
+	status := webserver.reader.GetECUStatus()
+	html := w.Body.String()
+
+	for _, expected := range []string{status.ECUID, status.ECUSerial, "Engine Speed", "<polyline points=", "the last 5 dataframes", "adjust idlespeed", "test fuelpump"} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected the report to contain %q", expected)
+		}
+	}
+
+	// self-contained, nothing is loaded from the server
+	if strings.Contains(html, "<link") || strings.Contains(html, "<script") {
+		t.Errorf("expected the report to be self-contained")
+	}
+
+	webserver.reader.Actuators.Reset()
+}
+
+func TestSessionStatisticsCoverWholeSession(t *testing.T) {
+	statistics := NewSessionStatistics()
+	status := rosco.ECUStatus{Connected: true, ECUID: "99000203"}
+
+	// more samples than the acquisition keeps
+	for i := 0; i < acquisitionBufferSize+100; i++ {
+		statistics.Update(status, &rosco.MemsData{Time: fmt.Sprintf("sample %d", i), EngineRPM: i})
+	}
+
+	samples, from, to, metrics := statistics.Summary()
+
+	if samples != acquisitionBufferSize+100 || from != "sample 0" || to != "sample 699" {
+		t.Errorf("expected 700 samples from sample 0 to 699, got %d from %s to %s", samples, from, to)
+	}
+
+	if rpm := metrics[0]; rpm.Name != "Engine Speed" || rpm.Min != 0 || rpm.Max != 699 || rpm.Mean != 349.5 {
+		t.Errorf("expected the engine speed over the whole session, got %+v", rpm)
+	}
+
+	// a new session starts when the ecu is connected again
+	statistics.Update(rosco.ECUStatus{}, nil)
+	statistics.Update(status, &rosco.MemsData{Time: "reconnected", EngineRPM: 800})
+
+	if samples, from, _, metrics = statistics.Summary(); samples != 1 || from != "reconnected" || metrics[0].Min != 800 {
+		t.Errorf("expected the statistics to be cleared when the ecu is connected, got %d from %s %+v", samples, from, metrics[0])
+	}
+}
+
+func TestSessionReportWithoutDataframes(t *testing.T) {
+	webserver := newTestWebServer(t)
+	webserver.paths.Webroot = "../resources"
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/session/report", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "No dataframes were read") {
+		t.Errorf("expected the report to show no dataframes were read")
+	}
+}
+
+func TestIndexRendersWithTemplateFunctions(t *testing.T) {
+	webserver := newTestWebServer(t)
+	webserver.paths.Webroot = "../resources"
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<title>MEMSFCR") {
+		t.Errorf("expected the index page to render, got %d", w.Code)
+	}
+}
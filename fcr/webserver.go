@@ -1,6 +1,8 @@
 package fcr
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/andrewdjackson/rosco"
@@ -11,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -30,16 +33,20 @@ type WebServer struct {
 	// websocket interface
 	httpDir  string
 	paths    RelativePaths
-	ws       *websocket.Conn
 	upgrader websocket.Upgrader
+	// websocket dataframe stream subscribers
+	stream *DataframeStream
 	// HTTPPort used by the HTTP Server instance
 	HTTPPort int
+	// URL the browser on this computer uses to reach the server
+	URL string
 	// ServerRunning indicates where the server is active
 	ServerRunning bool
+	// started is closed once the server is listening or has failed to start
+	started chan struct{}
+	server  *http.Server
 	// Pointer to Mems Fault Code Reader
 	reader *MemsReader
-	// waiting for a response from the ECU
-	waitingForECUResponse bool
 	// headless mode, supress quit on no browser heartbeat
 	headless bool
 }
@@ -47,6 +54,7 @@ type WebServer struct {
 const (
 	indexTemplate    = "index.template.html"
 	indexData        = "index.template.json"
+	reportTemplate   = "report.template.html"
 	templateWildcard = "*.template.html"
 )
 
@@ -57,15 +65,25 @@ func NewWebServer(reader *MemsReader, headless bool) *WebServer {
 	webserver.HTTPPort = 0
 	webserver.httpDir = ""
 	webserver.ServerRunning = false
+	webserver.started = make(chan struct{})
+	webserver.server = &http.Server{}
 	webserver.reader = reader
 	webserver.paths = RelativePaths{}
 
 	webserver.upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
+		CheckOrigin: webserver.isAllowedOrigin,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			webserver.sendError(w, r, status, ErrorCodeBadRequest, reason.Error())
 		},
 	}
 
+	webserver.stream = NewDataframeStream(reader.Acquisition)
+
+	// push the progress of the diagnostic procedures to the stream subscribers
+	reader.Procedures.AddListener(func(report ProcedureReport) {
+		webserver.stream.broadcast(StreamMessage{Type: StreamProcedure, Data: report})
+	})
+
 	return webserver
 }
 
@@ -108,18 +126,37 @@ func (webserver *WebServer) getRelativePaths() RelativePaths {
 }
 
 func (webserver *WebServer) newRouter() *mux.Router {
-	webserver.paths = webserver.getRelativePaths()
+	// the paths are only found once, they can be set to serve the files from elsewhere
+	if webserver.paths.Webroot == "" {
+		webserver.paths = webserver.getRelativePaths()
+	}
 	webserver.httpDir = webserver.paths.Webroot
 
 	// set a router and a handler to accept messages over the websocket
 
 	r := mux.NewRouter()
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webserver.sendError(w, r, http.StatusMethodNotAllowed, ErrorCodeBadRequest, fmt.Sprintf("%s is not supported by %s", r.Method, r.URL.Path))
+	})
+
+	// only allow requests from this server's pages and the allowed origins,
+	// and require the api token if one is configured
+	r.Use(webserver.originMiddleware, webserver.tokenMiddleware)
+	r.Methods(http.MethodOptions).HandlerFunc(webserver.preflightHandler)
 
 	r.HandleFunc("/heartbeat", webserver.browserHeartbeatHandler)
 
 	r.HandleFunc("/config", webserver.getConfigHandler).Methods(http.MethodGet)
 	r.HandleFunc("/config/ports", webserver.getSerialPortsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/config", webserver.updateConfigHandler).Methods(http.MethodPut)
+	r.HandleFunc("/config/profiles", webserver.getProfilesHandler).Methods(http.MethodGet)
+	r.HandleFunc("/config/profiles", webserver.postProfileHandler).Methods(http.MethodPost)
+	r.HandleFunc("/config/profiles/{name}/select", webserver.postSelectProfileHandler).Methods(http.MethodPost)
+	r.HandleFunc("/config/profiles/{name}", webserver.deleteProfileHandler).Methods(http.MethodDelete)
+
+	r.HandleFunc("/audit", webserver.getAuditHandler).Methods(http.MethodGet)
+
+	r.HandleFunc("/session/report", webserver.getSessionReport).Methods(http.MethodGet)
 
 	r.HandleFunc("/scenario", webserver.getListofScenarios).Methods(http.MethodGet)
 	r.HandleFunc("/scenario/contents/{scenarioId}", webserver.getScenarioContents).Methods(http.MethodGet)
@@ -127,14 +164,35 @@ func (webserver *WebServer) newRouter() *mux.Router {
 	r.HandleFunc("/scenario/progress/{scenarioId}", webserver.getPlaybackProgress).Methods(http.MethodGet)
 	r.HandleFunc("/scenario/convert", webserver.putConvertToScenario).Methods(http.MethodPut)
 	r.HandleFunc("/scenario/seek", webserver.postPlaybackSeek).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/export/{scenarioId}", webserver.getScenarioExport).Methods(http.MethodGet)
+	r.HandleFunc("/scenario/trim/{scenarioId}", webserver.postScenarioTrim).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/split/{scenarioId}", webserver.postScenarioSplit).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/merge", webserver.postScenarioMerge).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/search/{scenarioId}", webserver.getScenarioSearch).Methods(http.MethodGet)
+	r.HandleFunc("/scenario/bookmarks/{scenarioId}", webserver.getScenarioBookmarks).Methods(http.MethodGet)
+	r.HandleFunc("/scenario/bookmarks/{scenarioId}", webserver.postScenarioBookmark).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/bookmarks/{scenarioId}/{name}", webserver.deleteScenarioBookmark).Methods(http.MethodDelete)
+	r.HandleFunc("/scenario/playback", webserver.getPlayback).Methods(http.MethodGet)
+	r.HandleFunc("/scenario/playback/play", webserver.postPlaybackPlay).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/playback/pause", webserver.postPlaybackPause).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/playback/step", webserver.postPlaybackStep).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/playback/speed", webserver.postPlaybackSpeed).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/playback/mode", webserver.postPlaybackMode).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/playback/loop", webserver.postPlaybackLoop).Methods(http.MethodPost)
+	r.HandleFunc("/scenario/playback/loop", webserver.deletePlaybackLoop).Methods(http.MethodDelete)
 
 	r.HandleFunc("/rosco", webserver.getECUConnectionStatus).Methods(http.MethodGet)
 	r.HandleFunc("/rosco/connect", webserver.postECUConnect).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/disconnect", webserver.postECUDisconnect).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/dataframe", webserver.getECUDataframes).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/dataframes", webserver.getECUDataframeHistory).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/stream", webserver.streamHandler).Methods(http.MethodGet)
 	r.HandleFunc("/rosco/heartbeat", webserver.postECUHeartbeat).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/iac", webserver.getECUIAC).Methods(http.MethodGet)
 	r.HandleFunc("/rosco/diagnostics", webserver.getDiagnostics).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/faults", webserver.getECUFaultReport).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/faults/catalogue", webserver.getECUFaultCatalogue).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/queue", webserver.getECUQueueStatus).Methods(http.MethodGet)
 
 	r.HandleFunc("/rosco/reset", webserver.postECUReset).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/reset/ecu", webserver.postECUReset).Methods(http.MethodPost)
@@ -147,7 +205,10 @@ func (webserver *WebServer) newRouter() *mux.Router {
 	r.HandleFunc("/rosco/adjust/idlespeed", webserver.postECUAdjustIdleSpeed).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/adjust/ignitionadvance", webserver.postECUAdjustIgnitionAdvance).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/adjust/iac", webserver.postECUAdjustIAC).Methods(http.MethodPost)
+	r.HandleFunc("/rosco/adjust/snapshot", webserver.postECUAdjustSnapshot).Methods(http.MethodPost)
+	r.HandleFunc("/rosco/adjust/restore", webserver.postECUAdjustRestore).Methods(http.MethodPost)
 
+	r.HandleFunc("/rosco/test", webserver.getECUActuatorStates).Methods(http.MethodGet)
 	r.HandleFunc("/rosco/test/fuelpump", webserver.postECUTestFuelPump).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/test/ptc", webserver.postECUTestPTC).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/test/aircon", webserver.postECUTestAircon).Methods(http.MethodPost)
@@ -158,6 +219,12 @@ func (webserver *WebServer) newRouter() *mux.Router {
 	r.HandleFunc("/rosco/test/fan2", webserver.postECUTestFan2).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/test/injectors", webserver.postECUTestInjectors).Methods(http.MethodPost)
 	r.HandleFunc("/rosco/test/coil", webserver.postECUTestCoil).Methods(http.MethodPost)
+	r.HandleFunc("/rosco/test/off", webserver.postECUTestAllOff).Methods(http.MethodPost)
+
+	r.HandleFunc("/rosco/procedures", webserver.getProcedures).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/procedures/{name}", webserver.getProcedureReport).Methods(http.MethodGet)
+	r.HandleFunc("/rosco/procedures/{name}", webserver.postProcedure).Methods(http.MethodPost)
+	r.HandleFunc("/rosco/procedures/{name}", webserver.deleteProcedure).Methods(http.MethodDelete)
 
 	r.HandleFunc("/", webserver.renderIndex)
 
@@ -175,12 +242,7 @@ func (webserver *WebServer) renderIndex(w http.ResponseWriter, r *http.Request)
 	dataFile := fmt.Sprintf("%s/%s", webserver.paths.Webroot, indexData)
 	dataFile = filepath.ToSlash(dataFile)
 
-	templatePath := fmt.Sprintf("%s/%s", webserver.paths.Webroot, templateWildcard)
-	templatePath = filepath.ToSlash(templatePath)
-
-	log.Infof("rendering html templates in %s", templatePath)
-
-	page, err := template.ParseGlob(templatePath)
+	page, err := webserver.parseTemplates()
 
 	if err != nil {
 		log.Errorf("template error (%s) ", err)
@@ -209,29 +271,78 @@ func (webserver *WebServer) renderIndex(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// parseTemplates parses the html templates in the webroot
+func (webserver *WebServer) parseTemplates() (*template.Template, error) {
+	templatePath := fmt.Sprintf("%s/%s", webserver.paths.Webroot, templateWildcard)
+	templatePath = filepath.ToSlash(templatePath)
+
+	log.Infof("rendering html templates in %s", templatePath)
+
+	return template.New("").Funcs(templateFunctions).ParseGlob(templatePath)
+}
+
 // RunHTTPServer run the server
 func (webserver *WebServer) RunHTTPServer() {
 	// Declare a new router
 	webserver.router = webserver.newRouter()
 
-	serverport := fmt.Sprintf(":%s", webserver.reader.Config.ServerPort)
+	config := webserver.reader.Config.copy()
+	address := net.JoinHostPort(config.Listen, strconv.Itoa(config.ServerPort))
+	scheme := "http"
 
 	// We can then pass our router (after declaring all our routes) to this method
 	// (where previously, we were leaving the second argument as nil)
-	listener, err := net.Listen("tcp", serverport)
+	listener, err := net.Listen("tcp", address)
+
+	if err == nil && config.TLS {
+		var tlsConfig *tls.Config
+
+		if tlsConfig, err = getTLSConfig(config); err == nil {
+			listener = tls.NewListener(listener, tlsConfig)
+			scheme = "https"
+		} else {
+			_ = listener.Close()
+		}
+	}
 
 	if err != nil {
 		log.Errorf("error starting web interface (%s)", err)
+		// nothing can be done without the web interface
+		webserver.reader.Terminate()
+		close(webserver.started)
+		return
 	}
 
 	webserver.HTTPPort = listener.Addr().(*net.TCPAddr).Port
+	webserver.URL = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(getBrowserHost(config.Listen), strconv.Itoa(webserver.HTTPPort)))
+
+	if !isLoopback(config.Listen) && config.Token == "" {
+		log.Warnf("web interface is reachable from other devices on %s without an api token", config.Listen)
+	}
 
-	log.Infof("started http server on port %d", webserver.HTTPPort)
+	log.Infof("started %s server on %s", scheme, listener.Addr())
 	webserver.ServerRunning = true
+	close(webserver.started)
 
-	err = http.Serve(listener, webserver.router)
+	webserver.server.Handler = webserver.router
+	err = webserver.server.Serve(listener)
 
-	if err != nil {
-		log.Errorf("error starting web interface (%s)", err)
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("error running web interface (%s)", err)
 	}
 }
+
+// Shutdown closes the websocket streams and stops the server
+// once the requests in progress have completed
+func (webserver *WebServer) Shutdown(ctx context.Context) error {
+	webserver.stream.Close()
+
+	if !webserver.ServerRunning {
+		return nil
+	}
+
+	webserver.ServerRunning = false
+	log.Infof("stopping http server on port %d", webserver.HTTPPort)
+
+	return webserver.server.Shutdown(ctx)
+}
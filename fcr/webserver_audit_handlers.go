@@ -0,0 +1,79 @@
+package fcr
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getAuditHandler returns the audit entries, filtered by the query parameters
+// from, to (RFC 3339), command (prefix), client, result, ecuid and limit
+func (webserver *WebServer) getAuditHandler(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get audit log")
+
+	filter, invalid := parseAuditFilter(r)
+	if len(invalid) > 0 {
+		webserver.sendValidationError(w, r, invalid)
+		return
+	}
+
+	entries, err := webserver.reader.Audit.Read(filter)
+	if err != nil {
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	webserver.sendResponse(w, r, entries)
+}
+
+// parseAuditFilter reads the filter from the query parameters, returns the reason each invalid parameter was rejected
+func parseAuditFilter(r *http.Request) (AuditFilter, FieldErrors) {
+	var err error
+
+	filter := AuditFilter{}
+	invalid := FieldErrors{}
+	query := r.URL.Query()
+
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			invalid["from"] = "from must be an RFC 3339 time, e.g. 2021-06-01T09:00:00Z"
+		}
+	}
+
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			invalid["to"] = "to must be an RFC 3339 time, e.g. 2021-06-01T17:00:00Z"
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			invalid["limit"] = "limit must be a positive number"
+		}
+	}
+
+	filter.Result = query.Get("result")
+	if filter.Result != "" && filter.Result != AuditResultOK && filter.Result != AuditResultFailed {
+		invalid["result"] = fmt.Sprintf("result must be %s or %s", AuditResultOK, AuditResultFailed)
+	}
+
+	filter.Command = query.Get("command")
+	filter.Client = query.Get("client")
+	filter.ECUID = query.Get("ecuid")
+
+	return filter, invalid
+}
+
+// getClientAddress returns the address of the client that sent the request
+func getClientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -2,10 +2,13 @@ package fcr
 
 import (
 	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"go.bug.st/serial.v1"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type AvailablePorts struct {
@@ -15,51 +18,139 @@ type AvailablePorts struct {
 // REST API : GET Config
 // returns the contents of the Config file as a JSON response
 func (webserver *WebServer) getConfigHandler(w http.ResponseWriter, r *http.Request) {
-	config := webserver.reader.Config
+	config := webserver.reader.Config.copy()
 	log.Infof("rest-get config (%v)", config)
 
-	defer r.Body.Close()
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
-	if err := json.NewEncoder(w).Encode(config); err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	webserver.sendResponse(w, r, config)
 }
 
 // REST API : PUT Config
-// updates the config
+// validates and updates the config, invalid values are rejected
+// with a 400 response listing the reason for each invalid field
 func (webserver *WebServer) updateConfigHandler(w http.ResponseWriter, r *http.Request) {
 	// get the body of our request
-	// unmarshal this into a new Config struct
 	reqBody, _ := ioutil.ReadAll(r.Body)
 
-	// get the current configuration
-	config := ReadConfig()
-	_ = json.Unmarshal(reqBody, &config)
+	// apply the changes to a copy of the current configuration
+	current := webserver.reader.Config.copy()
+	config := current.copy()
+
+	invalid, err := updateConfig(&config, reqBody)
+	if err != nil {
+		webserver.sendError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, err.Error())
+		return
+	}
+
+	// report the range errors for the fields that could be parsed
+	for field, reason := range config.Validate() {
+		if _, found := invalid[field]; !found {
+			invalid[field] = reason
+		}
+	}
+
+	if len(invalid) > 0 {
+		webserver.sendValidationError(w, r, invalid)
+		return
+	}
+
+	// changed values are saved to the config file and replace any override,
+	// values from the selected profile are saved to the profile
+	for _, key := range ConfigKeys {
+		if config.getValue(key) != current.getValue(key) && current.Sources[key] != ConfigSourceProfile {
+			config.Sources[key] = ConfigSourceFile
+		}
+	}
 
 	log.Infof("rest-put update config (%v)", config)
-	// save the configuration
-	WriteConfig(config)
 
-	// return a 200 status code
-	w.WriteHeader(http.StatusOK)
+	// save the configuration and apply it to the running reader
+	configMutex.Lock()
+	*webserver.reader.Config = config
+	configMutex.Unlock()
+
+	WriteConfig(&config)
+
+	webserver.sendResponse(w, r, config)
+}
+
+// updateConfig applies the JSON config values to the config, the field names are
+// case insensitive and numbers and bools may be sent as strings.
+// Read-only and unknown fields are ignored.
+func updateConfig(config *Config, body []byte) (FieldErrors, error) {
+	var fields map[string]json.RawMessage
+
+	invalid := FieldErrors{}
+
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return invalid, fmt.Errorf("malformed request body (%s)", err)
+	}
+
+	for name, value := range fields {
+		var err error
+
+		switch strings.ToLower(name) {
+		case "port":
+			err = json.Unmarshal(value, &config.Port)
+			name = "Port"
+		case "debug":
+			config.Debug, err = parseConfigBool(value)
+			name = "Debug"
+		case "frequency":
+			config.Frequency, err = parseConfigInt(value)
+			name = "Frequency"
+		case "serverport":
+			config.ServerPort, err = parseConfigInt(value)
+			name = "ServerPort"
+		}
+
+		if err != nil {
+			invalid[name] = err.Error()
+		}
+	}
+
+	return invalid, nil
+}
+
+func parseConfigInt(value json.RawMessage) (int, error) {
+	var n int
+
+	if err := json.Unmarshal(value, &n); err == nil {
+		return n, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if n, err = strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			return n, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s is not a whole number", value)
+}
+
+func parseConfigBool(value json.RawMessage) (bool, error) {
+	var b bool
+
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err = strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			return b, nil
+		}
+	}
+
+	return false, fmt.Errorf("%s is not true or false", value)
 }
 
 // rest-api get list of available serial ports
 func (webserver *WebServer) getSerialPortsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Infof("rest-get available serial ports")
 
-	defer r.Body.Close()
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
 	ports := AvailablePorts{Ports: webserver.getSerialPorts()}
-
-	if err := json.NewEncoder(w).Encode(ports); err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	webserver.sendResponse(w, r, ports)
 }
 
 // enumerate the available serial ports
@@ -0,0 +1,151 @@
+package fcr
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// error codes returned in the error response, these identify the cause of the failure
+const (
+	// ErrorCodeBadRequest the request body could not be decoded
+	ErrorCodeBadRequest = "bad_request"
+	// ErrorCodeInvalid one or more values in the request are invalid
+	ErrorCodeInvalid = "invalid_value"
+	// ErrorCodeUnauthorized the api token is missing or wrong
+	ErrorCodeUnauthorized = "unauthorized"
+	// ErrorCodeForbidden the request came from a web page that is not allowed to use the api
+	ErrorCodeForbidden = "origin_not_allowed"
+	// ErrorCodeNotFound the requested item does not exist
+	ErrorCodeNotFound = "not_found"
+	// ErrorCodeNotConnected the ecu must be connected
+	ErrorCodeNotConnected = "ecu_not_connected"
+	// ErrorCodeNotScenario the ecu must be replaying a scenario
+	ErrorCodeNotScenario = "ecu_not_scenario"
+	// ErrorCodeNoData no dataframes have been read from the ecu
+	ErrorCodeNoData = "ecu_no_data"
+	// ErrorCodeConnectFailed the ecu could not be connected
+	ErrorCodeConnectFailed = "ecu_connect_failed"
+	// ErrorCodeCommandFailed the ecu command failed, usually a serial comms fault
+	ErrorCodeCommandFailed = "ecu_command_failed"
+	// ErrorCodeTimeout the ecu command was not sent before it timed out
+	ErrorCodeTimeout = "ecu_timeout"
+	// ErrorCodeInterlock the actuator can't be tested in the current engine state
+	ErrorCodeInterlock = "actuator_interlock"
+	// ErrorCodeProcedureRunning another diagnostic procedure is running
+	ErrorCodeProcedureRunning = "procedure_running"
+	// ErrorCodeECUMismatch the request applies to a different ecu
+	ErrorCodeECUMismatch = "ecu_mismatch"
+	// ErrorCodeScenarioExists the scenario would overwrite an existing scenario
+	ErrorCodeScenarioExists = "scenario_exists"
+	// ErrorCodeInternal the server was unable to complete the request
+	ErrorCodeInternal = "internal_error"
+)
+
+// ErrorResponse is the body returned by every REST call that fails
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Status is the ecu status at the time of the failure
+	Status rosco.ECUStatus `json:"status"`
+	// Errors describes each invalid field when the code is invalid_value
+	Errors FieldErrors `json:"errors,omitempty"`
+}
+
+// FieldErrors maps each invalid field in a request or the config to the reason it was rejected
+type FieldErrors map[string]string
+
+// sendError writes the error response with the http status code
+func (webserver *WebServer) sendError(w http.ResponseWriter, r *http.Request, statusCode int, code string, message string) {
+	response := ErrorResponse{Code: code, Message: message, Status: webserver.reader.GetECUStatus()}
+
+	log.Warnf("rest error %d %s (%s)", statusCode, code, message)
+	webserver.sendStatusResponse(w, r, statusCode, response)
+}
+
+// sendValidationError writes a 400 error response listing the reason each field is invalid
+func (webserver *WebServer) sendValidationError(w http.ResponseWriter, r *http.Request, invalid FieldErrors) {
+	response := ErrorResponse{Code: ErrorCodeInvalid, Message: "invalid values in the request", Status: webserver.reader.GetECUStatus(), Errors: invalid}
+
+	log.Warnf("rest error %d %s (%v)", http.StatusBadRequest, ErrorCodeInvalid, invalid)
+	webserver.sendStatusResponse(w, r, http.StatusBadRequest, response)
+}
+
+// sendECUError writes the error response for a failed ecu command
+func (webserver *WebServer) sendECUError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrTargetOutOfRange) || errors.Is(err, ErrPlaybackPosition) || errors.Is(err, ErrPlaybackSpeed) || errors.Is(err, ErrPlaybackMode) {
+		webserver.sendError(w, r, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+		return
+	}
+
+	switch err {
+	case ErrECUNotConnected:
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeNotConnected, err.Error())
+	case ErrNotScenario:
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeNotScenario, err.Error())
+	case ErrECUQueueTimeout:
+		webserver.sendError(w, r, http.StatusGatewayTimeout, ErrorCodeTimeout, err.Error())
+	case ErrECUQueueClosed:
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeInternal, err.Error())
+	case ErrEngineRunning, ErrEngineRPMUnknown:
+		webserver.sendError(w, r, http.StatusConflict, ErrorCodeInterlock, err.Error())
+	case ErrUnknownActuator, ErrUnknownAdjustment, ErrNoSnapshot:
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+	case ErrSnapshotECU:
+		webserver.sendError(w, r, http.StatusConflict, ErrorCodeECUMismatch, err.Error())
+	case ErrNoDataframes:
+		webserver.sendError(w, r, http.StatusBadGateway, ErrorCodeNoData, err.Error())
+	default:
+		webserver.sendError(w, r, http.StatusBadGateway, ErrorCodeCommandFailed, err.Error())
+	}
+}
+
+// sendStatusResponse writes the data as JSON with the http status code
+func (webserver *WebServer) sendStatusResponse(w http.ResponseWriter, r *http.Request, statusCode int, data interface{}) {
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Warnf("rest error closing response body")
+		}
+	}(r.Body)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		// the status has been sent, all that can be done is log the failure
+		log.Warnf("rest response failed (%s)", err)
+	}
+}
+
+// decodeRequest unmarshals the JSON request body into data,
+// a 400 error response is sent if the body is malformed
+func (webserver *WebServer) decodeRequest(w http.ResponseWriter, r *http.Request, data interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err == nil {
+		err = json.Unmarshal(body, data)
+	}
+
+	if err != nil {
+		webserver.sendError(w, r, http.StatusBadRequest, ErrorCodeBadRequest, "malformed request body ("+err.Error()+")")
+		return false
+	}
+
+	return true
+}
+
+// isECUConnected sends a 503 error response if the ecu is not connected
+func (webserver *WebServer) isECUConnected(w http.ResponseWriter, r *http.Request) bool {
+	if !webserver.reader.GetECUStatus().Connected {
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeNotConnected, ErrECUNotConnected.Error())
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,237 @@
+package fcr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+const testScenario = "testdata/scenario.fcr"
+
+func newTestWebServer(t *testing.T) *WebServer {
+	// keep the config and logs out of the user's home folder
+	t.Setenv("HOME", t.TempDir())
+	homedir.DisableCache = true
+	CreateFolders()
+
+	reader := NewMemsReader(context.Background(), "0.0.0", "test", true)
+	t.Cleanup(reader.Queue.Close)
+
+	return reader.WebServer
+}
+
+func sendTestRequest(t *testing.T, webserver *WebServer, method string, url string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+
+	webserver.newRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func decodeErrorResponse(t *testing.T, w *httptest.ResponseRecorder) ErrorResponse {
+	var response ErrorResponse
+
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("error response is not json (%s) %s", err, w.Body.String())
+	}
+
+	return response
+}
+
+func expectError(t *testing.T, w *httptest.ResponseRecorder, statusCode int, code string) ErrorResponse {
+	if w.Code != statusCode {
+		t.Errorf("expected status %d, got %d (%s)", statusCode, w.Code, w.Body.String())
+	}
+
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("expected a json response, got %s", contentType)
+	}
+
+	response := decodeErrorResponse(t, w)
+
+	if response.Code != code {
+		t.Errorf("expected error code %s, got %s", code, response.Code)
+	}
+
+	if response.Message == "" {
+		t.Errorf("expected an error message")
+	}
+
+	return response
+}
+
+func connectTestScenario(t *testing.T, webserver *WebServer) {
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/connect", `{"port":"`+testScenario+`"}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unable to connect to %s (%d %s)", testScenario, w.Code, w.Body.String())
+	}
+}
+
+func TestMalformedAdjustmentIsBadRequest(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	for _, adjustment := range []string{"stft", "ltft", "idledecay", "idlespeed", "ignitionadvance", "iac"} {
+		w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/"+adjustment, `{"steps":`)
+		expectError(t, w, http.StatusBadRequest, ErrorCodeBadRequest)
+	}
+}
+
+func TestMalformedActuatorTestIsBadRequest(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/test/fuelpump", `{"activate":"yes"}`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeBadRequest)
+}
+
+func TestAdjustmentWhenNotConnected(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/adjust/stft", `{"steps":1}`)
+	response := expectError(t, w, http.StatusServiceUnavailable, ErrorCodeNotConnected)
+
+	if response.Status.Connected {
+		t.Errorf("expected the ecu status to be disconnected")
+	}
+}
+
+func TestDataframeWhenNotConnected(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/rosco/dataframe", "")
+	expectError(t, w, http.StatusServiceUnavailable, ErrorCodeNotConnected)
+}
+
+func TestDataframeBeforeAcquisition(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/rosco/dataframe", "")
+	response := expectError(t, w, http.StatusServiceUnavailable, ErrorCodeNoData)
+
+	if !response.Status.Connected {
+		t.Errorf("expected the ecu status to be connected")
+	}
+}
+
+func TestConnectFailure(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/connect", `{"port":"/dev/memsfcr-missing"}`)
+	expectError(t, w, http.StatusServiceUnavailable, ErrorCodeConnectFailed)
+}
+
+func TestConnectAlreadyConnected(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/connect", `{"port":"`+testScenario+`"}`)
+
+	if w.Code != http.StatusAlreadyReported {
+		t.Errorf("expected status %d, got %d", http.StatusAlreadyReported, w.Code)
+	}
+}
+
+func TestConnectProfileIsNotSelected(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	if err := WriteProfile(Profile{Name: "mini", Port: testScenario}); err != nil {
+		t.Fatalf("unable to write the profile (%s)", err)
+	}
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/rosco/connect", `{"profile":"mini"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unable to connect with the profile (%d %s)", w.Code, w.Body.String())
+	}
+
+	// the connection uses the profile's port without changing the selected profile
+	if port := webserver.reader.Playback.Scenario(); port != testScenario {
+		t.Errorf("expected to connect to %s, got %s", testScenario, port)
+	}
+
+	if profile := ReadConfig().Profile; profile != "" {
+		t.Errorf("expected no profile to be selected in the config file, got %s", profile)
+	}
+
+	if profile := webserver.reader.Config.copy().Profile; profile != "" {
+		t.Errorf("expected no profile to be selected in the running config, got %s", profile)
+	}
+}
+
+func TestSeekOutsideScenario(t *testing.T) {
+	webserver := newTestWebServer(t)
+	connectTestScenario(t, webserver)
+
+	w := sendTestRequest(t, webserver, http.MethodPost, "/scenario/seek", `{"NewPosition":1000}`)
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+
+	w = sendTestRequest(t, webserver, http.MethodPost, "/scenario/seek", `{"NewPosition":5}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestScenarioNotFound(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodGet, "/scenario/details/missing.fcr", "")
+	expectError(t, w, http.StatusNotFound, ErrorCodeNotFound)
+}
+
+func TestInvalidConfigUpdate(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodPut, "/config", `{"Frequency":"abc","ServerPort":70000}`)
+	response := expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	for _, field := range []string{"Frequency", "ServerPort"} {
+		if _, found := response.Errors[field]; !found {
+			t.Errorf("expected an error for %s (%v)", field, response.Errors)
+		}
+	}
+
+	w = sendTestRequest(t, webserver, http.MethodPut, "/config", `{"Frequency":`)
+	expectError(t, w, http.StatusBadRequest, ErrorCodeBadRequest)
+}
+
+func TestConfigUpdateRangeErrors(t *testing.T) {
+	webserver := newTestWebServer(t)
+	before := webserver.reader.Config.copy()
+
+	w := sendTestRequest(t, webserver, http.MethodPut, "/config", `{"frequency":"5","Port":"","debug":"maybe"}`)
+	response := expectError(t, w, http.StatusBadRequest, ErrorCodeInvalid)
+
+	for _, field := range []string{"Frequency", "Port", "Debug"} {
+		if response.Errors[field] == "" {
+			t.Errorf("expected an error for %s (%v)", field, response.Errors)
+		}
+	}
+
+	// nothing is changed if any field is invalid
+	if config := webserver.reader.Config.copy(); config.Frequency != before.Frequency || config.Port != before.Port {
+		t.Errorf("expected the config to be unchanged, got %+v", config)
+	}
+
+	// numbers and bools can be sent as strings
+	w = sendTestRequest(t, webserver, http.MethodPut, "/config", `{"frequency":"250","debug":"true"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if config := ReadConfig(); config.Frequency != 250 || !config.Debug {
+		t.Errorf("expected the frequency and debug to be saved, got %+v", config)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequest(t, webserver, http.MethodDelete, "/rosco/connect", "")
+	expectError(t, w, http.StatusMethodNotAllowed, ErrorCodeBadRequest)
+}
@@ -0,0 +1,84 @@
+package fcr
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProcedureListing is a diagnostic procedure and the report from the last time it was run
+type ProcedureListing struct {
+	Procedure
+	Report *ProcedureReport `json:"report,omitempty"`
+}
+
+// getProcedures returns the diagnostic procedures
+func (webserver *WebServer) getProcedures(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get procedures")
+
+	var listing []ProcedureListing
+
+	for _, procedure := range webserver.reader.Procedures.Procedures() {
+		item := ProcedureListing{Procedure: procedure}
+		if report, ok := webserver.reader.Procedures.Report(procedure.Name); ok {
+			item.Report = &report
+		}
+
+		listing = append(listing, item)
+	}
+
+	webserver.sendResponse(w, r, listing)
+}
+
+// getProcedureReport returns the progress of the procedure, or the report from the last time it was run
+func (webserver *WebServer) getProcedureReport(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	log.Infof("rest-get procedure %s", name)
+
+	report, ok := webserver.reader.Procedures.Report(name)
+	if !ok {
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, "procedure "+name+" has not been run")
+		return
+	}
+
+	webserver.sendResponse(w, r, report)
+}
+
+// postProcedure starts the procedure, the progress is pushed to the stream subscribers
+// as each step starts and finishes
+func (webserver *WebServer) postProcedure(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	log.Infof("rest-post procedure %s", name)
+
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	report, err := webserver.reader.Procedures.Start(name, getClientAddress(r))
+
+	switch err {
+	case nil:
+		webserver.sendStatusResponse(w, r, http.StatusAccepted, report)
+	case ErrUnknownProcedure:
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+	case ErrProcedureRunning:
+		webserver.sendError(w, r, http.StatusConflict, ErrorCodeProcedureRunning, err.Error()+" ("+report.Procedure+")")
+	default:
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+	}
+}
+
+// deleteProcedure aborts the procedure if it is running
+func (webserver *WebServer) deleteProcedure(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	log.Infof("rest-delete procedure %s", name)
+
+	if err := webserver.reader.Procedures.Abort(name); err != nil {
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+		return
+	}
+
+	report, _ := webserver.reader.Procedures.Report(name)
+	webserver.sendResponse(w, r, report)
+}
@@ -0,0 +1,110 @@
+package fcr
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// REST API : GET Profiles
+// returns the vehicle profiles and the selected profile
+func (webserver *WebServer) getProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get profiles")
+
+	profiles, err := ReadProfiles()
+	if err != nil {
+		log.Warnf("rest-get unable to read profiles (%s)", err)
+	}
+
+	webserver.sendResponse(w, r, Profiles{Selected: webserver.reader.Config.copy().Profile, Profiles: profiles})
+}
+
+// REST API : POST Profile
+// creates or replaces a vehicle profile
+func (webserver *WebServer) postProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var profile Profile
+
+	if !webserver.decodeRequest(w, r, &profile) {
+		return
+	}
+
+	if invalid := profile.Validate(); len(invalid) > 0 {
+		webserver.sendValidationError(w, r, invalid)
+		return
+	}
+
+	log.Infof("rest-post profile (%+v)", profile)
+
+	if err := WriteProfile(profile); err != nil {
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, fmt.Sprintf("unable to save profile %s (%s)", profile.Name, err))
+		return
+	}
+
+	// apply the changes if the profile is in use
+	if profile.Name == webserver.reader.Config.copy().Profile {
+		webserver.reader.ReloadConfig()
+	}
+
+	webserver.sendStatusResponse(w, r, http.StatusCreated, profile)
+}
+
+// REST API : POST Select Profile
+// selects the vehicle profile and applies the profile settings to the config
+func (webserver *WebServer) postSelectProfileHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	log.Infof("rest-post select profile %s", name)
+
+	if err := SelectProfile(name); err != nil {
+		webserver.sendProfileError(w, r, name, err)
+		return
+	}
+
+	webserver.reader.ReloadConfig()
+	webserver.sendResponse(w, r, webserver.reader.Config.copy())
+}
+
+// REST API : DELETE Profile
+// deletes the vehicle profile, the config settings are used if the profile was selected
+func (webserver *WebServer) deleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	log.Infof("rest-delete profile %s", name)
+
+	if err := DeleteProfile(name); err != nil {
+		webserver.sendProfileError(w, r, name, err)
+		return
+	}
+
+	if name == webserver.reader.Config.copy().Profile {
+		webserver.reader.ReloadConfig()
+	}
+
+	webserver.getProfilesHandler(w, r)
+}
+
+func (webserver *WebServer) sendProfileError(w http.ResponseWriter, r *http.Request, name string, err error) {
+	message := fmt.Sprintf("profile %s (%s)", name, err)
+
+	if err == ErrProfileNotFound {
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, message)
+	} else {
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, message)
+	}
+}
+
+// checkProfileECUID warns if the connected ECU is not the ECU recorded in the profile
+func (webserver *WebServer) checkProfileECUID(name string) {
+	if name == "" {
+		return
+	}
+
+	profile, err := ReadProfile(name)
+	if err != nil || profile.ECUID == "" {
+		return
+	}
+
+	if ecuID := webserver.reader.GetECUStatus().ECUID; ecuID != profile.ECUID {
+		log.Warnf("connected ecu %s does not match the ecu %s in profile %s", ecuID, profile.ECUID, name)
+	}
+}
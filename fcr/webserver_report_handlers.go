@@ -0,0 +1,74 @@
+package fcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reportTimeFormat is how times are shown in the session report
+const reportTimeFormat = "2006-01-02 15:04:05"
+
+// templateFunctions are available to all the html templates
+var templateFunctions = template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(reportTimeFormat)
+	},
+	"json": func(value interface{}) string {
+		if value == nil {
+			return ""
+		}
+		data, _ := json.Marshal(value)
+		return string(data)
+	},
+	"join": strings.Join,
+	"add": func(a int, b int) int {
+		return a + b
+	},
+}
+
+// getSessionReport renders a self-contained html report of the ECU session,
+// the report is downloaded as a file if the download parameter is set
+func (webserver *WebServer) getSessionReport(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get session report")
+
+	report, err := webserver.reader.GetSessionReport()
+	if err != nil {
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+		return
+	}
+
+	page, err := webserver.parseTemplates()
+	if err != nil {
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "template error ("+err.Error()+")")
+		return
+	}
+
+	// render to a buffer so a template error can still be reported
+	var html bytes.Buffer
+	if err = page.ExecuteTemplate(&html, reportTemplate, report); err != nil {
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, "template error ("+err.Error()+")")
+		return
+	}
+
+	if _, download := r.URL.Query()["download"]; download {
+		filename := fmt.Sprintf("memsfcr-report-%s.html", report.Generated.Format("20060102-150405"))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if _, err = html.WriteTo(w); err != nil {
+		log.Warnf("rest-get session report response failed (%s)", err)
+	}
+}
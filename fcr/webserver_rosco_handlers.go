@@ -1,19 +1,21 @@
 package fcr
 
 import (
-	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
-	"io"
-	"io/ioutil"
 	"net/http"
 )
 
 type ECUConnectionPort struct {
 	Port string `json:"port"`
+	// Profile connects to the port in the vehicle profile without selecting the profile
+	Profile string `json:"profile"`
 }
 
 type ECUAdjustment struct {
 	Steps int `json:"steps"`
+	// Target steps the adjustment until the ECU reports the target value
+	Target *int `json:"target,omitempty"`
 }
 
 type ECUActivate struct {
@@ -25,6 +27,13 @@ type ECUActivateResponse struct {
 	Activate bool   `json:"activate"`
 }
 
+type RestoreResponse struct {
+	Snapshot AdjustmentSnapshot `json:"snapshot"`
+	Results  []AdjustmentResult `json:"results"`
+	// Restored is true if every value was restored to the snapshot
+	Restored bool `json:"restored"`
+}
+
 type ActionResponse struct {
 	Success bool `json:"success"`
 }
@@ -32,6 +41,10 @@ type ActionResponse struct {
 type AdjustmentResponse struct {
 	Adjustment string `json:"adjustment"`
 	Value      int    `json:"value"`
+	// Target, Steps and Reached report the progress towards a target
+	Target  *int `json:"target,omitempty"`
+	Steps   int  `json:"steps,omitempty"`
+	Reached bool `json:"reached,omitempty"`
 }
 
 const ActuatorFuelPump = "fuelpump"
@@ -44,6 +57,13 @@ const ActuatorFan2 = "fan2"
 const ActuatorInjectors = "injectors"
 const ActuatorCoil = "coil"
 
+const AdjustmentSTFT = "stft"
+const AdjustmentLTFT = "ltft"
+const AdjustmentIdleDecay = "idledecay"
+const AdjustmentIdleSpeed = "idlespeed"
+const AdjustmentIgnitionAdvance = "ignitionadvance"
+const AdjustmentIAC = "iac"
+
 //
 // Connection Status
 // returns the status of the ecu connection along with the ecu id and the iac initial position
@@ -51,21 +71,8 @@ const ActuatorCoil = "coil"
 func (webserver *WebServer) getECUConnectionStatus(w http.ResponseWriter, r *http.Request) {
 	log.Infof("rest-get read ecu status")
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Warnf("rest error closing response body")
-		}
-	}(r.Body)
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
-	status := webserver.reader.ECU.Status
-	if err := json.NewEncoder(w).Encode(status); err != nil {
-		log.Warnf("rest-post response failed")
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	status := webserver.reader.GetECUStatus()
+	webserver.sendResponse(w, r, status)
 }
 
 //
@@ -73,134 +80,146 @@ func (webserver *WebServer) getECUConnectionStatus(w http.ResponseWriter, r *htt
 //
 func (webserver *WebServer) postECUConnect(w http.ResponseWriter, r *http.Request) {
 	var connected bool
-	var err error
+	var port ECUConnectionPort
 
 	log.Infof("rest-post connect ecu")
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+	if webserver.reader.GetECUStatus().Connected {
+		log.Warnf("rest-post already connected to the ecu")
+		// return status if already connected
+		webserver.sendStatusResponse(w, r, http.StatusAlreadyReported, webserver.reader.GetECUStatus())
+		return
+	}
+
+	// get the body of our POST request
+	// unmarshal this into the connection port
+	if !webserver.decodeRequest(w, r, &port) {
+		return
+	}
+
+	// the profile is only used for this connection, the selected profile is left unchanged
+	if port.Profile != "" {
+		profile, err := ReadProfile(port.Profile)
 		if err != nil {
-			log.Warnf("rest error closing response body")
+			webserver.sendProfileError(w, r, port.Profile, err)
+			return
 		}
-	}(r.Body)
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		config := webserver.reader.Config.copy()
+		if source := config.Sources["port"]; source == ConfigSourceEnv || source == ConfigSourceFlag {
+			log.Warnf("rest-post using port %s from profile %s in place of port %s set by the %s", profile.Port, profile.Name, config.Port, source)
+		}
 
-	if webserver.reader.ECU.Status.Connected {
-		log.Warnf("rest-post already connected to the ecu")
-		// return status if already connected
-		w.WriteHeader(http.StatusAlreadyReported)
-	} else {
-		// get the body of our POST request
-		// unmarshal this into a new Config struct
-		reqBody, _ := ioutil.ReadAll(r.Body)
-
-		// get the current configuration
-		var port ECUConnectionPort
-		_ = json.Unmarshal(reqBody, &port)
-
-		log.Infof("rest-post connecting ecu (%v)", port)
-
-		if connected, err = webserver.reader.ECU.ConnectAndInitialiseECU(port.Port); err == nil {
-			log.Infof("rest-post connected (%t) to the ecu", connected)
-			// return a 200 status code
-			w.WriteHeader(http.StatusOK)
-		} else {
-			log.Warnf("rest-post unable to connect to the ecu")
-			// return service unavailable if unable to connect
-			w.WriteHeader(http.StatusServiceUnavailable)
+		port.Port = profile.Port
+	}
+
+	log.Infof("rest-post connecting ecu (%v)", port)
+
+	err := webserver.sendECUCommand("connect", PriorityHigh, func() error {
+		var err error
+		connected, err = webserver.reader.ECU.ConnectAndInitialiseECU(port.Port)
+		if connected {
+			// nothing read from the previous session can be relied on
+			webserver.reader.Acquisition.clear()
+		}
+		return err
+	})
+
+	if err != nil || !connected {
+		message := fmt.Sprintf("unable to connect to the ecu on %s", port.Port)
+		if err != nil {
+			message = fmt.Sprintf("%s (%s)", message, err)
 		}
+
+		// return service unavailable if unable to connect
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeConnectFailed, message)
+		return
 	}
 
-	if err = json.NewEncoder(w).Encode(webserver.reader.ECU.Status); err != nil {
-		log.Warnf("rest-post ecu connect response failed")
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	// bookmarks are looked up in the scenario being replayed
+	scenario := ""
+	if webserver.isECUScenarioReader() {
+		scenario = port.Port
 	}
+	webserver.reader.Playback.SetScenario(scenario)
+
+	log.Infof("rest-post connected (%t) to the ecu", connected)
+	webserver.checkProfileECUID(port.Profile)
+
+	webserver.sendResponse(w, r, webserver.reader.GetECUStatus())
 }
 
 //
 // Disconnect the ECU
 //
 func (webserver *WebServer) postECUDisconnect(w http.ResponseWriter, r *http.Request) {
-	var err error
-
 	log.Infof("rest-post disconnect ecu")
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Warnf("rest error closing response body")
-		}
-	}(r.Body)
+	if !webserver.reader.GetECUStatus().Connected {
+		// return status if already disconnected
+		webserver.sendStatusResponse(w, r, http.StatusAlreadyReported, webserver.reader.GetECUStatus())
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	// don't leave anything running
+	webserver.reader.Procedures.Stop()
 
-	if !webserver.reader.ECU.Status.Connected {
-		// return status if already disconnected
-		w.WriteHeader(http.StatusAlreadyReported)
-	} else {
-		// disconnect the ECU
-		if err = webserver.reader.ECU.Disconnect(); err == nil {
-			log.Infof("rest-post disconnected from the ecu")
-			// return a 200 status code
-			w.WriteHeader(http.StatusOK)
-		} else {
-			log.Warnf("rest-post unable to disconnect the ecu")
-			// return service unavailable if unable to connect
-			w.WriteHeader(http.StatusServiceUnavailable)
+	if webserver.reader.Actuators.IsActive() {
+		if err := webserver.reader.Actuators.AllOff(getClientAddress(r)); err != nil {
+			log.Warnf("rest-post unable to switch off the actuators (%s)", err)
 		}
 	}
 
-	if err := json.NewEncoder(w).Encode(webserver.reader.ECU.Status); err != nil {
-		log.Warnf("rest-post disconnect ecu response failed")
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	// disconnect the ECU
+	if err := webserver.sendECUCommand("disconnect", PriorityHigh, webserver.reader.ECU.Disconnect); err != nil {
+		log.Warnf("rest-post unable to disconnect the ecu")
+		webserver.sendECUError(w, r, err)
+		return
 	}
+
+	webserver.reader.Actuators.Reset()
+
+	log.Infof("rest-post disconnected from the ecu")
+	webserver.sendResponse(w, r, webserver.reader.GetECUStatus())
 }
 
 //
 // Read the Dataframes from the ECU
 // the dataframes contain the engine running parameters and fault codes
+// the ECU is polled in the background, this returns the latest sample
 //
 func (webserver *WebServer) getECUDataframes(w http.ResponseWriter, r *http.Request) {
 	log.Infof("rest-get read ecu dataframes")
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Warnf("rest error closing response body")
-		}
-	}(r.Body)
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
-	if webserver.isECUConnected(w) {
-		if !webserver.waitingForECUResponse {
-			// set the flag to prevent calls mid-protocol
-			webserver.waitingForECUResponse = true
-			// get the ECU data
-			if memsdata, err := webserver.reader.ECU.GetDataframes(); err == nil {
-				log.Infof("rest-get ecu dataframes (%+v)", memsdata)
-
-				if err := json.NewEncoder(w).Encode(memsdata); err != nil {
-					log.Warnf("rest-get read ecu dataframes response failed")
-					// return a error code
-					w.WriteHeader(http.StatusInternalServerError)
-				}
-			} else {
-				log.Warnf("rest-get read ecu dataframes serial comms fault")
-				// return a error code
-				w.WriteHeader(http.StatusNotFound)
-			}
-			// clear the flag
-			webserver.waitingForECUResponse = false
-		} else {
-			log.Warnf("rest-get already waiting for ECU")
-			// return a error code
-			w.WriteHeader(http.StatusTooManyRequests)
-		}
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	// report the serial comms fault if the last poll of the ecu failed
+	if err := webserver.reader.Acquisition.LastError(); err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
+
+	memsdata, ok := webserver.reader.Acquisition.Latest()
+	if !ok {
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeNoData, "no dataframes have been read from the ecu")
+		return
+	}
+
+	log.Infof("rest-get ecu dataframes (%+v)", memsdata)
+	webserver.sendResponse(w, r, memsdata)
+}
+
+//
+// Read the recent Dataframes
+// returns the samples held by the acquisition, oldest first
+//
+func (webserver *WebServer) getECUDataframeHistory(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get read ecu dataframe history")
+
+	samples := webserver.reader.Acquisition.Samples()
+	webserver.sendResponse(w, r, samples)
 }
 
 //
@@ -210,23 +229,9 @@ func (webserver *WebServer) getECUDataframes(w http.ResponseWriter, r *http.Requ
 func (webserver *WebServer) getDiagnostics(w http.ResponseWriter, r *http.Request) {
 	log.Infof("rest-get read diagnostics")
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Warnf("rest error closing response body")
-		}
-	}(r.Body)
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
 	// webserver.reader.ECU.Diagnostics.Analyse()
 	diagnostics := webserver.reader.ECU.Diagnostics
-
-	if err := json.NewEncoder(w).Encode(diagnostics); err != nil {
-		log.Warnf("rest-post response failed (%+v)", err)
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	webserver.sendResponse(w, r, diagnostics)
 }
 
 //
@@ -236,119 +241,127 @@ func (webserver *WebServer) getDiagnostics(w http.ResponseWriter, r *http.Reques
 // the ecu has no feedback from the stepper motor, the iac position is a calculated position
 //
 func (webserver *WebServer) getECUIAC(w http.ResponseWriter, r *http.Request) {
+	var value int
+
 	log.Infof("rest-get read ecu iac position")
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Warnf("rest error closing response body")
-		}
-	}(r.Body)
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	err := webserver.sendECUCommand("iac", PriorityNormal, func() error {
+		var err error
+		value, err = webserver.reader.ECU.GetIACPosition()
+		return err
+	})
+
+	if err != nil {
+		log.Warnf("rest-get iac position response failed")
+		webserver.sendECUError(w, r, err)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	log.Infof("rest-get ecu iac position (%v)", value)
+	webserver.sendResponse(w, r, AdjustmentResponse{Adjustment: AdjustmentIAC, Value: value})
+}
 
-	if webserver.isECUConnected(w) {
-		if value, err := webserver.reader.ECU.GetIACPosition(); err == nil {
-			response := AdjustmentResponse{Adjustment: "iac", Value: value}
+//
+// Faults
+// returns the active faults decoded from the dataframes and the faults seen since the ecu was connected
+//
+func (webserver *WebServer) getECUFaultReport(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get ecu faults")
 
-			log.Infof("rest-get ecu iac position (%v)", value)
+	webserver.sendResponse(w, r, webserver.reader.Faults.Report())
+}
 
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				log.Warnf("rest-get iac position response failed")
-				// return a error code
-				w.WriteHeader(http.StatusInternalServerError)
-			}
-		} else {
-			log.Warnf("rest-get iac position response failed")
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-	}
+//
+// Fault Catalogue
+// returns the definition of every fault code the ecu can report
+//
+func (webserver *WebServer) getECUFaultCatalogue(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get ecu fault catalogue")
+
+	webserver.sendResponse(w, r, FaultCatalogue)
 }
 
 //
 //  send heartbeat the ecu
 //
 func (webserver *WebServer) postECUHeartbeat(w http.ResponseWriter, r *http.Request) {
-	value := false
-
 	log.Infof("rest-post send heartbeat")
-	if err := webserver.reader.ECU.SendHeartbeat(); err == nil {
-		value = true
-	}
-
-	webserver.updateECUState(w, r, value)
+	webserver.updateECUState(w, r, "heartbeat", webserver.reader.ECU.SendHeartbeat)
 }
 
 //
 //  reset the ecu
 //
 func (webserver *WebServer) postECUReset(w http.ResponseWriter, r *http.Request) {
-	value := false
-
 	log.Infof("rest-post reset ecu")
-
-	if webserver.isECUConnected(w) {
-		if err := webserver.reader.ECU.ResetECU(); err == nil {
-			value = true
-		}
-	}
-
-	webserver.updateECUState(w, r, value)
+	webserver.changeECUState(w, r, "reset ecu", webserver.reader.ECU.ResetECU, webserver.reader.auditAdjustableValues)
 }
 
 //
 // clear the fault codes
 //
 func (webserver *WebServer) postECUClearFaults(w http.ResponseWriter, r *http.Request) {
-	value := false
 	log.Infof("rest-post clear ecu faults")
-
-	if webserver.isECUConnected(w) {
-		if err := webserver.reader.ECU.ClearFaults(); err == nil {
-			value = true
-		}
-	}
-	webserver.updateECUState(w, r, value)
+	webserver.changeECUState(w, r, "clear faults", webserver.reader.ECU.ClearFaults, webserver.reader.auditFaultCodes)
 }
 
 //
 // clear the adjustable values
 //
 func (webserver *WebServer) postECUClearAdjustments(w http.ResponseWriter, r *http.Request) {
-	value := false
 	log.Infof("rest-post clear ecu adjustable values")
+	webserver.changeECUState(w, r, "clear adjustments", webserver.reader.ECU.ResetAdjustments, webserver.reader.auditAdjustableValues)
+}
 
-	if webserver.isECUConnected(w) {
-		if err := webserver.reader.ECU.ResetAdjustments(); err == nil {
-			value = true
-		}
+//
+// update ecu state (heartbeat)
+//
+func (webserver *WebServer) updateECUState(w http.ResponseWriter, r *http.Request, name string, command func() error) {
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	if err := webserver.sendECUCommand(name, PriorityNormal, command); err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
-	webserver.updateECUState(w, r, value)
+
+	webserver.sendResponse(w, r, ActionResponse{Success: true})
 }
 
 //
-// update ecu state (clear faults, reset and heartbeat)
+// change the ecu state (clear faults and resets)
+// the values read before and after the command are recorded in the audit log
 //
-func (webserver *WebServer) updateECUState(w http.ResponseWriter, r *http.Request, value bool) {
-	if webserver.isECUConnected(w) {
+func (webserver *WebServer) changeECUState(w http.ResponseWriter, r *http.Request, name string, command func() error, read func() interface{}) {
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	entry := AuditEntry{Client: getClientAddress(r), Command: name}
 
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				log.Warnf("rest error closing response body")
-			}
-		}(r.Body)
+	err := webserver.sendECUCommand(name, PriorityNormal, func() error {
+		entry.Before = read()
+		if err := command(); err != nil {
+			return err
+		}
 
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		entry.After = read()
+		return nil
+	})
 
-		response := ActionResponse{Success: value}
+	webserver.reader.audit(entry, err)
 
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Warnf("rest-call response failed")
-			// return a error code
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	if err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
+
+	webserver.sendResponse(w, r, ActionResponse{Success: true})
 }
 
 //
@@ -360,19 +373,10 @@ func (webserver *WebServer) postECUAdjustSTFT(w http.ResponseWriter, r *http.Req
 	log.Infof("rest-post update ecu stft")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// unmarshal this into the adjustment
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateAdjustableValue(w, r, AdjustmentSTFT, data)
 	}
-
-	value, _ := webserver.reader.ECU.AdjustShortTermFuelTrim(data.Steps)
-	adjustment := AdjustmentResponse{Adjustment: "stft", Value: value}
-	webserver.updateAdjustableValue(w, r, adjustment)
 }
 
 //
@@ -384,19 +388,10 @@ func (webserver *WebServer) postECUAdjustLTFT(w http.ResponseWriter, r *http.Req
 	log.Infof("rest-post update ecu ltft")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// unmarshal this into the adjustment
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateAdjustableValue(w, r, AdjustmentLTFT, data)
 	}
-
-	value, _ := webserver.reader.ECU.AdjustLongTermFuelTrim(data.Steps)
-	adjustment := AdjustmentResponse{Adjustment: "ltft", Value: value}
-	webserver.updateAdjustableValue(w, r, adjustment)
 }
 
 //
@@ -408,19 +403,10 @@ func (webserver *WebServer) postECUAdjustIdleDecay(w http.ResponseWriter, r *htt
 	log.Infof("rest-post update ecu idle decay")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the adjustment
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateAdjustableValue(w, r, AdjustmentIdleDecay, data)
 	}
-
-	value, _ := webserver.reader.ECU.AdjustIdleDecay(data.Steps)
-	adjustment := AdjustmentResponse{Adjustment: "idledecay", Value: value}
-
-	webserver.updateAdjustableValue(w, r, adjustment)
 }
 
 //
@@ -432,19 +418,10 @@ func (webserver *WebServer) postECUAdjustIdleSpeed(w http.ResponseWriter, r *htt
 	log.Infof("rest-post update ecu idle speed")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the adjustment
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateAdjustableValue(w, r, AdjustmentIdleSpeed, data)
 	}
-
-	value, _ := webserver.reader.ECU.AdjustIdleSpeed(data.Steps)
-	adjustment := AdjustmentResponse{Adjustment: "idlespeed", Value: value}
-
-	webserver.updateAdjustableValue(w, r, adjustment)
 }
 
 //
@@ -453,22 +430,13 @@ func (webserver *WebServer) postECUAdjustIdleSpeed(w http.ResponseWriter, r *htt
 func (webserver *WebServer) postECUAdjustIgnitionAdvance(w http.ResponseWriter, r *http.Request) {
 	var data ECUAdjustment
 
-	log.Infof("rest-post update ecu idle speed")
+	log.Infof("rest-post update ecu ignition advance")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the adjustment
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateAdjustableValue(w, r, AdjustmentIgnitionAdvance, data)
 	}
-
-	value, _ := webserver.reader.ECU.AdjustIgnitionAdvanceOffset(data.Steps)
-	adjustment := AdjustmentResponse{Adjustment: "ignitionadvance", Value: value}
-
-	webserver.updateAdjustableValue(w, r, adjustment)
 }
 
 //
@@ -477,75 +445,124 @@ func (webserver *WebServer) postECUAdjustIgnitionAdvance(w http.ResponseWriter,
 func (webserver *WebServer) postECUAdjustIAC(w http.ResponseWriter, r *http.Request) {
 	var data ECUAdjustment
 
-	log.Infof("rest-post update ecu idle speed")
+	log.Infof("rest-post update ecu iac position")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
+	// unmarshal this into the adjustment
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateAdjustableValue(w, r, AdjustmentIAC, data)
+	}
+}
 
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+//
+// snapshot the adjustable values
+// records the current values so they can be restored
+//
+func (webserver *WebServer) postECUAdjustSnapshot(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-post snapshot adjustable values")
+
+	if !webserver.isECUConnected(w, r) {
+		return
 	}
 
-	value, _ := webserver.reader.ECU.AdjustIACPosition(data.Steps)
-	adjustment := AdjustmentResponse{Adjustment: "iac", Value: value}
+	snapshot, err := webserver.reader.TakeSnapshot()
+	if err != nil {
+		webserver.sendECUError(w, r, err)
+		return
+	}
 
-	webserver.updateAdjustableValue(w, r, adjustment)
+	webserver.sendResponse(w, r, snapshot)
 }
 
 //
-// update the adjustable value
+// restore the adjustable values
+// steps each value back to the snapshot and reports how close it got
 //
-func (webserver *WebServer) updateAdjustableValue(w http.ResponseWriter, r *http.Request, adjustment AdjustmentResponse) {
-	if webserver.isECUConnected(w) {
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				log.Warnf("rest error closing response body")
-			}
-		}(r.Body)
+func (webserver *WebServer) postECUAdjustRestore(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-post restore adjustable values")
 
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
 
-		log.Infof("rest-post adjustable value response")
-		response := AdjustmentResponse{Adjustment: adjustment.Adjustment, Value: adjustment.Value}
+	snapshot, results, err := webserver.reader.RestoreSnapshot(getClientAddress(r))
+	if err != nil {
+		webserver.sendECUError(w, r, err)
+		return
+	}
 
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Warnf("rest-call response failed")
-			// return a error code
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	response := RestoreResponse{Snapshot: snapshot, Results: results, Restored: true}
+	for _, result := range results {
+		response.Restored = response.Restored && result.Reached
 	}
+
+	log.Infof("rest-post restored adjustable values (%v)", response.Restored)
+	webserver.sendResponse(w, r, response)
 }
 
 //
-// test the fuel pump
+// update the adjustable value
 //
-func (webserver *WebServer) postECUTestFuelPump(w http.ResponseWriter, r *http.Request) {
-	var data ECUActivate
+func (webserver *WebServer) updateAdjustableValue(w http.ResponseWriter, r *http.Request, adjustment string, data ECUAdjustment) {
+	if data.Target != nil && data.Steps != 0 {
+		webserver.sendValidationError(w, r, map[string]string{"target": "give either steps or a target, not both"})
+		return
+	}
 
-	log.Infof("rest-post test fuel pump")
+	if data.Target != nil {
+		if min, max, err := getAdjustmentLimits(adjustment); err == nil && (*data.Target < min || *data.Target > max) {
+			webserver.sendValidationError(w, r, map[string]string{"target": fmt.Sprintf("%s target must be between %d and %d", adjustment, min, max)})
+			return
+		}
+	}
 
-	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
 
-	err := json.Unmarshal(body, &data)
+	if data.Target != nil {
+		webserver.updateAdjustableValueToTarget(w, r, adjustment, *data.Target)
+		return
+	}
+
+	value, err := webserver.reader.AdjustBySteps(getClientAddress(r), adjustment, data.Steps)
 	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+		webserver.sendECUError(w, r, err)
 		return
 	}
 
-	if err := webserver.reader.ECU.TestFuelPump(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	log.Infof("rest-post adjustable value response")
+	webserver.sendResponse(w, r, AdjustmentResponse{Adjustment: adjustment, Value: value})
+}
+
+//
+// step the adjustable value to the target
+// responds with the final value and the number of steps taken
+//
+func (webserver *WebServer) updateAdjustableValueToTarget(w http.ResponseWriter, r *http.Request, adjustment string, target int) {
+	result, err := webserver.reader.AdjustToTarget(getClientAddress(r), adjustment, target)
+	if err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
 
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorFuelPump, Activate: data.Activate}
+	log.Infof("rest-post adjusted %s to %d in %d steps (target %d)", adjustment, result.Value, result.Steps, target)
+	webserver.sendResponse(w, r, AdjustmentResponse{Adjustment: adjustment, Value: result.Value, Target: &target, Steps: result.Steps, Reached: result.Reached})
+}
 
-	webserver.updateTestActuator(w, r, actuatorResponse)
+//
+// test the fuel pump
+//
+func (webserver *WebServer) postECUTestFuelPump(w http.ResponseWriter, r *http.Request) {
+	var data ECUActivate
+
+	log.Infof("rest-post test fuel pump")
+
+	// get the body of our POST request
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorFuelPump, data.Activate)
+	}
 }
 
 //
@@ -557,22 +574,10 @@ func (webserver *WebServer) postECUTestPTC(w http.ResponseWriter, r *http.Reques
 	log.Infof("rest-post test PTC")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestPTCRelay(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorPTC, data.Activate)
 	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorPTC, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -584,22 +589,10 @@ func (webserver *WebServer) postECUTestAircon(w http.ResponseWriter, r *http.Req
 	log.Infof("rest-post test aircon")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestACRelay(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorAircon, data.Activate)
 	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorAircon, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -611,22 +604,10 @@ func (webserver *WebServer) postECUTestPurgeValve(w http.ResponseWriter, r *http
 	log.Infof("rest-post test purge valve")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestPurgeValve(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorPurgeValve, data.Activate)
 	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorPurgeValve, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -638,22 +619,10 @@ func (webserver *WebServer) postECUTestBoostValve(w http.ResponseWriter, r *http
 	log.Infof("rest-post test boost valve")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestBoostValve(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorBoostValve, data.Activate)
 	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorBoostValve, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -665,22 +634,10 @@ func (webserver *WebServer) postECUTestFan1(w http.ResponseWriter, r *http.Reque
 	log.Infof("rest-post test fan 1")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestFan1(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorFan1, data.Activate)
 	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorFan1, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -692,22 +649,10 @@ func (webserver *WebServer) postECUTestFan2(w http.ResponseWriter, r *http.Reque
 	log.Infof("rest-post test fan 2")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestFan2(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorFan2, data.Activate)
 	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorFan2, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -719,22 +664,10 @@ func (webserver *WebServer) postECUTestInjectors(w http.ResponseWriter, r *http.
 	log.Infof("rest-post test injectors")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorInjectors, data.Activate)
 	}
-
-	if err := webserver.reader.ECU.TestInjectors(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorInjectors, Activate: data.Activate}
-
-	webserver.updateTestActuator(w, r, actuatorResponse)
 }
 
 //
@@ -746,67 +679,73 @@ func (webserver *WebServer) postECUTestCoil(w http.ResponseWriter, r *http.Reque
 	log.Infof("rest-post test coil")
 
 	// get the body of our POST request
-	// unmarshal this into a new Config struct
-	body, _ := ioutil.ReadAll(r.Body)
-
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if err := webserver.reader.ECU.TestCoil(data.Activate); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// unmarshal this into the activation
+	if webserver.decodeRequest(w, r, &data) {
+		webserver.updateTestActuator(w, r, ActuatorCoil, data.Activate)
 	}
+}
 
-	actuatorResponse := ECUActivateResponse{Actuator: ActuatorCoil, Activate: data.Activate}
+//
+// Actuator States
+// returns each actuator with its on/off state, when it was switched and the last error
+//
+func (webserver *WebServer) getECUActuatorStates(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get actuator states")
 
-	webserver.updateTestActuator(w, r, actuatorResponse)
+	webserver.sendResponse(w, r, webserver.reader.Actuators.States())
 }
 
 //
-// update the actuator status
+// switch off all the actuators
+// responds with the state of each actuator
 //
-func (webserver *WebServer) updateTestActuator(w http.ResponseWriter, r *http.Request, actuatorResponse ECUActivateResponse) {
-	if webserver.isECUConnected(w) {
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				log.Warnf("rest error closing response body")
-			}
-		}(r.Body)
+func (webserver *WebServer) postECUTestAllOff(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-post switch off all actuators")
 
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
 
-		if err := json.NewEncoder(w).Encode(actuatorResponse); err != nil {
-			log.Warnf("rest-call response failed")
-			// return a error code
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	if err := webserver.reader.Actuators.AllOff(getClientAddress(r)); err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
+
+	webserver.sendResponse(w, r, webserver.reader.Actuators.States())
 }
 
 //
-// checks if the ECU is connected and sets the headers accordingly
-// create the response for methods that require the ecu to be connected.
+// switch the actuator and respond with the actuator status
+// actuators switched on are switched off by the server after their maximum on time
 //
-func (webserver *WebServer) isECUConnected(w http.ResponseWriter) bool {
-	if webserver.reader.ECU.Status.Connected {
-		// return a 200 status code
-		w.WriteHeader(http.StatusOK)
-	} else {
-		log.Infof("rest-call ecu is not connected")
-		// return service unavailable if unable to connect
-		w.WriteHeader(http.StatusServiceUnavailable)
-		// put the ecu status in the body
-		status := webserver.reader.ECU.Status
-
-		if err := json.NewEncoder(w).Encode(status); err != nil {
-			log.Warnf("rest-call response failed")
-			// return a error code
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+func (webserver *WebServer) updateTestActuator(w http.ResponseWriter, r *http.Request, actuator string, on bool) {
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	if err := webserver.reader.Actuators.Activate(getClientAddress(r), actuator, on); err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
 
-	return webserver.reader.ECU.Status.Connected
+	state, _ := webserver.reader.Actuators.State(actuator)
+	webserver.sendResponse(w, r, ECUActivateResponse{Actuator: actuator, Activate: state.Active})
+}
+
+//
+// Queue Status
+// returns the depth of the ecu command queue and the command latency
+//
+func (webserver *WebServer) getECUQueueStatus(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get read ecu queue status")
+
+	status := webserver.reader.Queue.Status()
+	webserver.sendResponse(w, r, status)
+}
+
+//
+// queues the command for the ECU and waits for it to complete
+//
+func (webserver *WebServer) sendECUCommand(name string, priority int, command func() error) error {
+	return webserver.reader.Queue.Submit(name, priority, defaultCommandTimeout, command)
 }
@@ -3,13 +3,13 @@ package fcr
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/andrewdjackson/rosco"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"reflect"
 	"strings"
 	"time"
 )
@@ -30,6 +30,42 @@ type ScenarioDetails struct {
 type ScenarioSeekPosition struct {
 	CurrentPosition int
 	NewPosition     int
+	// Bookmark seeks to the named bookmark instead of the new position
+	Bookmark string
+}
+
+// PlaybackStep is the number of dataframes to step, negative steps back
+type PlaybackStep struct {
+	Frames int `json:"frames"`
+}
+
+// PlaybackSpeed is the playback speed, 1 replays at the recorded speed
+type PlaybackSpeed struct {
+	Speed float64 `json:"speed"`
+}
+
+// PlaybackMode is how the time elapsed during playback is measured, poll or realtime
+type PlaybackMode struct {
+	Mode string `json:"mode"`
+}
+
+// ScenarioTrimRange is the range of positions to keep, the name of the new scenario is optional
+type ScenarioTrimRange struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Name string `json:"name"`
+}
+
+// ScenarioSplitPosition splits the scenario at the named bookmark or the position
+type ScenarioSplitPosition struct {
+	Position int    `json:"position"`
+	Bookmark string `json:"bookmark"`
+}
+
+// ScenarioMergeList are the scenarios to merge, the name of the new scenario is optional
+type ScenarioMergeList struct {
+	Scenarios []string `json:"scenarios"`
+	Name      string   `json:"name"`
 }
 
 type ScenarioConversion struct {
@@ -46,28 +82,17 @@ func (webserver *WebServer) getScenarioDetails(w http.ResponseWriter, r *http.Re
 	vars := mux.Vars(r)
 	scenarioID := vars["scenarioId"]
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Warnf("rest error closing response body")
-		}
-	}(r.Body)
-
 	data := rosco.GetScenario(scenarioID)
 
 	log.Infof("%+v", data)
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-
 	if data.Count == 0 {
 		// return 404 not found
-		w.WriteHeader(http.StatusNotFound)
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("scenario %s not found or empty", scenarioID))
+		return
 	}
 
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	webserver.sendResponse(w, r, data)
 }
 
 func (webserver *WebServer) getScenarioContents(w http.ResponseWriter, r *http.Request) {
@@ -83,21 +108,22 @@ func (webserver *WebServer) getScenarioContents(w http.ResponseWriter, r *http.R
 		}
 	}(r.Body)
 
-	if data, err := rosco.GetScenarioContents(scenarioID); err == nil {
-		contents := string(data)
+	data, err := rosco.GetScenarioContents(scenarioID)
+	if err != nil {
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("unable to get scenario contents %s (%s)", scenarioID, err))
+		return
+	}
 
-		if strings.HasSuffix(scenarioID, "csv") {
-			w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
-		} else {
-			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		}
+	contents := string(data)
 
-		if err := json.NewEncoder(w).Encode(contents); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	if strings.HasSuffix(scenarioID, "csv") {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
 	} else {
-		log.Warnf("unable to get scenario contents (%+v)", err)
-		w.WriteHeader(http.StatusNoContent)
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	}
+
+	if err := json.NewEncoder(w).Encode(contents); err != nil {
+		log.Warnf("rest response failed (%s)", err)
 	}
 }
 
@@ -115,45 +141,47 @@ func (webserver *WebServer) getPlaybackProgress(w http.ResponseWriter, r *http.R
 	log.Info("rest-get scenario playback details")
 
 	if !webserver.isECUScenarioReader() {
-		log.Info("rest-get ecu reader is not a scenario playback reader")
-		w.WriteHeader(http.StatusServiceUnavailable)
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeNotScenario, "ecu reader is not a scenario playback reader")
 		return
 	}
 
 	vars := mux.Vars(r)
 	if len(vars) > 0 {
 		scenarioID := vars["scenarioId"]
-		log.Info("rest-get scenario playback id %s", scenarioID)
+		log.Infof("rest-get scenario playback id %s", scenarioID)
 	}
 
 	details := ScenarioDetails{}
 
-	d, err := webserver.reader.ECU.Responder.GetFirst()
-	details.First = ScenarioDetail{}
-	if err == nil {
-		details.First.Timestamp = d.Timestamp
-		details.First.Position = d.Position
-		details.First.Dataframe80 = hex.EncodeToString(d.Dataframe80)
-		details.First.Dataframe7d = hex.EncodeToString(d.Dataframe7d)
-	}
+	// read the playback position once any command in progress has completed
+	webserver.reader.Queue.Inspect(func() {
+		d, err := webserver.reader.ECU.Responder.GetFirst()
+		details.First = ScenarioDetail{}
+		if err == nil {
+			details.First.Timestamp = d.Timestamp
+			details.First.Position = d.Position
+			details.First.Dataframe80 = hex.EncodeToString(d.Dataframe80)
+			details.First.Dataframe7d = hex.EncodeToString(d.Dataframe7d)
+		}
 
-	d, err = webserver.reader.ECU.Responder.GetCurrent()
-	details.Current = ScenarioDetail{}
-	if err == nil {
-		details.Current.Timestamp = d.Timestamp
-		details.Current.Position = d.Position
-		details.Current.Dataframe80 = hex.EncodeToString(d.Dataframe80)
-		details.Current.Dataframe7d = hex.EncodeToString(d.Dataframe7d)
-	}
+		d, err = webserver.reader.ECU.Responder.GetCurrent()
+		details.Current = ScenarioDetail{}
+		if err == nil {
+			details.Current.Timestamp = d.Timestamp
+			details.Current.Position = d.Position
+			details.Current.Dataframe80 = hex.EncodeToString(d.Dataframe80)
+			details.Current.Dataframe7d = hex.EncodeToString(d.Dataframe7d)
+		}
 
-	d, err = webserver.reader.ECU.Responder.GetLast()
-	details.Last = ScenarioDetail{}
-	if err == nil {
-		details.Last.Timestamp = d.Timestamp
-		details.Last.Position = d.Position
-		details.Last.Dataframe80 = hex.EncodeToString(d.Dataframe80)
-		details.Last.Dataframe7d = hex.EncodeToString(d.Dataframe7d)
-	}
+		d, err = webserver.reader.ECU.Responder.GetLast()
+		details.Last = ScenarioDetail{}
+		if err == nil {
+			details.Last.Timestamp = d.Timestamp
+			details.Last.Position = d.Position
+			details.Last.Dataframe80 = hex.EncodeToString(d.Dataframe80)
+			details.Last.Dataframe7d = hex.EncodeToString(d.Dataframe7d)
+		}
+	})
 
 	log.Infof("%+v", details)
 	webserver.sendResponse(w, r, details)
@@ -162,82 +190,427 @@ func (webserver *WebServer) getPlaybackProgress(w http.ResponseWriter, r *http.R
 func (webserver *WebServer) putConvertToScenario(w http.ResponseWriter, r *http.Request) {
 	// get the body of our request
 	// unmarshal this into a new struct
-	reqBody, _ := ioutil.ReadAll(r.Body)
 	conversion := ScenarioConversion{}
-	_ = json.Unmarshal(reqBody, &conversion)
-
-	scenarioId := conversion.Source
-
-	if strings.HasSuffix(strings.ToLower(scenarioId), ".csv") {
-		log.Infof("rest-put converting logfile %s to scenario", scenarioId)
-		scenarioFile := strings.Replace(scenarioId, ".csv", ".fcr", 1)
-		s := rosco.NewScenarioFile(scenarioFile)
-		if err := s.ConvertLogToScenario(scenarioId); err == nil {
-			if err := s.Write(); err != nil {
-				log.Errorf("rest-put error writing scenario file %s (%s)", scenarioFile, err)
-				w.WriteHeader(http.StatusBadRequest)
-			} else {
-				conversion.Result = true
-				conversion.Destination = scenarioFile
-
-				webserver.sendResponse(w, r, conversion)
-			}
-		} else {
-			log.Errorf("rest-put error converting scenario file %s (%s)", scenarioFile, err)
-			w.WriteHeader(http.StatusBadRequest)
-		}
+	if !webserver.decodeRequest(w, r, &conversion) {
+		return
+	}
+
+	log.Infof("rest-put converting logfile %s to scenario", conversion.Source)
+
+	if conversion, err := ConvertLogToScenario(conversion.Source); err == nil {
+		webserver.sendResponse(w, r, conversion)
 	} else {
-		log.Warnf("rest-put cannot convert file %s is already a scenario", scenarioId)
-		w.WriteHeader(http.StatusBadRequest)
+		webserver.sendError(w, r, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
 	}
 }
 
 func (webserver *WebServer) postPlaybackSeek(w http.ResponseWriter, r *http.Request) {
 	// get the body of our request
-	// unmarshal this into a new Config struct
-	reqBody, _ := ioutil.ReadAll(r.Body)
-
-	// get the current configuration
+	// unmarshal this into the seek position
 	position := ScenarioSeekPosition{}
-	_ = json.Unmarshal(reqBody, &position)
+	if !webserver.decodeRequest(w, r, &position) {
+		return
+	}
 
 	log.Infof("rest-post scenario playback seek (%+v)", position)
 
-	if webserver.reader.ECU.Status.Connected && webserver.isECUScenarioReader() {
-		last := webserver.reader.ECU.Responder.Playbook.Count
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
 
-		if position.NewPosition < last {
-			webserver.reader.ECU.Responder.MoveToPosition(position.NewPosition)
-			detail, _ := webserver.reader.ECU.Responder.GetCurrent()
-			log.Infof("rest-post scenario position moved from %v to %v", position.CurrentPosition, detail.Position)
+	if !webserver.isECUScenarioReader() {
+		// service unavailable if we're not replaying
+		webserver.sendError(w, r, http.StatusServiceUnavailable, ErrorCodeNotScenario, "ecu reader is not a scenario playback reader")
+		return
+	}
+
+	var detail rosco.PlaybookResponse
 
-			webserver.sendResponse(w, r, detail)
-		} else {
-			log.Infof("rest-post scenario position too far (%v > %v)", position.NewPosition, last)
-			// position not found
-			w.WriteHeader(http.StatusNotFound)
+	if position.Bookmark != "" {
+		bookmark, err := GetBookmark(webserver.reader.Playback.Scenario(), position.Bookmark)
+		if err != nil {
+			webserver.sendScenarioError(w, r, err)
+			return
 		}
-	} else {
-		// service unavailable if we're not replaying
-		w.WriteHeader(http.StatusServiceUnavailable)
+
+		position.NewPosition = bookmark.Position
+	}
+
+	last := webserver.reader.ECU.Responder.Playbook.Count
+
+	if position.NewPosition < 0 || position.NewPosition >= last {
+		// position not found
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("scenario position %d is outside the scenario (0 to %d)", position.NewPosition, last-1))
+		return
+	}
+
+	// move the position between dataframe reads, playback continues from the new position
+	err := webserver.sendECUCommand("seek", PriorityNormal, func() error {
+		_, err := webserver.reader.Playback.Control(webserver.reader.ECU, webserver.reader.Config.getFrequency(), func(playback *Playback) error {
+			return playback.seek(position.NewPosition)
+		})
+
+		if err == nil {
+			detail, err = webserver.reader.ECU.Responder.GetCurrent()
+		}
+
+		return err
+	})
+
+	if err != nil {
+		webserver.sendECUError(w, r, err)
+		return
 	}
+
+	log.Infof("rest-post scenario position moved from %v to %v", position.CurrentPosition, detail.Position)
+	webserver.sendResponse(w, r, detail)
 }
 
-func (webserver *WebServer) sendResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+// getScenarioSearch returns the positions in the scenario matching the query parameter,
+// e.g. ?query=rpm < 500 while closedloop
+func (webserver *WebServer) getScenarioSearch(w http.ResponseWriter, r *http.Request) {
+	scenarioID := mux.Vars(r)["scenarioId"]
+	query := r.URL.Query().Get("query")
+
+	log.Infof("rest-get scenario search %s (%s)", scenarioID, query)
+
+	result, err := webserver.reader.SearchScenario(scenarioID, query)
+	if err != nil {
+		webserver.sendScenarioError(w, r, err)
+		return
+	}
+
+	webserver.sendResponse(w, r, result)
+}
+
+// getScenarioBookmarks returns the bookmarks saved for the scenario
+func (webserver *WebServer) getScenarioBookmarks(w http.ResponseWriter, r *http.Request) {
+	scenarioID := mux.Vars(r)["scenarioId"]
+	log.Infof("rest-get scenario bookmarks %s", scenarioID)
+
+	bookmarks, err := ReadBookmarks(scenarioID)
+	if err != nil {
+		webserver.sendScenarioError(w, r, err)
+		return
+	}
+
+	webserver.sendResponse(w, r, bookmarks)
+}
+
+// postScenarioBookmark saves a named bookmark, replacing any bookmark with the same name
+func (webserver *WebServer) postScenarioBookmark(w http.ResponseWriter, r *http.Request) {
+	scenarioID := mux.Vars(r)["scenarioId"]
+
+	bookmark := ScenarioBookmark{}
+	if !webserver.decodeRequest(w, r, &bookmark) {
+		return
+	}
+
+	log.Infof("rest-post scenario bookmark %s (%+v)", scenarioID, bookmark)
+
+	bookmark.Name = strings.TrimSpace(bookmark.Name)
+	if bookmark.Name == "" {
+		webserver.sendValidationError(w, r, map[string]string{"name": "the bookmark must have a name"})
+		return
+	}
+
+	samples, err := webserver.reader.DecodeScenario(scenarioID)
+	if err == nil {
+		bookmark, err = SaveBookmark(scenarioID, bookmark, samples)
+	}
+
+	if err != nil {
+		webserver.sendScenarioError(w, r, err)
+		return
+	}
+
+	webserver.sendStatusResponse(w, r, http.StatusCreated, bookmark)
+}
+
+// deleteScenarioBookmark removes the named bookmark
+func (webserver *WebServer) deleteScenarioBookmark(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.Infof("rest-delete scenario bookmark %s (%s)", vars["scenarioId"], vars["name"])
+
+	if err := DeleteBookmark(vars["scenarioId"], vars["name"]); err != nil {
+		webserver.sendScenarioError(w, r, err)
+		return
+	}
+
+	bookmarks, _ := ReadBookmarks(vars["scenarioId"])
+	webserver.sendResponse(w, r, bookmarks)
+}
+
+// getScenarioExport streams the scenario as a download, the format parameter is one of fcr,
+// csv, jsonl or columnar. The scenario file is downloaded as it was saved if the format isn't given
+func (webserver *WebServer) getScenarioExport(w http.ResponseWriter, r *http.Request) {
+	scenarioID := mux.Vars(r)["scenarioId"]
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = ExportFCR
+	}
+
+	log.Infof("rest-get scenario export %s (%s)", scenarioID, format)
+
+	if !isExportFormat(format) {
+		webserver.sendValidationError(w, r, map[string]string{"format": fmt.Sprintf("expected one of %s", strings.Join(ExportFormats, ", "))})
+		return
+	}
+
+	if _, err := getScenarioFile(scenarioID); err != nil {
+		webserver.sendScenarioError(w, r, err)
+		return
+	}
+
+	write := func(w io.Writer) error { return ExportScenario(scenarioID, w) }
+	contentType := "application/json; charset=UTF-8"
+
+	if export, ok := exportFormats[format]; ok {
+		samples, err := webserver.reader.DecodeScenario(scenarioID)
 		if err != nil {
-			log.Warnf("rest error closing response body")
+			webserver.sendScenarioError(w, r, err)
+			return
 		}
-	}(r.Body)
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		write = func(w io.Writer) error { return export.write(w, samples) }
+		contentType = export.contentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", getExportFilename(scenarioID, format)))
+
+	// the response has started, so errors can only be logged
+	if err := write(w); err != nil {
+		log.Warnf("rest-get scenario export %s failed (%s)", scenarioID, err)
+	}
+}
+
+// postScenarioTrim writes the range of positions to a new scenario
+func (webserver *WebServer) postScenarioTrim(w http.ResponseWriter, r *http.Request) {
+	scenarioID := mux.Vars(r)["scenarioId"]
+
+	trim := ScenarioTrimRange{}
+	if !webserver.decodeRequest(w, r, &trim) {
+		return
+	}
+
+	log.Infof("rest-post scenario trim %s (%+v)", scenarioID, trim)
 
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		// return a error code
-		w.WriteHeader(http.StatusInternalServerError)
+	if !webserver.isValidScenarioName(w, r, trim.Name) {
+		return
 	}
+
+	edit, err := TrimScenario(scenarioID, trim.From, trim.To, trim.Name)
+	webserver.sendScenarioEdit(w, r, edit, err)
+}
+
+// postScenarioSplit splits the scenario in two at a bookmark or position
+func (webserver *WebServer) postScenarioSplit(w http.ResponseWriter, r *http.Request) {
+	var edit ScenarioEdit
+	var err error
+
+	scenarioID := mux.Vars(r)["scenarioId"]
+
+	split := ScenarioSplitPosition{}
+	if !webserver.decodeRequest(w, r, &split) {
+		return
+	}
+
+	log.Infof("rest-post scenario split %s (%+v)", scenarioID, split)
+
+	if split.Bookmark != "" {
+		edit, err = SplitScenarioAtBookmark(scenarioID, split.Bookmark)
+	} else {
+		edit, err = SplitScenario(scenarioID, split.Position)
+	}
+
+	webserver.sendScenarioEdit(w, r, edit, err)
+}
+
+// postScenarioMerge concatenates scenarios recorded from the same ecu
+func (webserver *WebServer) postScenarioMerge(w http.ResponseWriter, r *http.Request) {
+	merge := ScenarioMergeList{}
+	if !webserver.decodeRequest(w, r, &merge) {
+		return
+	}
+
+	log.Infof("rest-post scenario merge (%+v)", merge)
+
+	if !webserver.isValidScenarioName(w, r, merge.Name) {
+		return
+	}
+
+	for _, id := range merge.Scenarios {
+		if _, err := getScenarioPath(id); err != nil {
+			webserver.sendValidationError(w, r, map[string]string{"scenarios": fmt.Sprintf("%s is not a scenario in the log folder", id)})
+			return
+		}
+	}
+
+	edit, err := MergeScenarios(merge.Scenarios, merge.Name)
+	webserver.sendScenarioEdit(w, r, edit, err)
+}
+
+// isValidScenarioName new scenarios are created in the log folder, so the name can't include a path
+func (webserver *WebServer) isValidScenarioName(w http.ResponseWriter, r *http.Request, name string) bool {
+	if _, err := getScenarioPath(name); name != "" && err != nil {
+		webserver.sendValidationError(w, r, map[string]string{"name": "the scenario name cannot include a folder"})
+		return false
+	}
+
+	return true
+}
+
+// sendScenarioEdit returns the new scenarios and pushes the updated list of scenarios to the stream subscribers
+func (webserver *WebServer) sendScenarioEdit(w http.ResponseWriter, r *http.Request, edit ScenarioEdit, err error) {
+	// a split may have written the first part before failing
+	if len(edit.Scenarios) > 0 {
+		scenarios, _ := rosco.GetScenarios("")
+		webserver.stream.broadcast(StreamMessage{Type: StreamScenarios, Data: scenarios})
+	}
+
+	if err != nil {
+		webserver.sendScenarioError(w, r, err)
+		return
+	}
+
+	webserver.sendStatusResponse(w, r, http.StatusCreated, edit)
+}
+
+// sendScenarioError writes the error response for a failed scenario search or bookmark
+func (webserver *WebServer) sendScenarioError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrScenarioNotFound), errors.Is(err, ErrBookmarkNotFound):
+		webserver.sendError(w, r, http.StatusNotFound, ErrorCodeNotFound, err.Error())
+	case errors.Is(err, ErrInvalidQuery), errors.Is(err, ErrPlaybackPosition), errors.Is(err, ErrScenarioRange), errors.Is(err, ErrScenarioPath), errors.Is(err, ErrScenarioDataframes):
+		webserver.sendError(w, r, http.StatusBadRequest, ErrorCodeInvalid, err.Error())
+	case errors.Is(err, ErrScenarioExists):
+		webserver.sendError(w, r, http.StatusConflict, ErrorCodeScenarioExists, err.Error())
+	case errors.Is(err, ErrScenarioECU):
+		webserver.sendError(w, r, http.StatusConflict, ErrorCodeECUMismatch, err.Error())
+	default:
+		webserver.sendError(w, r, http.StatusInternalServerError, ErrorCodeInternal, err.Error())
+	}
+}
+
+// getPlayback returns the state of the scenario playback
+func (webserver *WebServer) getPlayback(w http.ResponseWriter, r *http.Request) {
+	log.Info("rest-get scenario playback")
+	webserver.controlPlayback(w, r, "state", func(playback *Playback) error { return nil })
+}
+
+// postPlaybackPlay plays the scenario from the current position
+func (webserver *WebServer) postPlaybackPlay(w http.ResponseWriter, r *http.Request) {
+	log.Info("rest-post scenario playback play")
+	webserver.controlPlayback(w, r, "play", (*Playback).play)
+}
+
+// postPlaybackPause holds the scenario at the current position
+func (webserver *WebServer) postPlaybackPause(w http.ResponseWriter, r *http.Request) {
+	log.Info("rest-post scenario playback pause")
+	webserver.controlPlayback(w, r, "pause", (*Playback).pause)
+}
+
+// postPlaybackStep pauses the playback and steps forward or back by the number of frames,
+// without a body the playback steps forward one frame
+func (webserver *WebServer) postPlaybackStep(w http.ResponseWriter, r *http.Request) {
+	step := PlaybackStep{Frames: 1}
+	if r.ContentLength != 0 && !webserver.decodeRequest(w, r, &step) {
+		return
+	}
+
+	log.Infof("rest-post scenario playback step (%+v)", step)
+	webserver.controlPlayback(w, r, "step", func(playback *Playback) error {
+		return playback.step(step.Frames)
+	})
+}
+
+// postPlaybackSpeed sets the playback speed
+func (webserver *WebServer) postPlaybackSpeed(w http.ResponseWriter, r *http.Request) {
+	speed := PlaybackSpeed{}
+	if !webserver.decodeRequest(w, r, &speed) {
+		return
+	}
+
+	log.Infof("rest-post scenario playback speed (%+v)", speed)
+
+	if speed.Speed < minPlaybackSpeed || speed.Speed > maxPlaybackSpeed {
+		webserver.sendValidationError(w, r, map[string]string{"speed": ErrPlaybackSpeed.Error()})
+		return
+	}
+
+	webserver.controlPlayback(w, r, "speed", func(playback *Playback) error {
+		return playback.setSpeed(speed.Speed)
+	})
+}
+
+// postPlaybackMode sets the playback mode, realtime plays the dataframe recorded
+// at the time elapsed since play started, so the replay can be lined up with a recording of the drive
+func (webserver *WebServer) postPlaybackMode(w http.ResponseWriter, r *http.Request) {
+	mode := PlaybackMode{}
+	if !webserver.decodeRequest(w, r, &mode) {
+		return
+	}
+
+	log.Infof("rest-post scenario playback mode (%+v)", mode)
+
+	if mode.Mode != PlaybackModePoll && mode.Mode != PlaybackModeRealtime {
+		webserver.sendValidationError(w, r, map[string]string{"mode": ErrPlaybackMode.Error()})
+		return
+	}
+
+	webserver.controlPlayback(w, r, "mode", func(playback *Playback) error {
+		return playback.setMode(mode.Mode)
+	})
+}
+
+// postPlaybackLoop repeats the playback between the two positions
+func (webserver *WebServer) postPlaybackLoop(w http.ResponseWriter, r *http.Request) {
+	loop := PlaybackLoop{}
+	if !webserver.decodeRequest(w, r, &loop) {
+		return
+	}
+
+	log.Infof("rest-post scenario playback loop (%+v)", loop)
+	webserver.controlPlayback(w, r, "loop", func(playback *Playback) error {
+		return playback.setLoop(&loop)
+	})
+}
+
+// deletePlaybackLoop plays the whole scenario
+func (webserver *WebServer) deletePlaybackLoop(w http.ResponseWriter, r *http.Request) {
+	log.Info("rest-delete scenario playback loop")
+	webserver.controlPlayback(w, r, "loop", func(playback *Playback) error {
+		return playback.setLoop(nil)
+	})
+}
+
+// controlPlayback changes the playback between dataframe reads and returns the playback state
+func (webserver *WebServer) controlPlayback(w http.ResponseWriter, r *http.Request, name string, change func(playback *Playback) error) {
+	var state PlaybackState
+
+	if !webserver.isECUConnected(w, r) {
+		return
+	}
+
+	err := webserver.sendECUCommand("playback "+name, PriorityNormal, func() error {
+		var err error
+		state, err = webserver.reader.Playback.Control(webserver.reader.ECU, webserver.reader.Config.getFrequency(), change)
+		return err
+	})
+
+	if err != nil {
+		webserver.sendECUError(w, r, err)
+		return
+	}
+
+	webserver.sendResponse(w, r, state)
+}
+
+func (webserver *WebServer) sendResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
+	webserver.sendStatusResponse(w, r, http.StatusOK, data)
 }
 func (webserver *WebServer) isECUScenarioReader() bool {
-	return reflect.TypeOf(webserver.reader.ECU.EcuReader) == reflect.TypeOf(&rosco.ScenarioReader{})
+	return isScenarioReader(webserver.reader.ECU)
 }
@@ -0,0 +1,181 @@
+package fcr
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenCookie holds the api token for the browser interface once the token has been
+// given in the url, the cookie is never sent with requests from other sites
+const tokenCookie = "memsfcr-token"
+
+// tokenParameter is the url query parameter used to give the api token to the browser interface
+const tokenParameter = "token"
+
+// originMiddleware rejects requests from web pages served by other origins unless
+// the origin is in the allowed list, this stops a stray web page triggering the actuators
+func (webserver *WebServer) originMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if !webserver.isAllowedOrigin(r) {
+			webserver.sendError(w, r, http.StatusForbidden, ErrorCodeForbidden, fmt.Sprintf("requests from %s are not allowed", origin))
+			return
+		}
+
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenMiddleware requires the api token if one is configured, the token is accepted as
+// a bearer token in the Authorization header, in the token cookie or as the token url parameter
+func (webserver *WebServer) tokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := webserver.reader.Config.getToken()
+
+		// preflight requests never include credentials
+		if token == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isMatchingToken(getBearerToken(r), token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cookie, err := r.Cookie(tokenCookie); err == nil && isMatchingToken(cookie.Value, token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isMatchingToken(r.URL.Query().Get(tokenParameter), token) {
+			// remember the token so the browser interface can call the api
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Warnf("rest request from %s without a valid token", r.RemoteAddr)
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="memsfcr"`)
+		webserver.sendError(w, r, http.StatusUnauthorized, ErrorCodeUnauthorized, "a valid api token is required")
+	})
+}
+
+// preflightHandler answers the CORS preflight requests from the allowed origins
+func (webserver *WebServer) preflightHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAllowedOrigin returns true if the request is not from a web page, is from a page served
+// by this server or is from one of the configured origins
+func (webserver *WebServer) isAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		return true
+	}
+
+	// a page on another site can be re-pointed at this computer by its dns, the page then has
+	// the same origin as the request host so the host must also be one of this server's names
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) && webserver.isServerHost(r.Host) {
+		return true
+	}
+
+	for _, allowed := range webserver.reader.Config.getOrigins() {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isServerHost returns true if the request host is this computer, the listen address
+// or the host of one of the configured origins
+func (webserver *WebServer) isServerHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	host = strings.Trim(host, "[]")
+
+	if isLoopback(host) || strings.EqualFold(host, webserver.reader.Config.getListen()) {
+		return true
+	}
+
+	names, addresses := getLocalNames()
+
+	for _, name := range names {
+		if strings.EqualFold(name, host) {
+			return true
+		}
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, address := range addresses {
+			if address.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	for _, allowed := range webserver.reader.Config.getOrigins() {
+		if u, err := url.Parse(allowed); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isValidOrigin returns true if the origin is a scheme and host or the * wildcard
+func isValidOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && strings.Trim(u.Path, "/") == "" && u.RawQuery == ""
+}
+
+func getBearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+
+	return ""
+}
+
+// isMatchingToken compares the tokens in constant time
+func isMatchingToken(presented string, token string) bool {
+	return presented != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
@@ -0,0 +1,120 @@
+package fcr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func sendTestRequestWithHeaders(webserver *WebServer, method string, url string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, nil)
+
+	for name, value := range headers {
+		if name == "Host" {
+			r.Host = value
+			continue
+		}
+
+		r.Header.Set(name, value)
+	}
+
+	webserver.newRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func TestTokenRequired(t *testing.T) {
+	webserver := newTestWebServer(t)
+	webserver.reader.Config.Token = "secret"
+
+	w := sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", nil)
+	expectError(t, w, http.StatusUnauthorized, ErrorCodeUnauthorized)
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", map[string]string{"Authorization": "Bearer wrong"})
+	expectError(t, w, http.StatusUnauthorized, ErrorCodeUnauthorized)
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", map[string]string{"Authorization": "Bearer secret"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with the bearer token, got %d", w.Code)
+	}
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco?token=secret", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with the token parameter, got %d", w.Code)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != tokenCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected the token cookie to be set, got %v", cookies)
+	}
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", map[string]string{"Cookie": tokenCookie + "=secret"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 with the token cookie, got %d", w.Code)
+	}
+}
+
+func TestCrossOriginRequestRefused(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	w := sendTestRequestWithHeaders(webserver, http.MethodPost, "/rosco/test/fuelpump", map[string]string{"Origin": "http://stray.test"})
+	expectError(t, w, http.StatusForbidden, ErrorCodeForbidden)
+
+	// pages served by the web server are allowed
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", map[string]string{"Origin": "http://localhost:8081", "Host": "localhost:8081"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 for the same origin, got %d", w.Code)
+	}
+}
+
+func TestRebindingOriginRefused(t *testing.T) {
+	webserver := newTestWebServer(t)
+
+	// a page on another site with its dns re-pointed at this computer has the same origin as the host
+	w := sendTestRequestWithHeaders(webserver, http.MethodPost, "/rosco/test/fuelpump", map[string]string{"Origin": "http://evil.test", "Host": "evil.test"})
+	expectError(t, w, http.StatusForbidden, ErrorCodeForbidden)
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", map[string]string{"Origin": "http://evil.test:8081", "Host": "evil.test:8081"})
+	expectError(t, w, http.StatusForbidden, ErrorCodeForbidden)
+
+	// the listen address is one of the server's names
+	webserver.reader.Config.Listen = "memsfcr.lan"
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodGet, "/rosco", map[string]string{"Origin": "http://memsfcr.lan:8081", "Host": "memsfcr.lan:8081"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 for the listen address, got %d", w.Code)
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	webserver := newTestWebServer(t)
+	webserver.reader.Config.Origins = []string{"http://dashboard.local:8080"}
+
+	w := sendTestRequestWithHeaders(webserver, http.MethodOptions, "/rosco/test/fuelpump", map[string]string{"Origin": "http://dashboard.local:8080"})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204 for the preflight request, got %d", w.Code)
+	}
+
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "http://dashboard.local:8080" {
+		t.Errorf("expected the origin to be allowed, got %s", origin)
+	}
+
+	w = sendTestRequestWithHeaders(webserver, http.MethodOptions, "/rosco/test/fuelpump", map[string]string{"Origin": "http://stray.test"})
+	expectError(t, w, http.StatusForbidden, ErrorCodeForbidden)
+}
+
+func TestIsValidOrigin(t *testing.T) {
+	for origin, valid := range map[string]bool{
+		"*":                      true,
+		"http://localhost:8081":  true,
+		"https://example.com/":   true,
+		"example.com":            false,
+		"ftp://example.com":      false,
+		"http://example.com/api": false,
+	} {
+		if isValidOrigin(origin) != valid {
+			t.Errorf("expected isValidOrigin(%s) to be %t", origin, valid)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package fcr
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// websocket stream message types
+const (
+	StreamDataframe = "dataframe"
+	StreamFaults    = "faults"
+	StreamStatus    = "status"
+	StreamProcedure = "procedure"
+	StreamScenarios = "scenarios"
+)
+
+// number of messages buffered per client before messages are dropped
+const streamClientBufferSize = 16
+
+// StreamMessage is pushed to each of the websocket subscribers
+type StreamMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// DataframeStream pushes the results of each acquisition poll
+// to every subscribed websocket client
+type DataframeStream struct {
+	mutex   sync.Mutex
+	clients map[*websocket.Conn]chan StreamMessage
+	status  rosco.ECUStatus
+	// faults are the faults set in the last dataframe
+	faults []FaultDefinition
+}
+
+// NewDataframeStream creates a stream fed by the acquisition
+func NewDataframeStream(acquisition *Acquisition) *DataframeStream {
+	stream := &DataframeStream{}
+	stream.clients = make(map[*websocket.Conn]chan StreamMessage)
+	stream.faults = []FaultDefinition{}
+
+	acquisition.AddListener(stream.update)
+
+	return stream
+}
+
+// Stream the ECU dataframes, fault changes and status changes over a websocket
+func (webserver *WebServer) streamHandler(w http.ResponseWriter, r *http.Request) {
+	log.Infof("rest-get stream upgrade request")
+
+	ws, err := webserver.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied with an error code
+		log.Warnf("stream unable to upgrade connection (%s)", err)
+		return
+	}
+
+	webserver.stream.subscribe(ws)
+}
+
+// subscribe adds the client to the stream
+func (stream *DataframeStream) subscribe(ws *websocket.Conn) {
+	messages := make(chan StreamMessage, streamClientBufferSize)
+
+	stream.mutex.Lock()
+	stream.clients[ws] = messages
+	clients := len(stream.clients)
+
+	// send the current status so the client doesn't need to wait for a change
+	messages <- StreamMessage{Type: StreamStatus, Data: stream.status}
+	stream.mutex.Unlock()
+
+	log.Infof("stream client %s subscribed (%d clients)", ws.RemoteAddr(), clients)
+
+	go stream.writeMessages(ws, messages)
+	go stream.readMessages(ws)
+}
+
+// unsubscribe removes the client from the stream
+func (stream *DataframeStream) unsubscribe(ws *websocket.Conn) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if messages, ok := stream.clients[ws]; ok {
+		delete(stream.clients, ws)
+		close(messages)
+		_ = ws.Close()
+
+		log.Infof("stream client %s unsubscribed (%d clients)", ws.RemoteAddr(), len(stream.clients))
+	}
+}
+
+// Close disconnects all the clients
+func (stream *DataframeStream) Close() {
+	stream.mutex.Lock()
+	clients := make([]*websocket.Conn, 0, len(stream.clients))
+	for ws := range stream.clients {
+		clients = append(clients, ws)
+	}
+	stream.mutex.Unlock()
+
+	for _, ws := range clients {
+		message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		_ = ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		stream.unsubscribe(ws)
+	}
+}
+
+// writeMessages is the only writer to the websocket connection
+func (stream *DataframeStream) writeMessages(ws *websocket.Conn, messages chan StreamMessage) {
+	for message := range messages {
+		if err := ws.WriteJSON(message); err != nil {
+			log.Warnf("stream unable to send to client %s (%s)", ws.RemoteAddr(), err)
+			stream.unsubscribe(ws)
+			// drain the channel until it's closed
+			for range messages {
+			}
+			return
+		}
+	}
+}
+
+// readMessages discards anything sent by the client and detects when the connection closes
+func (stream *DataframeStream) readMessages(ws *websocket.Conn) {
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			stream.unsubscribe(ws)
+			return
+		}
+	}
+}
+
+// broadcast sends the message to every subscriber,
+// slow clients have the message dropped rather than holding up the acquisition
+func (stream *DataframeStream) broadcast(message StreamMessage) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	for ws, messages := range stream.clients {
+		select {
+		case messages <- message:
+		default:
+			log.Warnf("stream client %s is not keeping up, dropped %s message", ws.RemoteAddr(), message.Type)
+		}
+	}
+}
+
+// update broadcasts any status changes, dataframes and fault changes
+// each time the acquisition polls the ECU
+func (stream *DataframeStream) update(status rosco.ECUStatus, sample *rosco.MemsData) {
+	stream.mutex.Lock()
+	statusChanged := status != stream.status
+	stream.status = status
+	stream.mutex.Unlock()
+
+	if statusChanged {
+		stream.broadcast(StreamMessage{Type: StreamStatus, Data: status})
+	}
+
+	if sample == nil {
+		return
+	}
+
+	stream.broadcast(StreamMessage{Type: StreamDataframe, Data: *sample})
+
+	faults := DecodeFaults(*sample)
+	if !reflect.DeepEqual(faults, stream.faults) {
+		stream.faults = faults
+		stream.broadcast(StreamMessage{Type: StreamFaults, Data: faults})
+	}
+}
@@ -0,0 +1,36 @@
+package fcr
+
+import (
+	"testing"
+
+	"github.com/andrewdjackson/rosco"
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamFaultChanges(t *testing.T) {
+	stream := NewDataframeStream(NewAcquisition(&MemsReader{}))
+
+	messages := make(chan StreamMessage, streamClientBufferSize)
+	stream.clients[&websocket.Conn{}] = messages
+
+	status := rosco.ECUStatus{Connected: true}
+	memsdata := rosco.MemsData{DTC0: rosco.CoolantSensorFaultCode}
+
+	// the faults are only sent when they change
+	stream.update(status, &memsdata)
+	stream.update(status, &memsdata)
+
+	memsdata.DTC0 = 0
+	stream.update(status, &memsdata)
+
+	var faults [][]FaultDefinition
+	for len(messages) > 0 {
+		if message := <-messages; message.Type == StreamFaults {
+			faults = append(faults, message.Data.([]FaultDefinition))
+		}
+	}
+
+	if len(faults) != 2 || len(faults[0]) != 1 || faults[0][0].Register != "DTC0" || len(faults[1]) != 0 {
+		t.Errorf("expected the coolant sensor fault to be set and then cleared, got %+v", faults)
+	}
+}
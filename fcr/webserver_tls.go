@@ -0,0 +1,256 @@
+package fcr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andrewdjackson/rosco"
+	log "github.com/sirupsen/logrus"
+)
+
+// the self-signed certificate files in the app folder
+const (
+	selfSignedCertFile = "memsfcr.crt"
+	selfSignedKeyFile  = "memsfcr.key"
+)
+
+// the self-signed certificate is replaced when it expires or when it is
+// within renewal time of expiring
+const (
+	selfSignedValidity = time.Hour * 24 * 365
+	selfSignedRenewal  = time.Hour * 24 * 7
+)
+
+// getTLSConfig loads the configured certificate and key, or the self-signed certificate
+// generating it if it doesn't exist, has expired or doesn't cover the hosts of this computer
+func getTLSConfig(config Config) (*tls.Config, error) {
+	certFile := config.TLSCert
+	keyFile := config.TLSKey
+
+	if certFile == "" {
+		certFile = filepath.Join(rosco.GetAppFolder(), selfSignedCertFile)
+		keyFile = filepath.Join(rosco.GetAppFolder(), selfSignedKeyFile)
+
+		if err := ensureSelfSignedCertificate(certFile, keyFile, config.Listen); err != nil {
+			return nil, err
+		}
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the tls certificate %s (%s)", certFile, err)
+	}
+
+	log.Infof("serving https using the certificate %s", certFile)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ensureSelfSignedCertificate generates a self-signed certificate for the host names
+// and addresses of this computer unless a current certificate already exists
+func ensureSelfSignedCertificate(certFile string, keyFile string, listen string) error {
+	names, addresses := getCertificateHosts(listen)
+
+	if isCurrentCertificate(certFile, keyFile, names, addresses) {
+		return nil
+	}
+
+	log.Infof("generating a self-signed certificate %s", certFile)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("unable to generate the certificate key (%s)", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("unable to generate the certificate serial number (%s)", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"MemsFCR"}, CommonName: "MemsFCR"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	template.DNSNames, template.IPAddresses = names, addresses
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("unable to generate the certificate (%s)", err)
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("unable to encode the certificate key (%s)", err)
+	}
+
+	// the key is only readable by the user
+	if err := writePEM(keyFile, "PRIVATE KEY", keyBytes, 0600); err != nil {
+		return err
+	}
+
+	return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+// isCurrentCertificate returns true if the certificate and key can be loaded, the certificate
+// is not about to expire and it is valid for all the host names and addresses
+func isCurrentCertificate(certFile string, keyFile string, names []string, addresses []net.IP) bool {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return false
+	}
+
+	if !time.Now().Add(selfSignedRenewal).Before(leaf.NotAfter) {
+		return false
+	}
+
+	// the addresses change when the computer moves network or the listen address is changed
+	for _, name := range names {
+		if leaf.VerifyHostname(name) != nil {
+			log.Infof("the self-signed certificate is not valid for %s", name)
+			return false
+		}
+	}
+
+	for _, address := range addresses {
+		if leaf.VerifyHostname(address.String()) != nil {
+			log.Infof("the self-signed certificate is not valid for %s", address)
+			return false
+		}
+	}
+
+	return true
+}
+
+// getCertificateHosts returns the host names and ip addresses of this computer
+// along with the listen address if it is a specific address
+func getCertificateHosts(listen string) ([]string, []net.IP) {
+	names, addresses := getLocalNames()
+
+	if ip := net.ParseIP(listen); ip != nil {
+		if !ip.IsUnspecified() && !containsIP(addresses, ip) {
+			addresses = append(addresses, ip)
+		}
+	} else if listen != "" && !containsString(names, listen) {
+		names = append(names, listen)
+	}
+
+	return names, addresses
+}
+
+func containsIP(addresses []net.IP, ip net.IP) bool {
+	for _, address := range addresses {
+		if address.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getLocalNames returns the host names and ip addresses the web interface can be reached on
+func getLocalNames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	addresses := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		names = append(names, hostname)
+
+		if !strings.Contains(hostname, ".") {
+			names = append(names, fmt.Sprintf("%s.local", hostname))
+		}
+	}
+
+	if interfaceAddresses, err := net.InterfaceAddrs(); err == nil {
+		for _, address := range interfaceAddresses {
+			if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+				addresses = append(addresses, ipnet.IP)
+			}
+		}
+	}
+
+	return names, addresses
+}
+
+func writePEM(filename string, blockType string, bytes []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("unable to write %s (%s)", filename, err)
+	}
+
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: bytes}); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write %s (%s)", filename, err)
+	}
+
+	return f.Close()
+}
+
+// isValidListenAddress returns true if the address is an ip address or a host name
+func isValidListenAddress(address string) bool {
+	if address == "" {
+		return false
+	}
+
+	if net.ParseIP(address) != nil {
+		return true
+	}
+
+	return !strings.ContainsAny(address, ":/ \t[]")
+}
+
+// getBrowserHost returns the host the browser on this computer uses to reach the web server
+func getBrowserHost(listen string) string {
+	if ip := net.ParseIP(listen); ip != nil && ip.IsUnspecified() {
+		return "127.0.0.1"
+	}
+
+	return listen
+}
+
+// isLoopback returns true if the address only accepts connections from this computer
+func isLoopback(listen string) bool {
+	if listen == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(listen)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,76 @@
+package fcr
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	folder := t.TempDir()
+	certFile := filepath.Join(folder, selfSignedCertFile)
+	keyFile := filepath.Join(folder, selfSignedKeyFile)
+
+	if err := ensureSelfSignedCertificate(certFile, keyFile, "127.0.0.1"); err != nil {
+		t.Fatalf("unable to generate the certificate (%s)", err)
+	}
+
+	names, addresses := getCertificateHosts("127.0.0.1")
+	if !isCurrentCertificate(certFile, keyFile, names, addresses) {
+		t.Errorf("expected the generated certificate to be current")
+	}
+
+	config := *NewConfig()
+	config.TLSCert = certFile
+	config.TLSKey = keyFile
+
+	if _, err := getTLSConfig(config); err != nil {
+		t.Errorf("unable to load the generated certificate (%s)", err)
+	}
+}
+
+func TestSelfSignedCertificateCoversListenAddress(t *testing.T) {
+	folder := t.TempDir()
+	certFile := filepath.Join(folder, selfSignedCertFile)
+	keyFile := filepath.Join(folder, selfSignedKeyFile)
+
+	if err := ensureSelfSignedCertificate(certFile, keyFile, "0.0.0.0"); err != nil {
+		t.Fatalf("unable to generate the certificate (%s)", err)
+	}
+
+	// the certificate is replaced once the listen address is no longer covered
+	for _, listen := range []string{"192.0.2.10", "garage.example"} {
+		names, addresses := getCertificateHosts(listen)
+		if isCurrentCertificate(certFile, keyFile, names, addresses) {
+			t.Errorf("expected the certificate not to cover %s", listen)
+		}
+
+		if err := ensureSelfSignedCertificate(certFile, keyFile, listen); err != nil {
+			t.Fatalf("unable to regenerate the certificate (%s)", err)
+		}
+
+		if !isCurrentCertificate(certFile, keyFile, names, addresses) {
+			t.Errorf("expected the regenerated certificate to cover %s", listen)
+		}
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	for address, valid := range map[string]bool{
+		"127.0.0.1":     true,
+		"0.0.0.0":       true,
+		"::":            true,
+		"localhost":     true,
+		"laptop.local":  true,
+		"":              false,
+		"127.0.0.1:80":  false,
+		"http://laptop": false,
+	} {
+		if isValidListenAddress(address) != valid {
+			t.Errorf("expected isValidListenAddress(%s) to be %t", address, valid)
+		}
+	}
+
+	if host := getBrowserHost("0.0.0.0"); host != "127.0.0.1" {
+		t.Errorf("expected the browser to use 127.0.0.1, got %s", host)
+	}
+}
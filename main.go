@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/andrewdjackson/rosco"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/andrewdjackson/memsfcr/fcr"
@@ -47,7 +50,10 @@ func init() {
 	Build = currentTime.Format("2006-01-02")
 }
 
-func setupLogging(debug bool) {
+// setupLogging sets up the logging, returns the debug log file if logging to file
+func setupLogging(debug bool) *os.File {
+	var f *os.File
+
 	if debug {
 		// create a log file using the current date and time
 		// this saves trying to roll logs
@@ -58,21 +64,22 @@ func setupLogging(debug bool) {
 		filename := fmt.Sprintf("%s/debug-%s.log", rosco.GetDebugFolder(), dateTime)
 		filename = filepath.FromSlash(filename)
 
-		// write logs to file and console
-		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755)
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Warn("error opening log file")
-		}
-
 		log.SetFormatter(&log.TextFormatter{
 			DisableColors:   true,
 			FullTimestamp:   true,
 			TimestampFormat: "15:04:05.000",
 		})
 
-		multilogwriter := io.MultiWriter(os.Stdout, f)
-		log.SetOutput(multilogwriter)
-		log.Infof("debug logging to %s", filename)
+		// write logs to file and console
+		var err error
+		if f, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755); err != nil {
+			log.SetOutput(os.Stdout)
+			log.WithFields(log.Fields{"error": err}).Warn("error opening log file")
+		} else {
+			multilogwriter := io.MultiWriter(os.Stdout, f)
+			log.SetOutput(multilogwriter)
+			log.Infof("debug logging to %s", filename)
+		}
 	} else {
 		log.SetOutput(os.Stdout)
 
@@ -86,19 +93,128 @@ func setupLogging(debug bool) {
 
 	// disable function logging
 	log.SetReportCaller(false)
+
+	return f
+}
+
+// closeLogging flushes and closes the debug log file
+func closeLogging(f *os.File) {
+	if f == nil {
+		return
+	}
+
+	log.SetOutput(os.Stdout)
+
+	if err := f.Sync(); err != nil {
+		log.Warnf("error flushing log file (%s)", err)
+	}
+
+	_ = f.Close()
+}
+
+// runCapture records from the ecu without starting the web interface
+// and writes a summary of the faults seen, returns the exit code
+func runCapture(reader *fcr.MemsReader, duration time.Duration, samples int) int {
+	summary, err := reader.Capture(duration, samples)
+
+	if !summary.Started.IsZero() {
+		summary.WriteSummary(os.Stdout)
+	}
+
+	if err != nil {
+		log.Errorf("capture failed (%s)", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runServer runs the web interface until the application is asked to shut down
+func runServer(reader *fcr.MemsReader, headless bool) {
+	// start sampling the ecu in the background
+	reader.StartAcquisition()
+	// start the web server
+	reader.StartWebServer()
+
+	if !headless {
+		// open the browser view
+		reader.OpenBrowser()
+	} else {
+		log.Infof("MemsFCR started in headless mode")
+	}
+
+	// wait for a signal or the browser to close
+	<-reader.Done()
+}
+
+// runScenarioCommand runs the scenario subcommands, logging is limited
+// to warnings on stderr so the output can be used in scripts
+func runScenarioCommand(args []string) {
+	log.SetOutput(os.Stderr)
+	log.SetLevel(log.WarnLevel)
+
+	if err := fcr.RunScenarioCommand(args, os.Stdout); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 }
 
 func main() {
 	var debug bool
 	var headless bool
+	var capture bool
+	var duration time.Duration
+	var samples int
+	var port string
+	var serverPort int
+	var frequency int
+	var token string
+	var origins string
+	var listen string
+	var useTLS bool
+	var tlsCert string
+	var tlsKey string
+
+	if len(os.Args) > 1 && os.Args[1] == "scenario" {
+		runScenarioCommand(os.Args[2:])
+		return
+	}
 
-	flag.BoolVar(&debug, "debug", true, "output to a debug file")
+	flag.BoolVar(&debug, "debug", false, "output to a debug file and show the debug values (overrides MEMSFCR_DEBUG)")
+	flag.StringVar(&port, "port", "", "serial port or scenario to connect to (overrides MEMSFCR_PORT)")
+	flag.IntVar(&serverPort, "serverport", 0, "web server port, 0 selects a free port (overrides MEMSFCR_SERVERPORT)")
+	flag.IntVar(&frequency, "frequency", 0, "ecu polling interval in milliseconds (overrides MEMSFCR_FREQUENCY)")
+	flag.StringVar(&token, "token", "", "api token required by the web interface (overrides MEMSFCR_TOKEN)")
+	flag.StringVar(&origins, "origins", "", "comma separated origins allowed to call the api (overrides MEMSFCR_ORIGINS)")
+	flag.StringVar(&listen, "listen", "", "address the web server listens on, 0.0.0.0 allows other devices to connect (overrides MEMSFCR_LISTEN)")
+	flag.BoolVar(&useTLS, "tls", false, "serve the web interface over https (overrides MEMSFCR_TLS)")
+	flag.StringVar(&tlsCert, "tlscert", "", "tls certificate file, a self-signed certificate is used if not set (overrides MEMSFCR_TLSCERT)")
+	flag.StringVar(&tlsKey, "tlskey", "", "tls key file (overrides MEMSFCR_TLSKEY)")
 	flag.BoolVar(&headless, "headless", false, "headless server mode")
+	flag.BoolVar(&capture, "capture", false, "capture dataframes from the ecu to the log folder without the web interface")
+	flag.DurationVar(&duration, "duration", 0, "capture duration, e.g. 8h (default until interrupted)")
+	flag.IntVar(&samples, "samples", 0, "number of dataframes to capture (default unlimited)")
 	flag.Parse()
 
-	// initialise the logging
+	// the config flags set on the command line override the environment and config file
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if fcr.IsConfigKey(f.Name) {
+			overrides[f.Name] = f.Value.String()
+		}
+	})
+	fcr.SetConfigOverrides(overrides)
+
 	fcr.CreateFolders()
-	setupLogging(debug)
+
+	// shut down cleanly on an interrupt or terminate signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// set up and initialise the fault code reader
+	reader := fcr.NewMemsReader(ctx, Version, Build, headless || capture)
+
+	// initialise the logging once the config file, environment and flags have been resolved
+	logfile := setupLogging(reader.Config.Debug)
 
 	log.Infof("MemsFCR Version %s, Build %s", Version, Build)
 	log.Infof("MemsFCR Home Folder %s", rosco.GetHomeFolder())
@@ -106,23 +222,19 @@ func main() {
 	log.Infof("MemsFCR Log Folder %s", rosco.GetLogFolder())
 	log.Infof("MemsFCR Debug Folder %s", rosco.GetDebugFolder())
 
-	// create a channel to notify app to exit
-	exit := make(chan int)
-
-	// set up and initialise the fault code reader
-	reader := fcr.NewMemsReader(Version, Build, headless)
-	// start the web server
-	reader.StartWebServer()
+	code := 0
 
-	if !headless {
-		// open the browser view
-		reader.OpenBrowser()
+	if capture {
+		code = runCapture(reader, duration, samples)
 	} else {
-		log.Infof("MemsFCR started in headless mode")
+		runServer(reader, headless)
 	}
 
-	// wait for exit on the channel
-	for {
-		<-exit
-	}
+	stop()
+	reader.Shutdown()
+
+	log.Infof("MemsFCR exiting")
+	closeLogging(logfile)
+
+	os.Exit(code)
 }
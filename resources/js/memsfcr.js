@@ -323,6 +323,7 @@ class MemsReader {
             dataframe: uri + "/rosco/dataframe",
             adjust: uri + "/rosco/adjust/",
             actuator: uri + "/rosco/test/",
+            actuators: uri + "/rosco/test",
             reset: uri + "/rosco/reset",
             scenario: uri + "/scenario",
             scenario_details: uri + "/scenario/details",
@@ -832,7 +833,7 @@ function updateConfigSettings(data) {
     setECUQueryFrequency(data.Frequency);
     updateECUQueryIntervalLabel(data.Frequency);
 
-    if (data.Debug == "true") {
+    if (data.Debug === true) {
         debug = data.Debug
     } else {
         hideDebugValues()
@@ -886,7 +887,7 @@ function Save() {
         logToFile = LogToFileDisabled;
     }
 
-    var data = { Port: configPort, logFolder: folder, logtofile: logToFile, frequency: ECUQueryInterval.toString() };
+    var data = { Port: configPort, logFolder: folder, logtofile: logToFile, frequency: ECUQueryInterval };
 
     // Create a request variable and assign a new XMLHttpRequest object to it.
     let request = new XMLHttpRequest()
@@ -1115,6 +1116,12 @@ function connected(event) {
     var responseData = JSON.parse(event.target.response)
     console.info("connected to ecu (" + JSON.stringify(responseData) + ")")
 
+    // failed requests return an error with the ecu status
+    if (responseData.code !== undefined) {
+        console.warn("unable to connect to the ecu (" + responseData.message + ")")
+        responseData = responseData.status
+    }
+
     memsreader.status.connected = responseData.Connected
 
     updateConnectMessage()
@@ -1145,6 +1152,9 @@ function connected(event) {
 
         // start the dataframe command loop
         startDataframeLoop();
+
+        // show the actuators the server has left on
+        restoreActuators();
     } else {
         setStatusLED(true, IndicatorECUConnected, LEDFault);
         removeConnectEventListeners()
@@ -1248,6 +1258,17 @@ function dataframeReceived(event) {
     var data = JSON.parse(event.target.response)
     console.debug("dataframe request response " + JSON.stringify(data))
 
+    // failed requests return an error with the ecu status, there is no dataframe
+    // until the first sample after connecting has been read
+    if (event.target.status !== 200 || data.code !== undefined) {
+        if (data.code === "ecu_no_data") {
+            console.info("waiting for the first dataframe (" + data.message + ")")
+        } else {
+            console.warn("unable to read the dataframe (" + data.message + ")")
+        }
+        return
+    }
+
     // if the engine rpm or lambda status are unfeasibly wrong then
     // the data is corrupt
     if (data.LambdaStatus > 1 || data.EngineRPM > 7000) {
@@ -1484,10 +1505,32 @@ function activateActuator(event) {
     }
 }
 
+async function restoreActuators() {
+    try {
+        let response = await fetch(memsreader.uri.actuators);
+        let states = await response.json();
+
+        states.forEach(function (state) {
+            console.info("actuator " + state.actuator + " active " + state.active)
+            // update the toggle without sending the activation again
+            $("#" + state.actuator).bootstrapToggle(state.active ? 'on' : 'off', true)
+        })
+    } catch(err) {
+        console.warn(`unable to read the actuator states (${err})`)
+    }
+}
+
 async function actuatorComplete(event) {
     var response = JSON.parse(event.target.response)
     console.info("actuator response " + JSON.stringify(response))
 
+    // failed requests return an error, the actuator state is unchanged
+    if (response.code !== undefined) {
+        console.warn("actuator test failed (" + response.message + ")")
+        restoreActuators()
+        return
+    }
+
     // if active, sleep for 2 seconds and then deactivate
     if (response.activate) {
         await sleep(2000)
@@ -7,7 +7,7 @@ export class MemsConfig {
         this._debug = false;
         this._version = "0.0.0";
         this._build = "2022-01-01";
-        this._serverPort = "8081";
+        this._serverPort = 8081;
         this._frequency = 0;
     }
 
@@ -65,6 +65,7 @@ export class MemsConfig {
         this._version = data.Version;
         this._build = data.Build;
         this._serverPort = data.ServerPort;
+        this._frequency = data.Frequency;
 
         return data;
     }
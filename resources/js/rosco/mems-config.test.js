@@ -5,7 +5,7 @@ var config = new MemsConfig("http://127.0.0.1:8081");
 
 it('loads config', async () => {
     let response = await config.load();
-    expect(parseInt(response.Frequency)).toBeGreaterThan(100);
+    expect(response.Frequency).toBeGreaterThan(100);
 });
 
 it('loads available ports', async () => {